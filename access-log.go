@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/digital-security-lab/hwl-proxy/utils"
+)
+
+//accessRecord collects the fields of the access log line of a request while it is processed.
+type accessRecord struct {
+	module        string        // "incoming" or "outgoing"
+	target        string        // request target
+	status        int           // status of the final response, 0 if none was sent
+	outcome       string        // "forwarded", "rejected", "failed" if the upstream failed or "tunneled", empty if no response was sent
+	upstream      string        // address of the upstream the request was forwarded to
+	whitelist     string        // "passed", "stripped", "reported" or "rejected" if the whitelist was applied
+	stripped      int           // header fields removed by the whitelist
+	bytesIn       int64         // bytes of the request read from the client
+	bytesOut      int64         // bytes of the response written to the client
+	consumed      int64         // bytes consumed from the client before the request
+	start         time.Time     // request header started
+	headerEnd     time.Time     // request header read
+	connect       time.Duration // waiting for the upstream connection
+	forwardStart  time.Time     // forwarding the request started
+	forwardEnd    time.Time     // request including its body forwarded
+	responseStart time.Time     // final response header received
+}
+
+//accessField is a key and value of an access log line.
+type accessField struct {
+	key   string
+	value interface{}
+}
+
+//logFile is a log file that can be reopened after it was rotated.
+type logFile struct {
+	path  string
+	file  *os.File
+	mutex sync.Mutex
+}
+
+//newAccessLog creates the logger of the configured access log. It returns nil if the access log is disabled.
+func newAccessLog() (*log.Logger, error) {
+	switch proxyConfig.AccessLog {
+	case "off":
+		return nil, nil
+	case "":
+		return openLog("-")
+	}
+	return openLog(proxyConfig.AccessLog)
+}
+
+//openLog creates a logger writing lines without prefix to the file at the path, or to stdout if the path is "-".
+func openLog(path string) (*log.Logger, error) {
+	var out io.Writer = os.Stdout
+	if path != "-" {
+		file := &logFile{path: path}
+		err := file.reopen()
+		if err != nil {
+			return nil, err
+		}
+		out = file
+	}
+	return log.New(out, "", 0), nil
+}
+
+//reopen opens the file at the path anew and closes the previous file, so lines are written to a new file after rotation.
+func (file *logFile) reopen() error {
+	f, err := os.OpenFile(file.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	file.mutex.Lock()
+	previous := file.file
+	file.file = f
+	file.mutex.Unlock()
+	if previous != nil {
+		previous.Close()
+	}
+	return nil
+}
+
+//Write appends data to the current file.
+func (file *logFile) Write(data []byte) (int, error) {
+	file.mutex.Lock()
+	defer file.mutex.Unlock()
+	return file.file.Write(data)
+}
+
+//reopenLog reopens the file of a logger created by openLog, if it writes to a file.
+func reopenLog(logger *log.Logger) {
+	if logger == nil {
+		return
+	}
+	if file, ok := logger.Writer().(*logFile); ok {
+		err := file.reopen()
+		if err != nil {
+			log.Println("Reopening log:", err.Error())
+		}
+	}
+}
+
+//beginRequest records the start of a request whose header is read next from the client.
+func (record *accessRecord) beginRequest(client *utils.BufferedConn) {
+	record.start = time.Now()
+	record.consumed = client.BytesConsumed()
+}
+
+//endRequest records the bytes of the request read from the client so far.
+func (record *accessRecord) endRequest(client *utils.BufferedConn) {
+	record.bytesIn = client.BytesConsumed() - record.consumed
+}
+
+//recordWhitelist records the decision of the whitelist on a request and the number of header fields it stripped,
+//or would have stripped in report-only mode.
+func (record *accessRecord) recordWhitelist(stripped []byte, ok bool, reportOnly bool) {
+	record.stripped = bytes.Count(stripped, []byte("\r\n"))
+	if !ok {
+		record.whitelist = "rejected"
+	} else if record.stripped > 0 && reportOnly {
+		record.whitelist = "reported"
+	} else if record.stripped > 0 {
+		record.whitelist = "stripped"
+	} else {
+		record.whitelist = "passed"
+	}
+}
+
+//writeResponse writes a response generated by the proxy to the client and records its status and size.
+func (record *accessRecord) writeResponse(client *utils.BufferedConn, response []byte) error {
+	if len(record.outcome) == 0 {
+		record.outcome = "rejected"
+	}
+	record.status = utils.GetStatusCode(response)
+	record.bytesOut += int64(len(response))
+	return client.WriteAndFlush(response)
+}
+
+//writeRejection writes the error response of a rejection to the client and records the rejection.
+func (record *accessRecord) writeRejection(client *utils.BufferedConn, r *rejection) error {
+	err := record.writeResponse(client, r.response)
+	r.record()
+	return err
+}
+
+//logAccess writes the access log line of a request once it was answered.
+func logAccess(p *pipeline, ex *exchange) {
+	if reqLog == nil {
+		return
+	}
+	record := &ex.access
+	end := time.Now()
+	fields := []accessField{
+		{"time", record.start.UTC().Format(time.RFC3339Nano)},
+		{"module", record.module},
+		{"client", p.client.Conn.RemoteAddr().String()},
+		{"method", ex.method},
+		{"target", record.target},
+		{"version", ex.version},
+		{"status", record.status},
+		{"bytes_in", record.bytesIn},
+		{"bytes_out", record.bytesOut},
+		{"upstream", record.upstream},
+		{"whitelist", record.whitelist},
+		{"stripped", record.stripped},
+		{"header_time", seconds(record.start, record.headerEnd)},
+		{"connect_time", record.connect.Seconds()},
+		{"body_time", seconds(record.forwardStart, record.forwardEnd)},
+		{"wait_time", seconds(record.forwardEnd, record.responseStart)},
+		{"response_time", seconds(record.responseStart, end)},
+		{"total_time", seconds(record.start, end)},
+	}
+	if proxyConfig.AccessLogFormat == "logfmt" {
+		reqLog.Println(formatLogfmt(fields))
+	} else {
+		reqLog.Println(formatJSON(fields))
+	}
+}
+
+//seconds returns the seconds between two points in time, or 0 if a phase did not take place.
+func seconds(from time.Time, to time.Time) float64 {
+	if from.IsZero() || to.IsZero() || to.Before(from) {
+		return 0
+	}
+	return to.Sub(from).Seconds()
+}
+
+//formatJSON formats the fields as a JSON object, keeping their order.
+func formatJSON(fields []accessField) string {
+	var line strings.Builder
+	line.WriteByte('{')
+	for i, field := range fields {
+		if i > 0 {
+			line.WriteByte(',')
+		}
+		key, _ := json.Marshal(field.key)
+		value, _ := json.Marshal(field.value)
+		line.Write(key)
+		line.WriteByte(':')
+		line.Write(value)
+	}
+	line.WriteByte('}')
+	return line.String()
+}
+
+//formatLogfmt formats the fields as space-separated key=value pairs. Values with spaces, quotes or equal signs are quoted.
+func formatLogfmt(fields []accessField) string {
+	var line strings.Builder
+	for i, field := range fields {
+		if i > 0 {
+			line.WriteByte(' ')
+		}
+		line.WriteString(field.key)
+		line.WriteByte('=')
+		switch value := field.value.(type) {
+		case string:
+			if len(value) == 0 || strings.ContainsAny(value, " =") || strconv.Quote(value) != `"`+value+`"` {
+				value = strconv.Quote(value)
+			}
+			line.WriteString(value)
+		case float64:
+			line.WriteString(strconv.FormatFloat(value, 'f', 6, 64))
+		default:
+			line.WriteString(fmt.Sprint(value))
+		}
+	}
+	return line.String()
+}
+
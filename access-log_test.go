@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/digital-security-lab/hwl-proxy/config"
+	"github.com/digital-security-lab/hwl-proxy/utils"
+)
+
+//captureAccessLog writes the access log to a buffer until the returned function is called.
+func captureAccessLog() (*bytes.Buffer, func()) {
+	previous := reqLog
+	var buf bytes.Buffer
+	reqLog = log.New(&buf, "", 0)
+	return &buf, func() { reqLog = previous }
+}
+
+func TestAccessLogJSON(t *testing.T) {
+	buf, restore := captureAccessLog()
+	defer restore()
+	conns, stop := startProxyTest(t, config.ProxyConfig{Whitelisting: true}, defaultTestWhitelist)
+
+	request := "GET /index?a=1 HTTP/1.1\r\nHost: a\r\nX-Unknown: 1\r\nCookie: b\r\n\r\n"
+	response := utils.CreateResponse(200, "OK", []byte("hello"))
+	go conns.client.Write([]byte(request))
+	expectMessage(t, conns.upstreamBr, `^GET /index\?a=1 HTTP/1.1\r\nHost: a\r\n\r\n$`)
+	go conns.upstream.Write(response)
+	expectMessage(t, conns.clientBr, `^HTTP/1.1 200 OK\r\n`)
+	utils.ReadByContentLength(conns.clientBr, 5)
+	stop()
+
+	var entry map[string]interface{}
+	err := json.Unmarshal(buf.Bytes(), &entry)
+	if err != nil {
+		t.Fatal("Invalid access log line:", buf.String(), err)
+	}
+	expected := map[string]interface{}{
+		"module":    "incoming",
+		"client":    "pipe",
+		"method":    "GET",
+		"target":    "/index?a=1",
+		"version":   "HTTP/1.1",
+		"status":    200.0,
+		"bytes_in":  float64(len(request)),
+		"bytes_out": float64(len(response)),
+		"upstream":  "pipe",
+		"whitelist": "stripped",
+		"stripped":  2.0,
+	}
+	for key, value := range expected {
+		if entry[key] != value {
+			t.Errorf("Unexpected %s: %v, expected %v", key, entry[key], value)
+		}
+	}
+	if total, ok := entry["total_time"].(float64); !ok || total <= 0 {
+		t.Error("Missing total time:", entry["total_time"])
+	}
+}
+
+func TestAccessLogLogfmt(t *testing.T) {
+	buf, restore := captureAccessLog()
+	defer restore()
+	conns, stop := startProxyTest(t, config.ProxyConfig{AccessLogFormat: "logfmt"}, defaultTestWhitelist)
+
+	go conns.client.Write([]byte("POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 1\r\nContent-Length: 2\r\n\r\n"))
+	expectMessage(t, conns.clientBr, `^HTTP/1.1 400 Bad Request\r\n`)
+	stop()
+
+	pattern := `^time=\S+ module=incoming client=pipe method=POST target=/ version=HTTP/1.1 status=400 bytes_in=\d+ bytes_out=\d+ ` +
+		`upstream="" whitelist="" stripped=0 header_time=[\d.]+ connect_time=0.000000 body_time=0.000000 wait_time=0.000000 response_time=0.000000 total_time=[\d.]+\n$`
+	if !regexp.MustCompile(pattern).Match(buf.Bytes()) {
+		t.Error("Unexpected access log line:", buf.String())
+	}
+}
+
+func TestAccessLogReopen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "access-log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "access.log")
+	previous := proxyConfig
+	defer func() { proxyConfig = previous }()
+	proxyConfig = config.ProxyConfig{AccessLog: path}
+	logger, err := newAccessLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logger.Writer().(*logFile).file.Close()
+
+	// the log is rotated by renaming the file before the proxy reopens it
+	logger.Println("first")
+	os.Rename(path, path+".1")
+	logger.Println("second")
+	logger.Writer().(*logFile).reopen()
+	logger.Println("third")
+
+	rotated, _ := ioutil.ReadFile(path + ".1")
+	current, _ := ioutil.ReadFile(path)
+	if string(rotated) != "first\nsecond\n" || string(current) != "third\n" {
+		t.Errorf("Unexpected files after rotation: %q, %q", rotated, current)
+	}
+}
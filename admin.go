@@ -0,0 +1,270 @@
+package main
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/digital-security-lab/hwl-proxy/session"
+)
+
+// number of rejections kept for the admin API
+const recentRejectionCount = 100
+
+// requests of the admin API to drain the connections and shut down
+var drainRequests = make(chan bool, 1)
+
+// most recent rejections, listed by the admin API
+var recentRejections = &rejectionLog{}
+
+//rejectionRecord is a rejection listed by the admin API.
+type rejectionRecord struct {
+	Time          time.Time    `json:"time"`
+	Reason        rejectReason `json:"reason"`
+	Status        int          `json:"status"`
+	Detail        string       `json:"detail,omitempty"`
+	CorrelationID string       `json:"correlationId,omitempty"`
+}
+
+//rejectionLog keeps the most recent rejections in a ring buffer.
+type rejectionLog struct {
+	records []rejectionRecord
+	next    int // index of the oldest record once the buffer is full
+	mutex   sync.Mutex
+}
+
+//adminStatus is the status reported by the admin API.
+type adminStatus struct {
+	ActiveConnections int          `json:"activeConnections"`
+	IdleConnections   int          `json:"idleConnections"`
+	Sessions          int          `json:"sessions"`
+	ReportOnly        bool         `json:"reportOnly"`
+	Draining          bool         `json:"draining"`
+	Config            fileVersion  `json:"config"`
+	Whitelist         fileVersion  `json:"whitelist"`
+	TrailerWhitelist  *fileVersion `json:"trailerWhitelist,omitempty"`
+}
+
+//adminServer serves the metrics and the admin API on the admin listener, with TLS if configured.
+func adminServer() {
+	endpoint, err := proxyConfig.AdminEndpoint()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	server, err := listen(endpoint)
+	log.Println("Start admin server:", endpoint)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	if len(proxyConfig.AdminTLS.CertFile) > 0 {
+		tlsConfig, err := adminTLSConfig()
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		server = tls.NewListener(server, tlsConfig)
+	}
+	if !adminAPIEnabled() {
+		log.Println("Admin API disabled, it requires adminToken or adminTLS.clientCAFile")
+	}
+	err = http.Serve(server, adminHandler())
+	log.Println("Admin server:", err.Error())
+}
+
+//adminTLSConfig returns the TLS configuration of the admin listener.
+//If a client CA is configured, clients must present a certificate issued by it.
+func adminTLSConfig() (*tls.Config, error) {
+	options := proxyConfig.AdminTLS
+	cert, err := tls.LoadX509KeyPair(options.CertFile, options.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if len(options.ClientCAFile) > 0 {
+		data, err := ioutil.ReadFile(options.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, errors.New("no certificates in adminTLS clientCAFile")
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsConfig, nil
+}
+
+//adminAPIEnabled checks whether the admin API is protected by a token or client certificates, as it is not served otherwise.
+func adminAPIEnabled() bool {
+	return len(proxyConfig.AdminToken) > 0 || len(proxyConfig.AdminTLS.ClientCAFile) > 0
+}
+
+//adminHandler returns the handler of the admin listener, which requires the admin token if configured.
+func adminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metricsRegistry.Handler())
+	if adminAPIEnabled() {
+		mux.HandleFunc("/api/status", adminMethod("GET", handleStatus))
+		mux.HandleFunc("/api/config", adminMethod("GET", handleConfig))
+		mux.HandleFunc("/api/whitelist", adminMethod("GET", handleWhitelist))
+		mux.HandleFunc("/api/rejections", adminMethod("GET", handleRejections))
+		mux.HandleFunc("/api/reload", adminMethod("POST", handleReload))
+		mux.HandleFunc("/api/report-only", adminMethod("POST", handleReportOnly))
+		mux.HandleFunc("/api/drain", adminMethod("POST", handleDrain))
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := proxyConfig.AdminToken
+		if len(token) > 0 && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="hwl-proxy"`)
+			writeAdminError(w, http.StatusUnauthorized, "invalid admin token")
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+//adminMethod restricts a handler of the admin API to a method.
+func adminMethod(method string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			writeAdminError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		handler(w, r)
+	}
+}
+
+//handleStatus reports the connections, sessions, modes and versions of the loaded files.
+func handleStatus(w http.ResponseWriter, r *http.Request) {
+	status := adminStatus{
+		Sessions:   session.Count(),
+		ReportOnly: isReportOnly(),
+		Draining:   clients.isClosing(),
+		Config:     configVersion,
+	}
+	status.ActiveConnections, status.IdleConnections = clients.count()
+	status.Whitelist, status.TrailerWhitelist = whitelistVersions()
+	writeAdminJSON(w, http.StatusOK, status)
+}
+
+//handleConfig reports the configuration in use with its version. Credentials are redacted.
+func handleConfig(w http.ResponseWriter, r *http.Request) {
+	redacted := proxyConfig
+	redacted.ProxyCredentials = redactValues(proxyConfig.ProxyCredentials)
+	if len(redacted.AdminToken) > 0 {
+		redacted.AdminToken = "[REDACTED]"
+	}
+	if len(proxyConfig.Tracing.Headers) > 0 {
+		redacted.Tracing.Headers = make(map[string]string)
+		for name := range proxyConfig.Tracing.Headers {
+			redacted.Tracing.Headers[name] = "[REDACTED]"
+		}
+	}
+	writeAdminJSON(w, http.StatusOK, map[string]interface{}{"version": configVersion, "config": redacted})
+}
+
+//handleWhitelist reports the whitelists in use with their versions.
+func handleWhitelist(w http.ResponseWriter, r *http.Request) {
+	whitelistMutex.RLock()
+	defer whitelistMutex.RUnlock()
+	response := map[string]interface{}{"version": whitelistVersion, "whitelist": whitelist}
+	if len(trailerWhitelistVersion.Path) > 0 {
+		response["trailerVersion"] = trailerWhitelistVersion
+		response["trailerWhitelist"] = trailerWhitelist
+	}
+	writeAdminJSON(w, http.StatusOK, response)
+}
+
+//handleRejections lists the most recent rejections, the newest first.
+func handleRejections(w http.ResponseWriter, r *http.Request) {
+	writeAdminJSON(w, http.StatusOK, recentRejections.list())
+}
+
+//handleReload loads the whitelists anew. A changed configuration file is reported, as it requires a restart.
+func handleReload(w http.ResponseWriter, r *http.Request) {
+	result, err := reload()
+	if err != nil {
+		log.Println("Reload:", err.Error())
+		writeAdminError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	log.Println("Reloaded whitelist version", result.Whitelist.Version)
+	writeAdminJSON(w, http.StatusOK, result)
+}
+
+//handleReportOnly enables or disables the report-only mode with a JSON body like {"enabled": true}.
+func handleReportOnly(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Enabled *bool `json:"enabled"`
+	}
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1024)).Decode(&request)
+	if err != nil || request.Enabled == nil {
+		writeAdminError(w, http.StatusBadRequest, `expected {"enabled": true|false}`)
+		return
+	}
+	setReportOnly(*request.Enabled)
+	log.Println("Report-only mode enabled:", *request.Enabled)
+	writeAdminJSON(w, http.StatusOK, map[string]bool{"reportOnly": *request.Enabled})
+}
+
+//handleDrain starts draining the connections as on SIGTERM, after which the proxy exits.
+func handleDrain(w http.ResponseWriter, r *http.Request) {
+	select {
+	case drainRequests <- true:
+	default:
+		// a drain was already requested
+	}
+	writeAdminJSON(w, http.StatusAccepted, map[string]bool{"draining": true})
+}
+
+//writeAdminJSON writes a JSON response of the admin API.
+func writeAdminJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+//writeAdminError writes an error response of the admin API.
+func writeAdminError(w http.ResponseWriter, status int, message string) {
+	writeAdminJSON(w, status, map[string]string{"error": message})
+}
+
+//redactValues replaces each value with a placeholder.
+func redactValues(values []string) []string {
+	var redacted []string
+	for range values {
+		redacted = append(redacted, "[REDACTED]")
+	}
+	return redacted
+}
+
+//add records a rejection, replacing the oldest one if the buffer is full.
+func (l *rejectionLog) add(record rejectionRecord) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if len(l.records) < recentRejectionCount {
+		l.records = append(l.records, record)
+		return
+	}
+	l.records[l.next] = record
+	l.next = (l.next + 1) % recentRejectionCount
+}
+
+//list returns the recorded rejections, the newest first.
+func (l *rejectionLog) list() []rejectionRecord {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	records := make([]rejectionRecord, 0, len(l.records))
+	for i := len(l.records) - 1; i >= 0; i-- {
+		records = append(records, l.records[(l.next+i)%len(l.records)])
+	}
+	return records
+}
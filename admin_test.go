@@ -0,0 +1,322 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/digital-security-lab/hwl-proxy/config"
+	"github.com/digital-security-lab/hwl-proxy/utils"
+)
+
+//setAdminConfig replaces the configuration for a test of the admin API until the returned function is called.
+func setAdminConfig(testConfig config.ProxyConfig) func() {
+	previous := proxyConfig
+	proxyConfig = testConfig
+	return func() { proxyConfig = previous }
+}
+
+//adminRequest sends a request to the admin handler with the token and decodes the JSON response into value.
+func adminRequest(t *testing.T, method string, path string, body string, token string, value interface{}) int {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if len(token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	recorder := httptest.NewRecorder()
+	adminHandler().ServeHTTP(recorder, req)
+	if value != nil {
+		err := json.Unmarshal(recorder.Body.Bytes(), value)
+		if err != nil {
+			t.Fatal("Invalid admin response:", recorder.Body.String(), err)
+		}
+	}
+	return recorder.Code
+}
+
+func TestAdminToken(t *testing.T) {
+	defer setAdminConfig(config.ProxyConfig{AdminToken: "secret"})()
+
+	if status := adminRequest(t, "GET", "/api/status", "", "", nil); status != http.StatusUnauthorized {
+		t.Error("Request without token accepted:", status)
+	}
+	if status := adminRequest(t, "GET", "/metrics", "", "wrong", nil); status != http.StatusUnauthorized {
+		t.Error("Request with wrong token accepted:", status)
+	}
+	var status adminStatus
+	if code := adminRequest(t, "GET", "/api/status", "", "secret", &status); code != http.StatusOK {
+		t.Error("Request with token rejected:", code)
+	}
+	if code := adminRequest(t, "POST", "/api/status", "", "secret", nil); code != http.StatusMethodNotAllowed {
+		t.Error("Unexpected method accepted:", code)
+	}
+}
+
+func TestAdminAPIDisabled(t *testing.T) {
+	defer setAdminConfig(config.ProxyConfig{})()
+
+	if status := adminRequest(t, "GET", "/api/status", "", "", nil); status != http.StatusNotFound {
+		t.Error("Unprotected admin API served:", status)
+	}
+	if status := adminRequest(t, "GET", "/metrics", "", "", nil); status != http.StatusOK {
+		t.Error("Metrics not served:", status)
+	}
+}
+
+func TestAdminStatus(t *testing.T) {
+	defer setAdminConfig(config.ProxyConfig{AdminToken: "secret"})()
+	active, peer := net.Pipe()
+	defer peer.Close()
+	idle, idlePeer := net.Pipe()
+	defer idlePeer.Close()
+	clients.add(active)
+	defer clients.remove(active)
+	clients.add(idle)
+	defer clients.remove(idle)
+	clients.setIdle(idle, true)
+
+	var status adminStatus
+	adminRequest(t, "GET", "/api/status", "", "secret", &status)
+	if status.ActiveConnections < 1 || status.IdleConnections < 1 || status.Draining || status.ReportOnly {
+		t.Error("Unexpected status:", status)
+	}
+}
+
+func TestAdminConfigRedacted(t *testing.T) {
+	defer setAdminConfig(config.ProxyConfig{
+		AdminToken:       "secret",
+		ProxyCredentials: []string{"user:password"},
+		Tracing:          config.Tracing{Endpoint: "http://127.0.0.1:4318/v1/traces", Headers: map[string]string{"Authorization": "Bearer collector"}},
+	})()
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/api/config", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	adminHandler().ServeHTTP(recorder, req)
+	body := recorder.Body.String()
+	if recorder.Code != http.StatusOK || !strings.Contains(body, `"Endpoint":"http://127.0.0.1:4318/v1/traces"`) {
+		t.Fatal("Unexpected config:", body)
+	}
+	for _, secret := range []string{"user:password", `"secret"`, "Bearer collector"} {
+		if strings.Contains(body, secret) {
+			t.Error("Secret not redacted:", secret)
+		}
+	}
+	if proxyConfig.ProxyCredentials[0] != "user:password" || proxyConfig.Tracing.Headers["Authorization"] != "Bearer collector" {
+		t.Error("Configuration in use modified")
+	}
+}
+
+func TestAdminReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "admin-reload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	previousFiles := []string{configFile, whitelistFile, trailerWhitelistFile}
+	previousWhitelist, previousVersion, previousConfigVersion := whitelist, whitelistVersion, configVersion
+	defer func() {
+		configFile, whitelistFile, trailerWhitelistFile = previousFiles[0], previousFiles[1], previousFiles[2]
+		whitelist, whitelistVersion, configVersion = previousWhitelist, previousVersion, previousConfigVersion
+	}()
+	defer setAdminConfig(proxyConfig)()
+
+	configFile = filepath.Join(dir, "config.json")
+	whitelistFile = filepath.Join(dir, "whitelist.json")
+	trailerWhitelistFile = ""
+	whitelistVersion = fileVersion{}
+	ioutil.WriteFile(configFile, []byte(`{"incomingAddress": ":80", "origin": true, "adminToken": "secret"}`), 0644)
+	ioutil.WriteFile(whitelistFile, []byte(`[{"key": "host"}]`), 0644)
+	if err := loadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadWhitelists(); err != nil {
+		t.Fatal(err)
+	}
+
+	ioutil.WriteFile(whitelistFile, []byte(`[{"key": "host"}, {"key": "accept"}]`), 0644)
+	var result reloadResult
+	if status := adminRequest(t, "POST", "/api/reload", "", "secret", &result); status != http.StatusOK {
+		t.Fatal("Reload failed:", status)
+	}
+	wl := currentWhitelist()
+	if result.Whitelist.Version != 2 || result.Config.Version != 1 || result.ConfigChanged || len(wl) != 2 || !wl.Contains("accept") {
+		t.Error("Whitelist not reloaded:", result, wl)
+	}
+
+	// invalid files are not loaded, a changed configuration is reported
+	ioutil.WriteFile(whitelistFile, []byte(`[{"key": `), 0644)
+	if status := adminRequest(t, "POST", "/api/reload", "", "secret", nil); status != http.StatusUnprocessableEntity {
+		t.Error("Invalid whitelist reloaded:", status)
+	}
+	if len(currentWhitelist()) != 2 {
+		t.Error("Whitelist replaced by invalid file")
+	}
+	ioutil.WriteFile(whitelistFile, []byte(`[{"key": "host"}, {"key": "accept"}]`), 0644)
+	ioutil.WriteFile(configFile, []byte(`{"incomingAddress": ":8080", "origin": true, "adminToken": "secret"}`), 0644)
+	adminRequest(t, "POST", "/api/reload", "", "secret", &result)
+	if !result.ConfigChanged || result.Whitelist.Version != 2 || proxyConfig.IncomingAddress != ":80" {
+		t.Error("Changed configuration not reported:", result)
+	}
+}
+
+func TestAdminReportOnly(t *testing.T) {
+	defer setReportOnly(false)
+	defer setAdminConfig(config.ProxyConfig{AdminToken: "secret"})()
+
+	var state map[string]bool
+	if status := adminRequest(t, "POST", "/api/report-only", `{"enabled": true}`, "secret", &state); status != http.StatusOK || !state["reportOnly"] {
+		t.Fatal("Report-only mode not enabled:", status, state)
+	}
+	if status := adminRequest(t, "POST", "/api/report-only", `{}`, "secret", nil); status != http.StatusBadRequest {
+		t.Error("Request without state accepted:", status)
+	}
+	buf, restore := captureAccessLog()
+	defer restore()
+	conns, stop := startProxyTest(t, config.ProxyConfig{Whitelisting: true}, defaultTestWhitelist)
+
+	// header fields the whitelist would remove are forwarded
+	go conns.client.Write([]byte("GET / HTTP/1.1\r\nHost: a\r\nX-Unknown: 1\r\n\r\n"))
+	expectMessage(t, conns.upstreamBr, `^GET / HTTP/1.1\r\nHost: a\r\nX-Unknown: 1\r\n\r\n$`)
+	go conns.upstream.Write(utils.CreateResponse(200, "OK", nil))
+	expectMessage(t, conns.clientBr, `^HTTP/1.1 200 OK\r\n`)
+	stop()
+	if !strings.Contains(buf.String(), `"whitelist":"reported","stripped":1`) {
+		t.Error("Removal not reported:", buf.String())
+	}
+}
+
+func TestAdminRejections(t *testing.T) {
+	defer setAdminConfig(config.ProxyConfig{AdminToken: "secret"})()
+	errorResponse(reasonHeaderTooLarge, "too many header fields").record()
+	errorResponse(reasonMethodNotAllowed, "").record()
+
+	var rejections []rejectionRecord
+	adminRequest(t, "GET", "/api/rejections", "", "secret", &rejections)
+	if len(rejections) < 2 || rejections[0].Reason != reasonMethodNotAllowed || rejections[0].Status != 405 ||
+		rejections[1].Reason != reasonHeaderTooLarge || rejections[1].Detail != "too many header fields" {
+		t.Error("Unexpected rejections:", rejections)
+	}
+}
+
+func TestRejectionLog(t *testing.T) {
+	rejections := &rejectionLog{}
+	for i := 0; i < recentRejectionCount+5; i++ {
+		rejections.add(rejectionRecord{Status: i})
+	}
+	records := rejections.list()
+	if len(records) != recentRejectionCount || records[0].Status != recentRejectionCount+4 || records[len(records)-1].Status != 5 {
+		t.Error("Unexpected records:", len(records), records[0], records[len(records)-1])
+	}
+}
+
+func TestAdminDrain(t *testing.T) {
+	defer setAdminConfig(config.ProxyConfig{AdminToken: "secret"})()
+	if status := adminRequest(t, "GET", "/api/drain", "", "secret", nil); status != http.StatusMethodNotAllowed {
+		t.Error("Drain without POST accepted:", status)
+	}
+	if status := adminRequest(t, "POST", "/api/drain", "", "secret", nil); status != http.StatusAccepted {
+		t.Error("Drain not accepted:", status)
+	}
+	select {
+	case <-drainRequests:
+	default:
+		t.Error("Drain not requested")
+	}
+}
+
+//writeCertificate creates a certificate signed by the parent, or a self-signed CA certificate if parent is nil,
+//and writes it and its key as PEM files.
+func writeCertificate(t *testing.T, dir string, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	ioutil.WriteFile(filepath.Join(dir, name+".pem"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+	ioutil.WriteFile(filepath.Join(dir, name+"-key.pem"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	cert, _ := x509.ParseCertificate(der)
+	return cert, key
+}
+
+func TestAdminClientCertificates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "admin-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ca, caKey := writeCertificate(t, dir, "ca", nil, nil)
+	writeCertificate(t, dir, "server", ca, caKey)
+	writeCertificate(t, dir, "client", ca, caKey)
+	defer setAdminConfig(config.ProxyConfig{AdminTLS: config.AdminTLS{
+		CertFile:     filepath.Join(dir, "server.pem"),
+		KeyFile:      filepath.Join(dir, "server-key.pem"),
+		ClientCAFile: filepath.Join(dir, "ca.pem"),
+	}})()
+
+	tlsConfig, err := adminTLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewUnstartedServer(adminHandler())
+	server.TLS = tlsConfig
+	server.StartTLS()
+	defer server.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	clientTLS := &tls.Config{RootCAs: roots}
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientTLS}}
+	if resp, err := client.Get(server.URL + "/api/status"); err == nil {
+		resp.Body.Close()
+		t.Error("Client without certificate accepted")
+	}
+
+	cert, err := tls.LoadX509KeyPair(filepath.Join(dir, "client.pem"), filepath.Join(dir, "client-key.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientTLS.Certificates = []tls.Certificate{cert}
+	client = &http.Client{Transport: &http.Transport{TLSClientConfig: clientTLS}}
+	resp, err := client.Get(server.URL + "/api/status")
+	if err != nil {
+		t.Fatal("Client with certificate rejected:", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Error("Unexpected status:", resp.StatusCode)
+	}
+}
+
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/digital-security-lab/hwl-proxy/utils"
+	"github.com/digital-security-lab/hwl-proxy/whitelisting"
+)
+
+// audit log of header fields removed by the whitelist, nil if not configured
+var auditLog *log.Logger
+
+// header fields whose values are redacted if AuditRedact is not set
+var defaultAuditRedact = []string{"Authorization", "Proxy-Authorization", "Cookie"}
+
+//auditEntry is the audit log line of a request from which the whitelist removed header fields.
+type auditEntry struct {
+	Time    string        `json:"time"`
+	Client  string        `json:"client"`
+	Method  string        `json:"method"`
+	Target  string        `json:"target"`
+	Outcome string        `json:"outcome"`          // "stripped" if the request was forwarded, "reported" in report-only mode, otherwise "rejected"
+	Reason  rejectReason  `json:"reason,omitempty"` // reason code of a rejected request
+	Headers []auditHeader `json:"headers"`
+}
+
+//auditHeader is a header field removed by the whitelist.
+type auditHeader struct {
+	Name   string     `json:"name"`
+	Value  string     `json:"value,omitempty"` // only logged with AuditValues
+	Reason string     `json:"reason"`          // why the field was removed, see whitelisting.Removal
+	Rule   *auditRule `json:"rule,omitempty"`  // whitelist item with the field name as key that failed to match
+}
+
+//auditRule identifies a whitelist item.
+type auditRule struct {
+	Index int    `json:"index"`
+	Key   string `json:"key"`
+	Val   string `json:"val,omitempty"`
+}
+
+//newAuditLog creates the logger of the configured audit log. It returns nil if no audit log is configured.
+func newAuditLog() (*log.Logger, error) {
+	if len(proxyConfig.AuditLog) == 0 {
+		return nil, nil
+	}
+	return openLog(proxyConfig.AuditLog)
+}
+
+//explainWhitelist returns the header fields of a request that the whitelist removes, if the audit log is enabled.
+func explainWhitelist(wl whitelisting.Whitelist, data []byte) []whitelisting.Removal {
+	if auditLog == nil {
+		return nil
+	}
+	removals, _ := wl.Explain(data)
+	return removals
+}
+
+//logAudit writes the audit log line of a request from which the whitelist removed header fields.
+//The reason is set if the request was rejected.
+func logAudit(p *pipeline, ex *exchange, wl whitelisting.Whitelist, removals []whitelisting.Removal, reason rejectReason) {
+	if auditLog == nil || len(removals) == 0 {
+		return
+	}
+	entry := auditEntry{
+		Time:    ex.access.start.UTC().Format(time.RFC3339Nano),
+		Client:  p.client.Conn.RemoteAddr().String(),
+		Method:  ex.method,
+		Target:  ex.access.target,
+		Outcome: "stripped",
+		Reason:  reason,
+	}
+	if len(reason) > 0 {
+		entry.Outcome = "rejected"
+	} else if ex.access.whitelist == "reported" {
+		entry.Outcome = "reported"
+	}
+	for _, removal := range removals {
+		name := utils.GetHeaderFieldName(removal.Line)
+		header := auditHeader{Name: string(name), Reason: removal.Reason}
+		if removal.Rule >= 0 {
+			item := wl[removal.Rule]
+			header.Rule = &auditRule{Index: removal.Rule, Key: item.Key, Val: item.Val}
+		}
+		if proxyConfig.AuditValues {
+			header.Value = auditValue(wl, removal, name)
+		}
+		entry.Headers = append(entry.Headers, header)
+	}
+	line, err := json.Marshal(entry)
+	if err == nil {
+		auditLog.Println(string(line))
+	}
+}
+
+//auditValue returns the value of a removed header field line, or a placeholder if the value is redacted.
+//Values are redacted if the field name is in AuditRedact or the whitelist item with the field name as key requires it.
+//Lines without a field name are always redacted, as it is unknown whose value they contain.
+func auditValue(wl whitelisting.Whitelist, removal whitelisting.Removal, name []byte) string {
+	if name == nil {
+		return "[REDACTED]"
+	}
+	redact := proxyConfig.AuditRedact
+	if redact == nil {
+		redact = defaultAuditRedact
+	}
+	trimmed := strings.TrimSpace(string(name))
+	for _, redacted := range redact {
+		if strings.EqualFold(trimmed, redacted) {
+			return "[REDACTED]"
+		}
+	}
+	if removal.Rule >= 0 && wl[removal.Rule].Redact {
+		return "[REDACTED]"
+	}
+	return string(bytes.TrimSpace(removal.Line[len(name)+1:]))
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"testing"
+
+	"github.com/digital-security-lab/hwl-proxy/config"
+	"github.com/digital-security-lab/hwl-proxy/utils"
+	"github.com/digital-security-lab/hwl-proxy/whitelisting"
+)
+
+// whitelist of the audit tests
+var auditTestWhitelist = whitelisting.Whitelist{
+	whitelisting.WhitelistItem{Key: "host"},
+	whitelisting.WhitelistItem{Key: "x-api-key", Val: `[0-9]+`, Redact: true},
+}
+
+//captureAuditLog writes the audit log to a buffer until the returned function is called.
+func captureAuditLog() (*bytes.Buffer, func()) {
+	previous := auditLog
+	var buf bytes.Buffer
+	auditLog = log.New(&buf, "", 0)
+	return &buf, func() { auditLog = previous }
+}
+
+func TestAuditStrippedHeaders(t *testing.T) {
+	buf, restore := captureAuditLog()
+	defer restore()
+	conns, stop := startProxyTest(t, config.ProxyConfig{Whitelisting: true, AuditValues: true}, auditTestWhitelist)
+
+	go conns.client.Write([]byte("GET /a HTTP/1.1\r\nHost: a\r\nAuthorization: Bearer secret\r\nX-Api-Key: secret\r\nX-Debug: 1\r\n\r\n"))
+	expectMessage(t, conns.upstreamBr, `^GET /a HTTP/1.1\r\nHost: a\r\n\r\n$`)
+	go conns.upstream.Write(utils.CreateResponse(200, "OK", nil))
+	expectMessage(t, conns.clientBr, `^HTTP/1.1 200 OK\r\n`)
+	stop()
+
+	var entry auditEntry
+	err := json.Unmarshal(buf.Bytes(), &entry)
+	if err != nil {
+		t.Fatal("Invalid audit log line:", buf.String(), err)
+	}
+	if entry.Outcome != "stripped" || entry.Reason != "" || entry.Method != "GET" || entry.Target != "/a" || len(entry.Headers) != 3 {
+		t.Fatal("Unexpected audit log line:", buf.String())
+	}
+	expected := []auditHeader{
+		{Name: "Authorization", Value: "[REDACTED]", Reason: whitelisting.ReasonNotWhitelisted},
+		{Name: "X-Api-Key", Value: "[REDACTED]", Reason: whitelisting.ReasonValueMismatch, Rule: &auditRule{Index: 1, Key: "x-api-key", Val: `[0-9]+`}},
+		{Name: "X-Debug", Value: "1", Reason: whitelisting.ReasonNotWhitelisted},
+	}
+	for i, header := range entry.Headers {
+		if header.Name != expected[i].Name || header.Value != expected[i].Value || header.Reason != expected[i].Reason ||
+			(header.Rule == nil) != (expected[i].Rule == nil) || (header.Rule != nil && *header.Rule != *expected[i].Rule) {
+			t.Errorf("Unexpected header %+v, expected %+v", header, expected[i])
+		}
+	}
+	if bytes.Contains(buf.Bytes(), []byte("secret")) {
+		t.Error("Secret value logged:", buf.String())
+	}
+}
+
+func TestAuditRejectedRequest(t *testing.T) {
+	buf, restore := captureAuditLog()
+	defer restore()
+	conns, stop := startProxyTest(t, config.ProxyConfig{Whitelisting: true, AuditValues: true}, auditTestWhitelist)
+
+	go conns.client.Write([]byte("GET / HTTP/1.1\r\nHost: a\r\nX-Bad : 1\r\n\r\n"))
+	expectMessage(t, conns.clientBr, `^HTTP/1.1 400 Bad Request\r\n`)
+	stop()
+
+	var entry auditEntry
+	err := json.Unmarshal(buf.Bytes(), &entry)
+	if err != nil || entry.Outcome != "rejected" || entry.Reason != reasonHeaderRejected ||
+		len(entry.Headers) != 1 || entry.Headers[0].Reason != whitelisting.ReasonInvalidSyntax {
+		t.Error("Unexpected audit log line:", buf.String(), err)
+	}
+}
+
+func TestAuditLineWithoutName(t *testing.T) {
+	buf, restore := captureAuditLog()
+	defer restore()
+	conns, stop := startProxyTest(t, config.ProxyConfig{Whitelisting: true, AuditValues: true}, auditTestWhitelist)
+
+	go conns.client.Write([]byte("GET / HTTP/1.1\r\nHost: a\r\nAuthorization Bearer secret\r\n\r\n"))
+	expectMessage(t, conns.clientBr, `^HTTP/1.1 400 Bad Request\r\n`)
+	stop()
+
+	var entry auditEntry
+	err := json.Unmarshal(buf.Bytes(), &entry)
+	if err != nil || len(entry.Headers) != 1 || entry.Headers[0].Value != "[REDACTED]" || bytes.Contains(buf.Bytes(), []byte("secret")) {
+		t.Error("Unexpected audit log line:", buf.String(), err)
+	}
+}
@@ -0,0 +1,220 @@
+package balancer
+
+import (
+	"errors"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/digital-security-lab/hwl-proxy/config"
+)
+
+// virtual nodes per weight unit on the consistent hash ring
+const ringReplicas = 100
+
+var ErrNoHealthyUpstream = errors.New("no healthy upstream")
+
+//Options configures the selection of targets and their passive health checks.
+type Options struct {
+	Policy    string                    // "round-robin", "least-connections" or "consistent-hash"
+	MaxFails  int                       // consecutive failures after which a target is ejected, never if 0
+	EjectTime time.Duration             // time an ejected target is not selected, unless an active health check succeeds
+	Load      func(config.Endpoint) int // number of active connections to an endpoint, used by least-connections
+}
+
+//Balancer selects one of several weighted upstream endpoints for a connection or request.
+//Targets failing active health checks or ejected after consecutive failures are not selected.
+type Balancer struct {
+	options Options
+	targets []*target
+	ring    []ringPoint
+	mutex   sync.Mutex
+}
+
+//target holds the state of an upstream endpoint.
+type target struct {
+	endpoint     config.Endpoint
+	weight       int
+	current      int       // current weight of the smooth weighted round-robin
+	healthy      bool      // result of the last active health check
+	failures     int       // consecutive failed requests
+	ejectedUntil time.Time // end of the ejection after MaxFails failures
+}
+
+//ringPoint is a virtual node of a target on the consistent hash ring.
+type ringPoint struct {
+	hash   uint32
+	target *target
+}
+
+//New creates a balancer for the endpoints with the given weights. All targets are healthy initially.
+func New(endpoints []config.Endpoint, weights []int, options Options) *Balancer {
+	b := &Balancer{options: options}
+	for i, endpoint := range endpoints {
+		t := &target{endpoint: endpoint, weight: weights[i], healthy: true}
+		b.targets = append(b.targets, t)
+		for j := 0; j < t.weight*ringReplicas; j++ {
+			b.ring = append(b.ring, ringPoint{hash: hash(endpoint.String() + "#" + strconv.Itoa(j)), target: t})
+		}
+	}
+	sort.Slice(b.ring, func(i, j int) bool { return b.ring[i].hash < b.ring[j].hash })
+	return b
+}
+
+//HashesRequests checks whether each request is assigned to a target by its key.
+//Otherwise, a target is selected for each upstream connection.
+func (b *Balancer) HashesRequests() bool {
+	return b.options.Policy == "consistent-hash"
+}
+
+//Pick selects an available target. The key is only used for consistent hashing.
+func (b *Balancer) Pick(key string) (config.Endpoint, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	now := time.Now()
+	var selected *target
+	switch b.options.Policy {
+	case "consistent-hash":
+		selected = b.pickHash(key, now)
+	case "least-connections":
+		selected = b.pickLeastConnections(now)
+	default:
+		selected = b.pickRoundRobin(now)
+	}
+	if selected == nil {
+		return config.Endpoint{}, ErrNoHealthyUpstream
+	}
+	return selected.endpoint, nil
+}
+
+//IsAvailable checks whether the endpoint is a target that may be selected.
+func (b *Balancer) IsAvailable(endpoint config.Endpoint) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	t := b.find(endpoint)
+	return t != nil && t.available(time.Now())
+}
+
+//Report records the result of a request to the endpoint. After MaxFails consecutive failures, the target is ejected.
+func (b *Balancer) Report(endpoint config.Endpoint, ok bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	t := b.find(endpoint)
+	if t == nil {
+		return
+	}
+	if ok {
+		t.failures = 0
+		return
+	}
+	t.failures++
+	if b.options.MaxFails > 0 && t.failures >= b.options.MaxFails {
+		t.ejectedUntil = time.Now().Add(b.options.EjectTime)
+		t.failures = 0
+	}
+}
+
+//SetHealthy records the result of an active health check. A successful check ends an ejection.
+func (b *Balancer) SetHealthy(endpoint config.Endpoint, healthy bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	t := b.find(endpoint)
+	if t == nil {
+		return
+	}
+	t.healthy = healthy
+	if healthy {
+		t.failures = 0
+		t.ejectedUntil = time.Time{}
+	}
+}
+
+//Endpoints returns the endpoints of all targets.
+func (b *Balancer) Endpoints() []config.Endpoint {
+	var endpoints []config.Endpoint
+	for _, t := range b.targets {
+		endpoints = append(endpoints, t.endpoint)
+	}
+	return endpoints
+}
+
+//pickRoundRobin selects targets in proportion to their weights, interleaving them as evenly as possible.
+func (b *Balancer) pickRoundRobin(now time.Time) *target {
+	var selected *target
+	total := 0
+	for _, t := range b.targets {
+		if !t.available(now) {
+			continue
+		}
+		t.current += t.weight
+		total += t.weight
+		if selected == nil || t.current > selected.current {
+			selected = t
+		}
+	}
+	if selected != nil {
+		selected.current -= total
+	}
+	return selected
+}
+
+//pickLeastConnections selects the target with the fewest active connections relative to its weight.
+func (b *Balancer) pickLeastConnections(now time.Time) *target {
+	var selected *target
+	selectedLoad := 0
+	for _, t := range b.targets {
+		if !t.available(now) {
+			continue
+		}
+		load := 0
+		if b.options.Load != nil {
+			load = b.options.Load(t.endpoint)
+		}
+		if selected == nil || load*selected.weight < selectedLoad*t.weight {
+			selected = t
+			selectedLoad = load
+		}
+	}
+	return selected
+}
+
+//pickHash selects the first available target following the hash of the key on the ring,
+//so keys only move to other targets if their target becomes unavailable.
+func (b *Balancer) pickHash(key string, now time.Time) *target {
+	if len(b.ring) == 0 {
+		return nil
+	}
+	h := hash(key)
+	start := sort.Search(len(b.ring), func(i int) bool { return b.ring[i].hash >= h })
+	for i := 0; i < len(b.ring); i++ {
+		t := b.ring[(start+i)%len(b.ring)].target
+		if t.available(now) {
+			return t
+		}
+	}
+	return nil
+}
+
+//find returns the target of the endpoint or nil.
+func (b *Balancer) find(endpoint config.Endpoint) *target {
+	for _, t := range b.targets {
+		if t.endpoint == endpoint {
+			return t
+		}
+	}
+	return nil
+}
+
+//available checks whether the target passed its last health check and is not ejected.
+func (t *target) available(now time.Time) bool {
+	return t.healthy && !now.Before(t.ejectedUntil)
+}
+
+//hash returns the FNV-1a hash of a string.
+func hash(s string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return h.Sum32()
+}
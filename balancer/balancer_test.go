@@ -0,0 +1,131 @@
+package balancer_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/digital-security-lab/hwl-proxy/balancer"
+	"github.com/digital-security-lab/hwl-proxy/config"
+)
+
+var testEndpoints = []config.Endpoint{
+	config.Endpoint{Network: "tcp", Address: "10.0.0.1:80"},
+	config.Endpoint{Network: "tcp", Address: "10.0.0.2:80"},
+	config.Endpoint{Network: "unix", Address: "/run/app.sock"},
+}
+
+func pickAll(t *testing.T, b *balancer.Balancer, key string, n int) map[config.Endpoint]int {
+	counts := make(map[config.Endpoint]int)
+	for i := 0; i < n; i++ {
+		endpoint, err := b.Pick(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts[endpoint]++
+	}
+	return counts
+}
+
+func TestRoundRobin(t *testing.T) {
+	b := balancer.New(testEndpoints, []int{1, 2, 1}, balancer.Options{})
+	counts := pickAll(t, b, "", 8)
+	if counts[testEndpoints[0]] != 2 || counts[testEndpoints[1]] != 4 || counts[testEndpoints[2]] != 2 {
+		t.Error("Unexpected distribution:", counts)
+	}
+
+	// the weight is spread evenly instead of selecting a target repeatedly
+	first, _ := b.Pick("")
+	second, _ := b.Pick("")
+	if first == second && first != testEndpoints[1] {
+		t.Error("Target selected repeatedly:", first)
+	}
+}
+
+func TestLeastConnections(t *testing.T) {
+	load := map[config.Endpoint]int{testEndpoints[0]: 3, testEndpoints[1]: 4, testEndpoints[2]: 1}
+	b := balancer.New(testEndpoints, []int{1, 4, 1}, balancer.Options{
+		Policy: "least-connections",
+		Load:   func(endpoint config.Endpoint) int { return load[endpoint] },
+	})
+	endpoint, _ := b.Pick("")
+	if endpoint != testEndpoints[1] {
+		t.Error("Expected the target with the fewest connections per weight, got", endpoint)
+	}
+	load[testEndpoints[1]] = 8
+	endpoint, _ = b.Pick("")
+	if endpoint != testEndpoints[2] {
+		t.Error("Expected the target with the fewest connections per weight, got", endpoint)
+	}
+}
+
+func TestConsistentHash(t *testing.T) {
+	b := balancer.New(testEndpoints, []int{1, 1, 1}, balancer.Options{Policy: "consistent-hash"})
+	if !b.HashesRequests() {
+		t.Error("Consistent hashing does not hash requests")
+	}
+	keys := []string{"a/", "b/index.html", "c/style.css", "d/", "e/", "f/", "g/", "h/"}
+	assigned := make(map[string]config.Endpoint)
+	used := make(map[config.Endpoint]bool)
+	for _, key := range keys {
+		counts := pickAll(t, b, key, 3)
+		if len(counts) != 1 {
+			t.Error("Key assigned to several targets:", key, counts)
+		}
+		assigned[key], _ = b.Pick(key)
+		used[assigned[key]] = true
+	}
+	if len(used) < 2 {
+		t.Error("Keys not distributed:", assigned)
+	}
+
+	// only the keys of an unavailable target move
+	b.SetHealthy(testEndpoints[0], false)
+	for _, key := range keys {
+		endpoint, _ := b.Pick(key)
+		if endpoint == testEndpoints[0] || (assigned[key] != testEndpoints[0] && endpoint != assigned[key]) {
+			t.Error("Unexpected target for", key, endpoint)
+		}
+	}
+}
+
+func TestEjection(t *testing.T) {
+	b := balancer.New(testEndpoints[:2], []int{1, 1}, balancer.Options{MaxFails: 2, EjectTime: 30 * time.Millisecond})
+	b.Report(testEndpoints[0], false)
+	b.Report(testEndpoints[0], true)
+	b.Report(testEndpoints[0], false)
+	if !b.IsAvailable(testEndpoints[0]) {
+		t.Error("Target ejected after non-consecutive failures")
+	}
+	b.Report(testEndpoints[0], false)
+	if b.IsAvailable(testEndpoints[0]) {
+		t.Error("Target not ejected after consecutive failures")
+	}
+	counts := pickAll(t, b, "", 4)
+	if counts[testEndpoints[1]] != 4 {
+		t.Error("Ejected target selected:", counts)
+	}
+
+	// the ejection ends after the eject time or a successful active check
+	time.Sleep(40 * time.Millisecond)
+	if !b.IsAvailable(testEndpoints[0]) {
+		t.Error("Target still ejected after the eject time")
+	}
+	b.Report(testEndpoints[1], false)
+	b.Report(testEndpoints[1], false)
+	b.SetHealthy(testEndpoints[1], true)
+	if !b.IsAvailable(testEndpoints[1]) {
+		t.Error("Target still ejected after a successful health check")
+	}
+}
+
+func TestNoHealthyUpstream(t *testing.T) {
+	for _, policy := range []string{"round-robin", "least-connections", "consistent-hash"} {
+		b := balancer.New(testEndpoints[:2], []int{1, 1}, balancer.Options{Policy: policy})
+		b.SetHealthy(testEndpoints[0], false)
+		b.SetHealthy(testEndpoints[1], false)
+		_, err := b.Pick("key")
+		if err != balancer.ErrNoHealthyUpstream {
+			t.Error(policy, "expected ErrNoHealthyUpstream, got", err)
+		}
+	}
+}
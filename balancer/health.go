@@ -0,0 +1,79 @@
+package balancer
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/digital-security-lab/hwl-proxy/config"
+	"github.com/digital-security-lab/hwl-proxy/utils"
+)
+
+var ErrUnhealthyStatus = errors.New("health check returned an unsuccessful status")
+
+//Probe checks the health of an endpoint.
+type Probe func(endpoint config.Endpoint) error
+
+//TCPProbe returns a probe that checks whether the endpoint accepts connections within the timeout.
+func TCPProbe(timeout time.Duration) Probe {
+	return func(endpoint config.Endpoint) error {
+		conn, err := net.DialTimeout(endpoint.Network, endpoint.Address, timeout)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+}
+
+//HTTPProbe returns a probe that sends a GET request for the path and expects a 2xx or 3xx response within the timeout.
+//The Host header is set to host, or to the address of the endpoint if host is empty. Response headers exceeding the
+//limits fail the probe.
+func HTTPProbe(timeout time.Duration, path string, host string, limits utils.HeaderLimits) Probe {
+	return func(endpoint config.Endpoint) error {
+		conn, err := net.DialTimeout(endpoint.Network, endpoint.Address, timeout)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(timeout))
+		probeHost := host
+		if len(probeHost) == 0 {
+			probeHost = endpoint.Address
+			if endpoint.Network == "unix" {
+				probeHost = "localhost"
+			}
+		}
+		_, err = conn.Write([]byte("GET " + path + " HTTP/1.1\r\nHost: " + probeHost + "\r\nConnection: close\r\n\r\n"))
+		if err != nil {
+			return err
+		}
+		data, err := utils.ReadHeader(bufio.NewReader(conn), limits)
+		if err != nil {
+			return err
+		}
+		code := utils.GetStatusCode(data)
+		if code < 200 || code >= 400 {
+			return ErrUnhealthyStatus
+		}
+		return nil
+	}
+}
+
+//CheckHealth probes all targets every interval until stop is closed.
+func (b *Balancer) CheckHealth(interval time.Duration, probe Probe, stop <-chan bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		for _, endpoint := range b.Endpoints() {
+			go func(endpoint config.Endpoint) {
+				b.SetHealthy(endpoint, probe(endpoint) == nil)
+			}(endpoint)
+		}
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package balancer_test
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/digital-security-lab/hwl-proxy/balancer"
+	"github.com/digital-security-lab/hwl-proxy/config"
+	"github.com/digital-security-lab/hwl-proxy/utils"
+)
+
+// startHealthServer answers requests for the host with the given response, the host defaults to the listener address.
+func startHealthServer(t *testing.T, host string, response string) (config.Endpoint, func()) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(host) == 0 {
+		host = listener.Addr().String()
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			data, err := utils.ReadUntilBytes(bufio.NewReader(conn), []byte("\r\n\r\n"))
+			if err == nil && string(data) == "GET /health HTTP/1.1\r\nHost: "+host+"\r\nConnection: close\r\n\r\n" {
+				conn.Write([]byte(response))
+			}
+			conn.Close()
+		}
+	}()
+	return config.Endpoint{Network: "tcp", Address: listener.Addr().String()}, func() { listener.Close() }
+}
+
+func TestProbes(t *testing.T) {
+	healthy, stopHealthy := startHealthServer(t, "backend", "HTTP/1.1 204 No Content\r\n\r\n")
+	defer stopHealthy()
+	unhealthy, stopUnhealthy := startHealthServer(t, "backend", "HTTP/1.1 503 Service Unavailable\r\nContent-Length: 0\r\n\r\n")
+	defer stopUnhealthy()
+	oversized, stopOversized := startHealthServer(t, "backend", "HTTP/1.1 200 OK\r\nX-Padding: "+strings.Repeat("a", 2048)+"\r\n\r\n")
+	defer stopOversized()
+	closed, stopClosed := startHealthServer(t, "backend", "")
+	stopClosed()
+
+	probe := balancer.HTTPProbe(time.Second, "/health", "backend", utils.HeaderLimits{Size: 1024})
+	if err := probe(healthy); err != nil {
+		t.Error("Healthy upstream failed the HTTP probe:", err)
+	}
+	if err := probe(unhealthy); err != balancer.ErrUnhealthyStatus {
+		t.Error("Expected ErrUnhealthyStatus, got", err)
+	}
+	if err := probe(oversized); err != utils.ErrHeaderTooLarge {
+		t.Error("Expected ErrHeaderTooLarge, got", err)
+	}
+	if probe(closed) == nil {
+		t.Error("Closed upstream passed the HTTP probe")
+	}
+	if err := balancer.TCPProbe(time.Second)(unhealthy); err != nil {
+		t.Error("Listening upstream failed the TCP probe:", err)
+	}
+	if balancer.TCPProbe(time.Second)(closed) == nil {
+		t.Error("Closed upstream passed the TCP probe")
+	}
+}
+
+func TestHTTPProbeDefaultHost(t *testing.T) {
+	first, stopFirst := startHealthServer(t, "", "HTTP/1.1 204 No Content\r\n\r\n")
+	defer stopFirst()
+	second, stopSecond := startHealthServer(t, "", "HTTP/1.1 204 No Content\r\n\r\n")
+	defer stopSecond()
+
+	// each endpoint is probed with its own address as host, also when probed again
+	probe := balancer.HTTPProbe(time.Second, "/health", "", utils.HeaderLimits{})
+	for _, endpoint := range []config.Endpoint{first, second, first} {
+		if err := probe(endpoint); err != nil {
+			t.Error("Probe of", endpoint.Address, "failed:", err)
+		}
+	}
+
+	b := balancer.New([]config.Endpoint{first, second}, []int{1, 1}, balancer.Options{})
+	stop := make(chan bool)
+	defer close(stop)
+	go b.CheckHealth(10*time.Millisecond, probe, stop)
+	time.Sleep(50 * time.Millisecond)
+	if !b.IsAvailable(first) || !b.IsAvailable(second) {
+		t.Error("Unexpected health:", b.IsAvailable(first), b.IsAvailable(second))
+	}
+}
+
+func TestCheckHealth(t *testing.T) {
+	healthy, stopHealthy := startHealthServer(t, "backend", "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n")
+	defer stopHealthy()
+	closed, stopClosed := startHealthServer(t, "backend", "")
+	stopClosed()
+
+	b := balancer.New([]config.Endpoint{healthy, closed}, []int{1, 1}, balancer.Options{})
+	stop := make(chan bool)
+	defer close(stop)
+	go b.CheckHealth(10*time.Millisecond, balancer.TCPProbe(time.Second), stop)
+	time.Sleep(50 * time.Millisecond)
+	if !b.IsAvailable(healthy) || b.IsAvailable(closed) {
+		t.Error("Unexpected health:", b.IsAvailable(healthy), b.IsAvailable(closed))
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"strconv"
+
+	"github.com/digital-security-lab/hwl-proxy/utils"
+)
+
+// maximum size of a de-chunked request body if not configured
+const defaultMaxDechunkedSize = 1024 * 1024
+
+//requestChunkOptions returns how chunked request bodies are forwarded and their limits.
+//Trailer fields are filtered by the trailer whitelist if whitelisting is enabled.
+func requestChunkOptions() utils.ChunkOptions {
+	options := utils.ChunkOptions{
+		RejectExtensions: proxyConfig.ChunkExtensions == "reject",
+		MaxSize:          proxyConfig.MaxBodySize,
+		MaxChunks:        proxyConfig.MaxChunkCount,
+	}
+	if proxyConfig.Whitelisting {
+		trailers := currentTrailerWhitelist()
+		options.FilterTrailers = trailers.ApplyFields
+	}
+	return options
+}
+
+//responseChunkOptions returns how chunked response bodies are relayed.
+//Fields that must not be sent in a trailer section are dropped when the trailers are read.
+//The trailer whitelist is not applied, as it restricts what clients send upstream and response header fields are not whitelisted either.
+func responseChunkOptions() utils.ChunkOptions {
+	return utils.ChunkOptions{}
+}
+
+//isDechunkedRequest checks whether the chunked body of a request is forwarded with Content-Length.
+//Bodies with further transfer codings remain chunked, as Content-Length must not be sent with Transfer-Encoding.
+func isDechunkedRequest(data []byte) bool {
+	transferEncoding := utils.GetHeaderFieldValues(data, []byte("Transfer-Encoding"))
+	return proxyConfig.ChunkedRequests == "dechunk" && len(transferEncoding) == 1 && bytes.EqualFold(transferEncoding[0], []byte("chunked"))
+}
+
+//forwardDechunked reads a chunked request body and forwards the request with the decoded body.
+//Trailer fields are dropped, as merging them into the header would let the client inject header fields.
+func forwardDechunked(upstream *utils.BufferedConn, client *utils.BufferedConn, header []byte) error {
+	limit := proxyConfig.MaxDechunkedSize
+	if limit <= 0 {
+		limit = defaultMaxDechunkedSize
+	}
+	var body bytes.Buffer
+	_, err := utils.DecodeChunks(&body, client.Reader, requestChunkOptions(), limit)
+	if err != nil {
+		return err
+	}
+	header = utils.RemoveHeader(header, "Transfer-Encoding", 0)
+	header = utils.RemoveHeader(header, "Trailer", 0)
+	header = utils.RemoveHeader(header, "Content-Length", 0)
+	header = utils.AddHeader(header, "Content-Length", strconv.Itoa(body.Len()))
+	_, err = upstream.Write(header)
+	if err == nil {
+		_, err = upstream.Write(body.Bytes())
+	}
+	if err == nil {
+		err = upstream.Flush()
+	}
+	if err != nil {
+		upstream.Conn.Close()
+	}
+	return err
+}
+
+//bodyErrorResponse returns the response to a request whose body could not be forwarded.
+func bodyErrorResponse(err error) *rejection {
+	if utils.IsTimeout(err) {
+		return errorResponse(reasonRequestTimeout, "")
+	}
+	if err == utils.ErrBodyTooLarge || err == utils.ErrTooManyChunks {
+		return limitErrorResponse(err)
+	}
+	return errorResponse(reasonInvalidBody, "")
+}
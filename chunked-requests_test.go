@@ -0,0 +1,85 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/digital-security-lab/hwl-proxy/config"
+	"github.com/digital-security-lab/hwl-proxy/utils"
+	"github.com/digital-security-lab/hwl-proxy/whitelisting"
+)
+
+// whitelists of the chunked body tests
+var chunkedTestWhitelist = whitelisting.Whitelist{
+	whitelisting.WhitelistItem{Key: "host"},
+	whitelisting.WhitelistItem{Key: "transfer-encoding", Val: `(?i)(chunked)`},
+	whitelisting.WhitelistItem{Key: "expect", Val: `100-continue`},
+}
+var chunkedTestTrailers = whitelisting.Whitelist{
+	whitelisting.WhitelistItem{Key: "checksum", Val: `[a-z0-9]+`},
+}
+
+func TestRechunkedRequest(t *testing.T) {
+	conns, stop := startProxyTest(t, config.ProxyConfig{Whitelisting: true}, chunkedTestWhitelist)
+	defer stop()
+	trailerWhitelist = chunkedTestTrailers
+
+	go conns.client.Write([]byte("POST / HTTP/1.1\r\nHost: example.com\r\nTransfer-Encoding: chunked\r\n\r\n" +
+		"a;ext=1\r\nabcdefghij\r\n0\r\nChecksum: abc\r\nX-Internal: 1\r\n\r\n"))
+	expectMessage(t, conns.upstreamBr, `^POST / HTTP/1.1\r\nHost: example.com\r\nTransfer-Encoding: chunked\r\n\r\n$`)
+	expected := "a\r\nabcdefghij\r\n0\r\nChecksum: abc\r\n\r\n"
+	body, err := utils.ReadByContentLength(conns.upstreamBr, len(expected))
+	if err != nil || string(body) != expected {
+		t.Fatal("Invalid body:", string(body), err)
+	}
+	go conns.upstream.Write(utils.CreateResponse(200, "OK", []byte("OK")))
+	expectMessage(t, conns.clientBr, `^HTTP/1.1 200 OK\r\n`)
+}
+
+func TestDechunkedRequest(t *testing.T) {
+	conns, stop := startProxyTest(t, config.ProxyConfig{Whitelisting: true, ChunkedRequests: "dechunk"}, chunkedTestWhitelist)
+	defer stop()
+	trailerWhitelist = chunkedTestTrailers
+
+	go conns.client.Write([]byte("POST / HTTP/1.1\r\nHost: example.com\r\nTransfer-Encoding: chunked\r\nExpect: 100-continue\r\n\r\n"))
+	expectMessage(t, conns.clientBr, `^HTTP/1.1 100 Continue\r\n\r\n$`)
+	go conns.client.Write([]byte("3\r\nabc\r\n10\r\nabcdefghijklmnop\r\n0\r\nChecksum: abc\r\n\r\n"))
+	expectMessage(t, conns.upstreamBr, `^POST / HTTP/1.1\r\nHost: example.com\r\nContent-Length: 19\r\n\r\n$`)
+	body, err := utils.ReadByContentLength(conns.upstreamBr, 19)
+	if err != nil || string(body) != "abcabcdefghijklmnop" {
+		t.Fatal("Invalid body:", string(body), err)
+	}
+}
+
+func TestDechunkedRequestTrailerSmuggling(t *testing.T) {
+	conns, stop := startProxyTest(t, config.ProxyConfig{Whitelisting: true, ChunkedRequests: "dechunk"}, chunkedTestWhitelist)
+	defer stop()
+	trailerWhitelist = chunkedTestTrailers
+
+	go conns.client.Write([]byte("POST / HTTP/1.1\r\nHost: example.com\r\nTransfer-Encoding: chunked\r\n\r\n" +
+		"3\r\nabc\r\n0\r\nContent-Length: 0\r\nTransfer-Encoding: chunked\r\n\r\n"))
+	expectMessage(t, conns.upstreamBr, `^POST / HTTP/1.1\r\nHost: example.com\r\nContent-Length: 3\r\n\r\n$`)
+	body, err := utils.ReadByContentLength(conns.upstreamBr, 3)
+	if err != nil || string(body) != "abc" {
+		t.Fatal("Invalid body:", string(body), err)
+	}
+}
+
+func TestDechunkedRequestTooLarge(t *testing.T) {
+	conns, stop := startProxyTest(t, config.ProxyConfig{Whitelisting: true, ChunkedRequests: "dechunk", MaxDechunkedSize: 8}, chunkedTestWhitelist)
+	defer stop()
+	trailerWhitelist = chunkedTestTrailers
+
+	go conns.client.Write([]byte("POST / HTTP/1.1\r\nHost: example.com\r\nTransfer-Encoding: chunked\r\n\r\n" +
+		"5\r\nabcde\r\n5\r\nfghij\r\n0\r\n\r\n"))
+	expectMessage(t, conns.clientBr, `^HTTP/1.1 413 Payload Too Large\r\n`)
+}
+
+func TestChunkExtensionRejected(t *testing.T) {
+	conns, stop := startProxyTest(t, config.ProxyConfig{Whitelisting: true, ChunkExtensions: "reject"}, chunkedTestWhitelist)
+	defer stop()
+	trailerWhitelist = chunkedTestTrailers
+
+	go conns.client.Write([]byte("POST / HTTP/1.1\r\nHost: example.com\r\nTransfer-Encoding: chunked\r\n\r\n" +
+		"5;ext\r\nabcde\r\n0\r\n\r\n"))
+	expectMessage(t, conns.clientBr, `^HTTP/1.1 400 Bad Request\r\n`)
+}
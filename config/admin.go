@@ -0,0 +1,21 @@
+package config
+
+import "errors"
+
+//AdminTLS configures TLS on the admin listener.
+type AdminTLS struct {
+	CertFile     string // PEM certificate chain of the admin listener, TLS is disabled if empty
+	KeyFile      string // PEM private key of CertFile
+	ClientCAFile string // PEM CA certificates client certificates must be issued by, client certificates are not requested if empty
+}
+
+//validate checks that the certificate is configured with its key and that client certificates are only requested with TLS.
+func (adminTLS AdminTLS) validate() error {
+	if (len(adminTLS.CertFile) > 0) != (len(adminTLS.KeyFile) > 0) {
+		return errors.New("adminTLS requires both certFile and keyFile")
+	}
+	if len(adminTLS.ClientCAFile) > 0 && len(adminTLS.CertFile) == 0 {
+		return errors.New("adminTLS clientCAFile requires certFile and keyFile")
+	}
+	return nil
+}
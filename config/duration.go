@@ -0,0 +1,42 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+var ErrInvalidDuration = errors.New("duration must be a number of seconds or a duration string like \"30s\"")
+
+//Duration is a time span configured as a Go duration string, e.g. "30s" or "1m30s", or as a number of seconds.
+type Duration time.Duration
+
+//UnmarshalJSON parses a duration string or a number of seconds.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	err := json.Unmarshal(data, &value)
+	if err != nil {
+		return err
+	}
+	switch value := value.(type) {
+	case float64:
+		*d = Duration(value * float64(time.Second))
+	case string:
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			return ErrInvalidDuration
+		}
+		*d = Duration(parsed)
+	default:
+		return ErrInvalidDuration
+	}
+	return nil
+}
+
+//Deadline returns the time the duration ends from now, or the zero time, i.e. no deadline, if the duration is not positive.
+func (d Duration) Deadline() time.Time {
+	if d <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(time.Duration(d))
+}
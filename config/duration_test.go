@@ -0,0 +1,53 @@
+package config_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/digital-security-lab/hwl-proxy/config"
+)
+
+func TestDuration(t *testing.T) {
+	var tests = []struct {
+		value    string
+		duration time.Duration
+		err      error
+	}{
+		{`30`, 30 * time.Second, nil},
+		{`0.5`, 500 * time.Millisecond, nil},
+		{`"30s"`, 30 * time.Second, nil},
+		{`"1m30s"`, 90 * time.Second, nil},
+		{`"250ms"`, 250 * time.Millisecond, nil},
+		{`"30"`, 0, config.ErrInvalidDuration},
+		{`true`, 0, config.ErrInvalidDuration},
+	}
+	for _, test := range tests {
+		var d config.Duration
+		err := json.Unmarshal([]byte(test.value), &d)
+		if time.Duration(d) != test.duration || err != test.err {
+			t.Errorf("%s: got %v, %v, expected %v, %v", test.value, time.Duration(d), err, test.duration, test.err)
+		}
+	}
+}
+
+func TestPhaseTimeouts(t *testing.T) {
+	var proxyConfig config.ProxyConfig
+	err := json.Unmarshal([]byte(`{"connTimeout": "30s", "timeouts": {"idle": 120, "responseHeader": "1m"}}`), &proxyConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	timeouts := proxyConfig.PhaseTimeouts()
+	if timeouts.Idle != config.Duration(2*time.Minute) || timeouts.ResponseHeader != config.Duration(time.Minute) {
+		t.Error("Configured timeouts not applied:", timeouts)
+	}
+	if timeouts.Header != config.Duration(30*time.Second) || timeouts.Write != config.Duration(30*time.Second) {
+		t.Error("Timeouts not defaulting to connTimeout:", timeouts)
+	}
+	if timeouts.Continue != config.Duration(time.Second) {
+		t.Error("Continue timeout not defaulting to one second:", timeouts.Continue)
+	}
+	if !config.Duration(0).Deadline().IsZero() {
+		t.Error("Deadline set for a zero duration")
+	}
+}
@@ -0,0 +1,38 @@
+package config
+
+import (
+	"errors"
+	"net"
+	"strings"
+)
+
+var ErrInvalidEndpoint = errors.New("invalid endpoint, expected tcp://host:port, unix:///path or host:port")
+
+//Endpoint is the address of a TCP listener or server, or the path of a Unix domain socket.
+type Endpoint struct {
+	Network string // "tcp" or "unix"
+	Address string // host and port, or path of the socket
+}
+
+//ParseEndpoint parses an address of the form "tcp://host:port", "unix:///path/to/socket" or "host:port".
+//IPv6 addresses are enclosed in brackets, e.g. "tcp://[::1]:81".
+func ParseEndpoint(address string) (Endpoint, error) {
+	if strings.HasPrefix(address, "unix://") {
+		path := strings.TrimPrefix(address, "unix://")
+		if len(path) == 0 {
+			return Endpoint{}, ErrInvalidEndpoint
+		}
+		return Endpoint{Network: "unix", Address: path}, nil
+	}
+	address = strings.TrimPrefix(address, "tcp://")
+	_, port, err := net.SplitHostPort(address)
+	if err != nil || len(port) == 0 || strings.Contains(address, "/") {
+		return Endpoint{}, ErrInvalidEndpoint
+	}
+	return Endpoint{Network: "tcp", Address: address}, nil
+}
+
+//String returns the endpoint in the form accepted by ParseEndpoint.
+func (endpoint Endpoint) String() string {
+	return endpoint.Network + "://" + endpoint.Address
+}
@@ -0,0 +1,45 @@
+package config_test
+
+import (
+	"testing"
+
+	"github.com/digital-security-lab/hwl-proxy/config"
+)
+
+func TestParseEndpoint(t *testing.T) {
+	var tests = []struct {
+		address  string
+		endpoint config.Endpoint
+		err      error
+	}{
+		{"tcp://[::1]:81", config.Endpoint{Network: "tcp", Address: "[::1]:81"}, nil},
+		{"tcp://example.com:80", config.Endpoint{Network: "tcp", Address: "example.com:80"}, nil},
+		{"127.0.0.1:9000", config.Endpoint{Network: "tcp", Address: "127.0.0.1:9000"}, nil},
+		{":80", config.Endpoint{Network: "tcp", Address: ":80"}, nil},
+		{"unix:///run/app.sock", config.Endpoint{Network: "unix", Address: "/run/app.sock"}, nil},
+		{"unix://", config.Endpoint{}, config.ErrInvalidEndpoint},
+		{"::1:81", config.Endpoint{}, config.ErrInvalidEndpoint},
+		{"tcp://example.com", config.Endpoint{}, config.ErrInvalidEndpoint},
+		{"tcp://example.com:", config.Endpoint{}, config.ErrInvalidEndpoint},
+		{"http://example.com:80", config.Endpoint{}, config.ErrInvalidEndpoint},
+		{"", config.Endpoint{}, config.ErrInvalidEndpoint},
+	}
+	for _, test := range tests {
+		endpoint, err := config.ParseEndpoint(test.address)
+		if endpoint != test.endpoint || err != test.err {
+			t.Errorf("%q: got %v, %v, expected %v, %v", test.address, endpoint, err, test.endpoint, test.err)
+		}
+	}
+}
+
+func TestLocalEndpoints(t *testing.T) {
+	proxyConfig := config.ProxyConfig{PortOutLocal: 81, PortInLocal: 80, AddressInLocal: "unix:///run/hwl-proxy.sock"}
+	endpoint, err := proxyConfig.OutLocalEndpoint()
+	if err != nil || endpoint.String() != "tcp://127.0.0.1:81" {
+		t.Errorf("got %v, %v, expected tcp://127.0.0.1:81", endpoint, err)
+	}
+	endpoint, err = proxyConfig.InLocalEndpoint()
+	if err != nil || endpoint.String() != "unix:///run/hwl-proxy.sock" {
+		t.Errorf("got %v, %v, expected unix:///run/hwl-proxy.sock", endpoint, err)
+	}
+}
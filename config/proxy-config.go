@@ -2,25 +2,198 @@ package config
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io/ioutil"
-	"time"
+	"strings"
 )
 
 type ProxyConfig struct {
-	IncomingAddress string        // incoming connection from the internet
-	PortOutLocal    int           // outgoing connection to local intermediary or origin server
-	PortInLocal     int           // incoming connection from local intermediary
-	OutgoingAddress string        // outgoing connection to next intermediary
-	Whitelisting    bool          // apply whitelisting
-	ConnTimeout     time.Duration // connection read and write timeout
-	Origin          bool          // true, if target is origin server, false if target is intermediary with two endpoints
+	IncomingAddress     string      // incoming connection from the internet, e.g. "tcp://[::]:80" or "0.0.0.0:80"
+	PortOutLocal        int         // outgoing connection to local intermediary or origin server on 127.0.0.1, if AddressOutLocal is not set
+	PortInLocal         int         // incoming connection from local intermediary on 127.0.0.1, if AddressInLocal is not set
+	AddressOutLocal     string      // outgoing connection to local intermediary or origin server, e.g. "tcp://[::1]:81" or "unix:///run/app.sock"
+	AddressInLocal      string      // incoming connection from local intermediary, e.g. "unix:///run/hwl-proxy.sock"
+	OutgoingAddress     string      // outgoing connection to next intermediary, e.g. "tcp://example.com:80"
+	Whitelisting        bool        // apply whitelisting
+	ConnTimeout         Duration    // default of all Timeouts, e.g. "30s" or 30 seconds, no timeout if 0
+	Timeouts            Timeouts    // timeouts of the phases of a connection
+	Origin              bool        // true, if target is origin server, false if target is intermediary with two endpoints
+	ForwardProxy        bool        // true, if absolute-form and CONNECT requests are dialled to their target host (requires origin)
+	AllowedDestinations []string    // destinations reachable in forward-proxy mode, e.g. "example.com:443", "*.internal" or "10.0.0.0/8"
+	ProxyCredentials    []string    // accepted "user:password" pairs for Proxy-Authorization, no authentication if empty
+	ExpectContinue      string      // "relay" forwards whitelisted 100-continue expectations, "generate" (default) answers them
+	PipelineDepth       int         // maximum number of requests forwarded on a connection before their responses were received
+	ChunkExtensions     string      // "reject" rejects requests with chunk extensions, "strip" (default) strips them
+	ChunkedRequests     string      // "dechunk" forwards chunked request bodies with Content-Length, "rechunk" (default) re-chunks them
+	MaxDechunkedSize    int64       // maximum size of a de-chunked request body in bytes
+	FramingPolicy       string      // "strict" (default) rejects requests with ambiguous framing, "lenient" resolves it according to RFC 9112
+	MaxStartLine        int         // maximum length of the request line and the upstream status line in bytes, 8192 if not set
+	MaxHeaderLine       int         // maximum length of a request or response header field line in bytes, 8192 if not set
+	MaxHeaderCount      int         // maximum number of request or response header fields, 100 if not set
+	MaxHeaderBytes      int         // maximum size of a request or response header in bytes, 65536 if not set
+	MinHeaderRate       int         // minimum bytes per second of a request header after MinRateGrace, not enforced if 0
+	MinBodyRate         int         // minimum bytes per second of a request body after MinRateGrace, not enforced if 0
+	MinRateGrace        Duration    // time after which MinHeaderRate and MinBodyRate are enforced, 5s if not set
+	MaxHeaderReaders    int         // maximum number of connections reading a request header, the slowest is evicted, unlimited if 0
+	MaxBodySize         int64       // maximum size of a request body in bytes, unlimited if 0
+	MaxChunkCount       int         // maximum number of chunks of a chunked request body, unlimited if 0
+	ProxyProtocol       bool        // read a PROXY protocol v1 or v2 header on incoming connections from ProxyProtocolFrom
+	ProxyProtocolFrom   []string    // IP addresses or CIDR ranges of load balancers sending a PROXY protocol header
+	SendProxyProtocol   string      // "v1" or "v2" sends a PROXY protocol header with the client address to the upstream, none if not set
+	ForwardedHeaders    string      // "forwarded", "x-forwarded" or "both" adds Forwarded and/or X-Forwarded-* to requests, client values are kept if empty
+	TrustedProxies      []string    // IP addresses or CIDR ranges of proxies whose Forwarded and X-Forwarded-* values are appended to
+	Upstreams           []Upstream  // upstreams of the last leg, replacing OutgoingAddress, or AddressOutLocal in origin mode
+	Balancing           string      // "round-robin" (default), "least-connections" or "consistent-hash" of Host and request target
+	HealthCheck         HealthCheck // health checks of Upstreams
+	UpstreamMaxIdle     int         // maximum number of idle upstream connections kept per endpoint for reuse, none if 0
+	UpstreamMaxOpen     int         // maximum number of open upstream connections per endpoint, unlimited if 0
+	UpstreamIdleTimeout Duration    // idle upstream connections are closed after this time, unlimited if 0
+	RequestRate         float64     // requests per second per client IP address, unlimited if 0
+	RequestBurst        int         // requests a client IP address may send at once, RequestRate rounded up if not set
+	GlobalRequestRate   float64     // requests per second of all clients, unlimited if 0
+	GlobalRequestBurst  int         // requests all clients may send at once, GlobalRequestRate rounded up if not set
+	MaxClientConns      int         // concurrent connections per client IP address, unlimited if 0
+	MaxConns            int         // concurrent connections of all clients, unlimited if 0
+	RateLimitExempt     []string    // IP addresses or CIDR ranges exempt from the request rate and connection limits
+	ShutdownTimeout     Duration    // time in-flight requests may take to finish after SIGTERM or SIGINT, 30s if not set
+	NominationPolicy    string      // "reject" (default) rejects requests nominating whitelisted header fields in Connection, "ignore" keeps these fields
+	Rejections          Rejections  // error responses to rejected requests
+	AccessLog           string      // file path of the access log, reopened on SIGUSR1, "off" disables it, stdout if empty
+	AccessLogFormat     string      // "json" (default) writes each line as JSON object, "logfmt" as key=value pairs
+	AuditLog            string      // file path of the audit log of header fields removed by the whitelist, reopened on SIGUSR1, "-" for stdout, none if empty
+	AuditValues         bool        // log the values of removed header fields, except for redacted fields
+	AuditRedact         []string    // header field names whose values are redacted, Authorization, Proxy-Authorization and Cookie if not set
+	AdminAddress        string      // admin listener serving /metrics and the admin API, e.g. "tcp://127.0.0.1:9090", disabled if empty
+	AdminToken          string      // bearer token required on the admin listener, the admin API requires it or AdminTLS.ClientCAFile
+	AdminTLS            AdminTLS    // TLS of the admin listener, optionally requiring client certificates
+	ReportOnly          bool        // header fields the whitelist would remove are only reported, the admin API can toggle it
+	MaxMetricSeries     int         // maximum number of series of metrics labelled by header field name or upstream, 100 if not set
+	Tracing             Tracing     // export of spans of requests carrying or starting a W3C trace context
 }
 
+//Load reads the configuration from a JSON file and validates it.
 func (proxyConfig *ProxyConfig) Load(file string) error {
 	data, err := ioutil.ReadFile(file)
 	if err != nil {
 		return err
 	}
-	err = json.Unmarshal(data, proxyConfig)
+	return proxyConfig.Parse(data)
+}
+
+//Parse reads the configuration from JSON data and validates it.
+func (proxyConfig *ProxyConfig) Parse(data []byte) error {
+	err := json.Unmarshal(data, proxyConfig)
+	if err != nil {
+		return err
+	}
+	if proxyConfig.ForwardProxy && !proxyConfig.Origin {
+		return errors.New("forwardProxy requires origin mode")
+	}
+	err = checkOption("expectContinue", proxyConfig.ExpectContinue, "generate", "relay")
+	if err != nil {
+		return err
+	}
+	err = checkOption("chunkExtensions", proxyConfig.ChunkExtensions, "strip", "reject")
+	if err != nil {
+		return err
+	}
+	err = checkOption("chunkedRequests", proxyConfig.ChunkedRequests, "rechunk", "dechunk")
+	if err != nil {
+		return err
+	}
+	err = checkOption("framingPolicy", proxyConfig.FramingPolicy, "strict", "lenient")
+	if err != nil {
+		return err
+	}
+	err = checkOption("nominationPolicy", proxyConfig.NominationPolicy, "reject", "ignore")
+	if err != nil {
+		return err
+	}
+	err = checkOption("sendProxyProtocol", proxyConfig.SendProxyProtocol, "v1", "v2")
+	if err != nil {
+		return err
+	}
+	err = checkOption("forwardedHeaders", proxyConfig.ForwardedHeaders, "forwarded", "x-forwarded", "both")
+	if err != nil {
+		return err
+	}
+	err = checkOption("balancing", proxyConfig.Balancing, "round-robin", "least-connections", "consistent-hash")
+	if err != nil {
+		return err
+	}
+	err = checkOption("accessLogFormat", proxyConfig.AccessLogFormat, "json", "logfmt")
+	if err != nil {
+		return err
+	}
+	err = proxyConfig.AdminTLS.validate()
+	if err != nil {
+		return err
+	}
+	endpoints := []func() (Endpoint, error){proxyConfig.IncomingEndpoint, proxyConfig.OutLocalEndpoint}
+	if !proxyConfig.Origin {
+		endpoints = append(endpoints, proxyConfig.InLocalEndpoint)
+	}
+	if !proxyConfig.Origin && len(proxyConfig.Upstreams) == 0 {
+		endpoints = append(endpoints, proxyConfig.OutgoingEndpoint)
+	}
+	if len(proxyConfig.AdminAddress) > 0 {
+		endpoints = append(endpoints, proxyConfig.AdminEndpoint)
+	}
+	for _, endpoint := range endpoints {
+		_, err = endpoint()
+		if err != nil {
+			return err
+		}
+	}
+	_, _, err = proxyConfig.UpstreamEndpoints()
 	return err
 }
+
+//checkOption returns an error if an option is set to none of the allowed values. An empty value selects the default.
+func checkOption(name string, value string, allowed ...string) error {
+	if len(value) == 0 {
+		return nil
+	}
+	for _, option := range allowed {
+		if value == option {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid %s %q, expected one of: %s", name, value, strings.Join(allowed, ", "))
+}
+
+//IncomingEndpoint returns the endpoint the incoming module listens on.
+func (proxyConfig *ProxyConfig) IncomingEndpoint() (Endpoint, error) {
+	return ParseEndpoint(proxyConfig.IncomingAddress)
+}
+
+//OutLocalEndpoint returns the endpoint of the local intermediary or origin server.
+//Without AddressOutLocal, PortOutLocal on the IPv4 loopback address is used.
+func (proxyConfig *ProxyConfig) OutLocalEndpoint() (Endpoint, error) {
+	return localEndpoint(proxyConfig.AddressOutLocal, proxyConfig.PortOutLocal)
+}
+
+//InLocalEndpoint returns the endpoint the outgoing module listens on for the local intermediary.
+//Without AddressInLocal, PortInLocal on the IPv4 loopback address is used.
+func (proxyConfig *ProxyConfig) InLocalEndpoint() (Endpoint, error) {
+	return localEndpoint(proxyConfig.AddressInLocal, proxyConfig.PortInLocal)
+}
+
+//OutgoingEndpoint returns the endpoint of the next intermediary.
+func (proxyConfig *ProxyConfig) OutgoingEndpoint() (Endpoint, error) {
+	return ParseEndpoint(proxyConfig.OutgoingAddress)
+}
+
+//AdminEndpoint returns the endpoint the admin listener listens on.
+func (proxyConfig *ProxyConfig) AdminEndpoint() (Endpoint, error) {
+	return ParseEndpoint(proxyConfig.AdminAddress)
+}
+
+//localEndpoint parses the address of a local leg, or uses the port on 127.0.0.1 if no address is set.
+func localEndpoint(address string, port int) (Endpoint, error) {
+	if len(address) == 0 {
+		return Endpoint{Network: "tcp", Address: fmt.Sprintf("127.0.0.1:%d", port)}, nil
+	}
+	return ParseEndpoint(address)
+}
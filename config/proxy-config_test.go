@@ -19,3 +19,56 @@ func TestLoadWhitelist(t *testing.T) {
 	}
 
 }
+
+func TestParseOptions(t *testing.T) {
+	var tests = []struct {
+		json  string
+		valid bool
+	}{
+		{`{"incomingAddress": ":80", "origin": true, "expectContinue": "relay"}`, true},
+		{`{"incomingAddress": ":80", "origin": true, "expectContinue": "generate"}`, true},
+		{`{"incomingAddress": ":80", "origin": true, "expectContinue": "Relay"}`, false},
+		{`{"incomingAddress": ":80", "origin": true, "chunkExtensions": "strip", "chunkedRequests": "dechunk"}`, true},
+		{`{"incomingAddress": ":80", "origin": true, "chunkExtensions": "rejct"}`, false},
+		{`{"incomingAddress": ":80", "origin": true, "chunkedRequests": "de-chunk"}`, false},
+		{`{"incomingAddress": ":80", "origin": true, "framingPolicy": "lenient"}`, true},
+		{`{"incomingAddress": ":80", "origin": true, "framingPolicy": "Strict"}`, false},
+		{`{"incomingAddress": ":80", "origin": true, "nominationPolicy": "ignore"}`, true},
+		{`{"incomingAddress": ":80", "origin": true, "nominationPolicy": "keep"}`, false},
+		{`{"incomingAddress": ":80", "origin": true, "sendProxyProtocol": "v2"}`, true},
+		{`{"incomingAddress": ":80", "origin": true, "sendProxyProtocol": "2"}`, false},
+		{`{"incomingAddress": ":80", "origin": true, "forwardedHeaders": "x-forwarded"}`, true},
+		{`{"incomingAddress": ":80", "origin": true, "forwardedHeaders": "xff"}`, false},
+		{`{"incomingAddress": ":80", "origin": true, "balancing": "consistent-hash"}`, true},
+		{`{"incomingAddress": ":80", "origin": true, "balancing": "least-conn"}`, false},
+		{`{"incomingAddress": ":80", "origin": true, "accessLogFormat": "logfmt"}`, true},
+		{`{"incomingAddress": ":80", "origin": true, "accessLogFormat": "text"}`, false},
+	}
+	for _, test := range tests {
+		var proxyConfig config.ProxyConfig
+		err := proxyConfig.Parse([]byte(test.json))
+		if (err == nil) != test.valid {
+			t.Errorf("%s: unexpected error %v", test.json, err)
+		}
+	}
+}
+
+func TestParseAdminConfig(t *testing.T) {
+	var tests = []struct {
+		json  string
+		valid bool
+	}{
+		{`{"incomingAddress": ":80", "origin": true, "adminAddress": "127.0.0.1:9090", "adminToken": "secret"}`, true},
+		{`{"incomingAddress": ":80", "origin": true, "adminAddress": "127.0.0.1"}`, false},
+		{`{"incomingAddress": ":80", "origin": true, "adminTLS": {"certFile": "admin.pem", "keyFile": "admin-key.pem", "clientCAFile": "ca.pem"}}`, true},
+		{`{"incomingAddress": ":80", "origin": true, "adminTLS": {"certFile": "admin.pem"}}`, false},
+		{`{"incomingAddress": ":80", "origin": true, "adminTLS": {"clientCAFile": "ca.pem"}}`, false},
+	}
+	for _, test := range tests {
+		var proxyConfig config.ProxyConfig
+		err := proxyConfig.Parse([]byte(test.json))
+		if (err == nil) != test.valid {
+			t.Errorf("%s: unexpected error %v", test.json, err)
+		}
+	}
+}
@@ -0,0 +1,16 @@
+package config
+
+//Rejections configures the error responses to rejected requests.
+type Rejections struct {
+	Format            string               // "problem+json" answers with RFC 9457 problem details, otherwise plain text
+	ProblemTypeBase   string               // URI the reason code is appended to for the problem type, e.g. "https://example.com/problems/", "about:blank" if empty
+	CorrelationHeader string               // header field carrying a correlation ID, e.g. "X-Correlation-ID", which is logged with the reason, none if empty
+	Reasons           map[string]Rejection // responses per reason code, e.g. "header-too-large"
+}
+
+//Rejection overrides the error response to a reason code.
+type Rejection struct {
+	Status      int    // status code, the default of the reason if 0
+	Body        string // text/template of the body with the fields Status, Title, Reason, Detail and CorrelationID, the body of the format if empty
+	ContentType string // Content-Type of Body, "text/plain; charset=utf-8" if empty
+}
@@ -0,0 +1,33 @@
+package config
+
+import "time"
+
+// time to wait for 100 Continue if not configured, independent of ConnTimeout as the body is forwarded afterwards anyway
+const defaultContinueTimeout = Duration(time.Second)
+
+//Timeouts configures the timeouts of the phases of a connection. They apply to each request anew.
+type Timeouts struct {
+	Header         Duration // reading the request header, from its first byte
+	Body           Duration // inactivity while reading the request body
+	Idle           Duration // waiting for the next request on a keep-alive connection
+	Connect        Duration // connecting to an upstream, including the wait for a pooled connection
+	ResponseHeader Duration // waiting for the response header after the request was forwarded
+	ResponseBody   Duration // inactivity while reading the response body
+	Write          Duration // each write to the client or upstream
+	Continue       Duration // waiting for 100 Continue from the upstream before a request body is forwarded anyway, 1s if not set
+}
+
+//PhaseTimeouts returns the configured timeouts. Timeouts that are not set default to ConnTimeout, except for Continue.
+func (proxyConfig *ProxyConfig) PhaseTimeouts() Timeouts {
+	timeouts := proxyConfig.Timeouts
+	if timeouts.Continue == 0 {
+		timeouts.Continue = defaultContinueTimeout
+	}
+	for _, timeout := range []*Duration{&timeouts.Header, &timeouts.Body, &timeouts.Idle, &timeouts.Connect,
+		&timeouts.ResponseHeader, &timeouts.ResponseBody, &timeouts.Write} {
+		if *timeout == 0 {
+			*timeout = proxyConfig.ConnTimeout
+		}
+	}
+	return timeouts
+}
@@ -0,0 +1,10 @@
+package config
+
+//Tracing configures the export of spans to an OpenTelemetry collector.
+type Tracing struct {
+	Endpoint    string            // OTLP/HTTP traces endpoint of the collector, e.g. "http://127.0.0.1:4318/v1/traces", no spans are exported if empty
+	ServiceName string            // service.name of the exported spans, "hwl-proxy" if empty
+	Headers     map[string]string // HTTP header fields sent to the collector, e.g. Authorization
+	BatchSize   int               // maximum number of spans per export request, 512 if not set
+	Interval    Duration          // time after which pending spans are exported, 5s if not set
+}
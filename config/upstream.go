@@ -0,0 +1,36 @@
+package config
+
+//Upstream is one of several upstreams requests are balanced across.
+type Upstream struct {
+	Address string // endpoint of the upstream, see ParseEndpoint
+	Weight  int    // relative share of the requests, 1 if not set
+}
+
+//HealthCheck configures active and passive health checks of the upstreams.
+type HealthCheck struct {
+	Interval  Duration // time between active checks, no active checks if 0
+	Timeout   Duration // time an active check may take
+	Path      string   // request target of an HTTP probe, e.g. "/health", a TCP connect is checked if empty
+	Host      string   // Host of the HTTP probe, the upstream address if empty
+	MaxFails  int      // consecutive failed requests after which an upstream is ejected, never if 0
+	EjectTime Duration // time an ejected upstream is not selected, unless an active check succeeds
+}
+
+//UpstreamEndpoints returns the endpoints and weights of Upstreams.
+func (proxyConfig *ProxyConfig) UpstreamEndpoints() ([]Endpoint, []int, error) {
+	var endpoints []Endpoint
+	var weights []int
+	for _, upstream := range proxyConfig.Upstreams {
+		endpoint, err := ParseEndpoint(upstream.Address)
+		if err != nil {
+			return nil, nil, err
+		}
+		weight := upstream.Weight
+		if weight <= 0 {
+			weight = 1
+		}
+		endpoints = append(endpoints, endpoint)
+		weights = append(weights, weight)
+	}
+	return endpoints, weights, nil
+}
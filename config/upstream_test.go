@@ -0,0 +1,24 @@
+package config_test
+
+import (
+	"testing"
+
+	"github.com/digital-security-lab/hwl-proxy/config"
+)
+
+func TestUpstreamEndpoints(t *testing.T) {
+	proxyConfig := config.ProxyConfig{Upstreams: []config.Upstream{
+		config.Upstream{Address: "tcp://10.0.0.1:80", Weight: 3},
+		config.Upstream{Address: "unix:///run/app.sock"},
+	}}
+	endpoints, weights, err := proxyConfig.UpstreamEndpoints()
+	if err != nil || len(endpoints) != 2 || endpoints[1].Network != "unix" || weights[0] != 3 || weights[1] != 1 {
+		t.Error("Unexpected upstreams:", endpoints, weights, err)
+	}
+
+	proxyConfig.Upstreams = append(proxyConfig.Upstreams, config.Upstream{Address: "10.0.0.2"})
+	_, _, err = proxyConfig.UpstreamEndpoints()
+	if err != config.ErrInvalidEndpoint {
+		t.Error("Expected ErrInvalidEndpoint, got", err)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/digital-security-lab/hwl-proxy/utils"
+)
+
+var errProtectedNomination = errors.New("end-to-end header field nominated in Connection")
+
+//isProtectedHeader checks whether a header field must not be removed by nominating it in Connection.
+//These are the framing fields and Host as well as all whitelisted fields.
+func isProtectedHeader(name string) bool {
+	switch strings.ToLower(name) {
+	case "host", "content-length", "transfer-encoding":
+		return true
+	}
+	if !proxyConfig.Whitelisting {
+		return false
+	}
+	wl := currentWhitelist()
+	return wl.Contains(name)
+}
+
+//prepareConnectionHeaders determines whether the client connection persists after the request and removes hop-by-hop header fields.
+//Unless the nomination policy is "ignore", requests nominating protected header fields in Connection are rejected,
+//as the next hop would remove header fields that passed whitelisting. Otherwise, such fields are kept.
+func prepareConnectionHeaders(data []byte, ex *exchange) ([]byte, error) {
+	ex.close = !utils.IsPersistent(data)
+	if proxyConfig.NominationPolicy != "ignore" {
+		for _, name := range utils.NominatedHeaders(data) {
+			if isProtectedHeader(name) {
+				return nil, errProtectedNomination
+			}
+		}
+	}
+	return utils.RemoveHopByHopHeaders(data, isProtectedHeader), nil
+}
+
+//prepareResponseConnection removes hop-by-hop header fields of a response and sets the Connection option for the client.
+//It returns true if the client connection must be closed after the response, e.g. as the proxy is shutting down.
+//If only the upstream does not keep the connection alive, the upstream connection is closed
+//and the next request is forwarded on another connection.
+func prepareResponseConnection(data []byte, ex *exchange) ([]byte, bool) {
+	ex.upstreamClose = ex.upstreamClose || !utils.IsPersistent(data)
+	closeAfter := ex.close || clients.isClosing()
+	data = utils.RemoveHopByHopHeaders(data, nil)
+	if closeAfter {
+		data = utils.SetConnectionOption(data, "close")
+	} else if ex.version == "HTTP/1.0" {
+		data = utils.SetConnectionOption(data, "keep-alive")
+	} else {
+		// the options of the upstream connection do not apply to the client connection
+		data = utils.RemoveHeader(data, "Connection", 0)
+	}
+	return data, closeAfter
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/digital-security-lab/hwl-proxy/config"
+	"github.com/digital-security-lab/hwl-proxy/utils"
+)
+
+func TestNominatedWhitelistedHeader(t *testing.T) {
+	conns, stop := startProxyTest(t, config.ProxyConfig{Whitelisting: true}, defaultTestWhitelist)
+	defer stop()
+
+	go conns.client.Write([]byte("GET / HTTP/1.1\r\nHost: a\r\nConnection: Authorization\r\nAuthorization: Basic YQ==\r\n\r\n"))
+	expectMessage(t, conns.clientBr, `^HTTP/1.1 400 Bad Request\r\n`)
+}
+
+func TestNominatedHeaderIgnored(t *testing.T) {
+	conns, stop := startProxyTest(t, config.ProxyConfig{Whitelisting: true, NominationPolicy: "ignore"}, defaultTestWhitelist)
+	defer stop()
+
+	go conns.client.Write([]byte("GET / HTTP/1.1\r\nHost: a\r\nConnection: keep-alive, Authorization, X-Hop\r\nAuthorization: Basic YQ==\r\nX-Hop: 1\r\nKeep-Alive: timeout=5\r\n\r\n"))
+	expectMessage(t, conns.upstreamBr, `^GET / HTTP/1.1\r\nHost: a\r\nConnection: keep-alive\r\nAuthorization: Basic YQ==\r\n\r\n$`)
+}
+
+func TestHTTP10Persistence(t *testing.T) {
+	conns, stop := startProxyTest(t, config.ProxyConfig{Whitelisting: true}, defaultTestWhitelist)
+	defer stop()
+
+	// HTTP/1.0 connections only persist with keep-alive, which is confirmed in the response
+	go conns.client.Write([]byte("GET /1 HTTP/1.0\r\nHost: a\r\nConnection: keep-alive\r\n\r\nGET /2 HTTP/1.0\r\nHost: a\r\n\r\nGET /3 HTTP/1.0\r\n\r\n"))
+	expectMessage(t, conns.upstreamBr, `^GET /1 HTTP/1.0\r\nHost: a\r\nConnection: keep-alive\r\n\r\n$`)
+	go conns.upstream.Write([]byte("HTTP/1.1 200 OK\r\nContent-Length: 0\r\nKeep-Alive: timeout=5\r\n\r\n"))
+	expectMessage(t, conns.clientBr, `^HTTP/1.1 200 OK\r\nContent-Length: 0\r\nConnection: keep-alive\r\n\r\n$`)
+
+	expectMessage(t, conns.upstreamBr, `^GET /2 HTTP/1.0\r\nHost: a\r\n\r\n$`)
+	go conns.upstream.Write(utils.CreateResponse(200, "OK", nil))
+	expectMessage(t, conns.clientBr, `^HTTP/1.1 200 OK\r\nContent-Length: 0\r\nConnection: close\r\n\r\n$`)
+	select {
+	case <-conns.done:
+	case <-time.After(time.Second):
+		t.Error("Connection not closed after HTTP/1.0 request")
+	}
+}
+
+func TestUpstreamConnectionClose(t *testing.T) {
+	conns, stop := startProxyTest(t, config.ProxyConfig{Whitelisting: true}, defaultTestWhitelist)
+	defer stop()
+
+	go conns.client.Write([]byte("GET / HTTP/1.1\r\nHost: a\r\n\r\n"))
+	expectMessage(t, conns.upstreamBr, `^GET / HTTP/1.1\r\nHost: a\r\n\r\n$`)
+	go conns.upstream.Write([]byte("HTTP/1.1 200 OK\r\nConnection: close, X-Hop\r\nX-Hop: 1\r\nContent-Length: 0\r\n\r\n"))
+	expectMessage(t, conns.clientBr, `^HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n$`)
+
+	// only the upstream connection is closed, the client connection persists
+	_, err := conns.upstreamBr.ReadByte()
+	if err != io.EOF {
+		t.Error("Upstream connection not closed after response with Connection: close:", err)
+	}
+	select {
+	case <-conns.done:
+		t.Error("Client connection closed after upstream response with Connection: close")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+
+	"github.com/digital-security-lab/hwl-proxy/utils"
+)
+
+//getExpectation returns whether a request expects 100-continue and whether all of its expectations are supported.
+//Expectations of HTTP/1.0 requests are ignored.
+func getExpectation(data []byte) (bool, bool) {
+	_, _, version := utils.GetRequestLine(data)
+	if version == "HTTP/1.0" {
+		return false, true
+	}
+	expectContinue := false
+	for _, value := range utils.GetHeaderFieldValues(data, []byte("Expect")) {
+		for _, expectation := range bytes.Split(value, []byte(",")) {
+			if !bytes.EqualFold(bytes.TrimSpace(expectation), []byte("100-continue")) {
+				return false, false
+			}
+			expectContinue = true
+		}
+	}
+	return expectContinue, true
+}
+
+//isRelayedExpectation checks whether a 100-continue expectation is forwarded to the upstream.
+//Otherwise, the Expect header is removed and the proxy answers the expectation itself.
+func isRelayedExpectation(data []byte) bool {
+	return proxyConfig.ExpectContinue == "relay" && len(utils.GetHeaderFieldValues(data, []byte("Expect"))) > 0
+}
+
+//sendContinue answers a 100-continue expectation, unless the client already started sending the body.
+func sendContinue(p *pipeline) error {
+	if p.client.Reader.Buffered() > 0 {
+		return nil
+	}
+	return p.respond([]byte("HTTP/1.1 100 Continue\r\n\r\n"))
+}
+
+//awaitContinue relays interim responses of the upstream to the client until 100 Continue is received.
+//It returns false if the upstream sent a final response instead, which remains unread in the upstream reader.
+//If the upstream does not answer within the continue timeout, the body is expected to be sent anyway.
+func awaitContinue(connOut *utils.BufferedConn, connIn *utils.BufferedConn) (bool, error) {
+	connOut.SetReadTimeout(0)
+	timeouts := proxyConfig.PhaseTimeouts()
+	connOut.SetReadDeadline(timeouts.Continue.Deadline())
+	for {
+		_, err := connOut.Reader.Peek(1)
+		if err != nil {
+			if utils.IsTimeout(err) {
+				return true, nil
+			}
+			return false, err
+		}
+		connOut.SetReadDeadline(timeouts.ResponseHeader.Deadline())
+		statusLine, err := connOut.Reader.Peek(len("HTTP/1.1 100"))
+		if err != nil {
+			return false, err
+		}
+		if !utils.IsInterimResponse(statusLine) {
+			return false, nil
+		}
+		data, err := utils.ReadHeader(connOut.Reader, headerLimits())
+		if isHeaderLimitError(err) {
+			return false, errResponseTooLarge
+		}
+		if err != nil {
+			return false, err
+		}
+		err = connIn.WriteAndFlush(data)
+		if err != nil || utils.GetStatusCode(data) == 100 {
+			return err == nil, err
+		}
+		connOut.SetReadDeadline(timeouts.Continue.Deadline())
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/digital-security-lab/hwl-proxy/config"
+	"github.com/digital-security-lab/hwl-proxy/utils"
+	"github.com/digital-security-lab/hwl-proxy/whitelisting"
+)
+
+// whitelist of the expectation tests
+var expectTestWhitelist = whitelisting.Whitelist{
+	whitelisting.WhitelistItem{Key: "host"},
+	whitelisting.WhitelistItem{Key: "content-length", Val: `\d+`},
+	whitelisting.WhitelistItem{Key: "expect", Val: `100-continue`},
+}
+
+func TestExpectContinueGenerate(t *testing.T) {
+	conns, stop := startProxyTest(t, config.ProxyConfig{Whitelisting: true}, expectTestWhitelist)
+	defer stop()
+
+	conns.client.Write([]byte("POST / HTTP/1.1\r\nHost: example.com\r\nExpect: 100-continue\r\nContent-Length: 4\r\n\r\n"))
+	expectMessage(t, conns.clientBr, `^HTTP/1.1 100 Continue\r\n\r\n$`)
+	go conns.client.Write([]byte("body"))
+
+	expectMessage(t, conns.upstreamBr, `^POST / HTTP/1.1\r\nHost: example.com\r\nContent-Length: 4\r\n\r\n$`)
+	body, err := utils.ReadByContentLength(conns.upstreamBr, 4)
+	if err != nil || string(body) != "body" {
+		t.Fatal("Invalid body:", string(body), err)
+	}
+	go conns.upstream.Write(utils.CreateResponse(200, "OK", []byte("OK")))
+	expectMessage(t, conns.clientBr, `^HTTP/1.1 200 OK\r\n`)
+}
+
+func TestExpectContinueRelay(t *testing.T) {
+	conns, stop := startProxyTest(t, config.ProxyConfig{Whitelisting: true, ExpectContinue: "relay"}, expectTestWhitelist)
+	defer stop()
+
+	go conns.client.Write([]byte("POST / HTTP/1.1\r\nHost: example.com\r\nExpect: 100-continue\r\nContent-Length: 4\r\n\r\n"))
+	expectMessage(t, conns.upstreamBr, `^POST / HTTP/1.1\r\nHost: example.com\r\nExpect: 100-continue\r\nContent-Length: 4\r\n\r\n$`)
+	go conns.upstream.Write([]byte("HTTP/1.1 100 Continue\r\n\r\n"))
+	expectMessage(t, conns.clientBr, `^HTTP/1.1 100 Continue\r\n\r\n$`)
+
+	go conns.client.Write([]byte("body"))
+	body, err := utils.ReadByContentLength(conns.upstreamBr, 4)
+	if err != nil || string(body) != "body" {
+		t.Fatal("Invalid body:", string(body), err)
+	}
+	go conns.upstream.Write(append([]byte("HTTP/1.1 103 Early Hints\r\nLink: </style.css>\r\n\r\n"), utils.CreateResponse(200, "OK", []byte("OK"))...))
+	expectMessage(t, conns.clientBr, `^HTTP/1.1 103 Early Hints\r\n`)
+	expectMessage(t, conns.clientBr, `^HTTP/1.1 200 OK\r\n`)
+}
+
+func TestExpectContinueTimeout(t *testing.T) {
+	conns, stop := startProxyTest(t, config.ProxyConfig{
+		Whitelisting:   true,
+		ExpectContinue: "relay",
+		Timeouts:       config.Timeouts{Continue: config.Duration(50 * time.Millisecond)},
+	}, expectTestWhitelist)
+	defer stop()
+
+	// the body is forwarded once the upstream did not answer within the continue timeout
+	go conns.client.Write([]byte("POST / HTTP/1.1\r\nHost: example.com\r\nExpect: 100-continue\r\nContent-Length: 4\r\n\r\nbody"))
+	expectMessage(t, conns.upstreamBr, `^POST / HTTP/1.1\r\n`)
+	body, err := utils.ReadByContentLength(conns.upstreamBr, 4)
+	if err != nil || string(body) != "body" {
+		t.Fatal("Invalid body:", string(body), err)
+	}
+}
+
+func TestExpectContinueRelayFinalResponse(t *testing.T) {
+	conns, stop := startProxyTest(t, config.ProxyConfig{Whitelisting: true, ExpectContinue: "relay"}, expectTestWhitelist)
+	defer stop()
+
+	go conns.client.Write([]byte("PUT / HTTP/1.1\r\nHost: example.com\r\nExpect: 100-continue\r\nContent-Length: 4\r\n\r\n"))
+	expectMessage(t, conns.upstreamBr, `^PUT / HTTP/1.1\r\n`)
+	go conns.upstream.Write(utils.CreateResponse(413, "Content Too Large", nil))
+	expectMessage(t, conns.clientBr, `^HTTP/1.1 413 Content Too Large\r\n`)
+
+	// body is not forwarded and the connection is closed
+	select {
+	case <-conns.done:
+	case <-time.After(time.Second):
+		t.Error("Connection not closed after final response")
+	}
+}
+
+func TestExpectationFailed(t *testing.T) {
+	conns, stop := startProxyTest(t, config.ProxyConfig{Whitelisting: true}, expectTestWhitelist)
+	defer stop()
+
+	conns.client.Write([]byte("POST / HTTP/1.1\r\nHost: example.com\r\nExpect: 100-continue, something-else\r\nContent-Length: 4\r\n\r\n"))
+	expectMessage(t, conns.clientBr, `^HTTP/1.1 417 Expectation Failed\r\n`)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/digital-security-lab/hwl-proxy/utils"
+)
+
+//isAuthorizedProxyRequest checks the Proxy-Authorization header against the configured credentials.
+//Requests are always authorized if no credentials are configured.
+func isAuthorizedProxyRequest(data []byte) bool {
+	if len(proxyConfig.ProxyCredentials) == 0 {
+		return true
+	}
+	values := utils.GetHeaderFieldValues(data, []byte("Proxy-Authorization"))
+	if len(values) != 1 {
+		return false
+	}
+	fields := strings.Fields(string(values[0]))
+	if len(fields) != 2 || !strings.EqualFold(fields[0], "Basic") {
+		return false
+	}
+	credentials, err := base64.StdEncoding.DecodeString(fields[1])
+	if err != nil {
+		return false
+	}
+	authorized := false
+	for _, allowed := range proxyConfig.ProxyCredentials {
+		if subtle.ConstantTimeCompare(credentials, []byte(allowed)) == 1 {
+			authorized = true
+		}
+	}
+	return authorized
+}
+
+//prepareForwardRequest rewrites an absolute-form request to origin-form and returns it together with the target address.
+//The Host header is replaced by the authority of the request target.
+func prepareForwardRequest(data []byte) ([]byte, string, bool) {
+	_, target, _ := utils.GetRequestLine(data)
+	address, host, originForm, err := utils.ParseAbsoluteTarget(target)
+	if err != nil || !strings.Contains(target, "://") {
+		return nil, "", false
+	}
+	data = utils.SetRequestTarget(data, originForm)
+	if len(utils.GetHeaderFieldValues(data, []byte("Host"))) > 0 {
+		data = utils.SetHeaderValue(data, "Host", host, 0)
+	} else {
+		data = utils.AddHeader(data, "Host", host)
+	}
+	return data, address, true
+}
+
+//handleConnect establishes a tunnel between the client and the target of a CONNECT request.
+//The responses and the data relayed through the tunnel are recorded for the access log.
+func handleConnect(connIn *utils.BufferedConn, data []byte, ex *exchange) {
+	ex.access.endRequest(connIn)
+	_, target, _ := utils.GetRequestLine(data)
+	address, err := utils.ParseAuthorityTarget(target)
+	if err != nil {
+		ex.access.writeRejection(connIn, errorResponse(reasonInvalidTarget, ""))
+		return
+	}
+	if !utils.MatchDestination(proxyConfig.AllowedDestinations, address) {
+		ex.access.writeRejection(connIn, errorResponse(reasonForbiddenDestination, ""))
+		return
+	}
+	timeouts := proxyConfig.PhaseTimeouts()
+	ex.access.upstream = address
+	connectStart := time.Now()
+	connOut, err := net.DialTimeout("tcp", address, time.Duration(timeouts.Connect))
+	ex.access.connect = time.Since(connectStart)
+	if err != nil {
+		ex.access.outcome = "failed"
+		ex.access.writeRejection(connIn, upstreamErrorResponse(address, err))
+		return
+	}
+	defer connOut.Close()
+	ex.access.outcome = "tunneled"
+	err = ex.access.writeResponse(connIn, []byte("HTTP/1.1 200 Connection Established\r\n\r\n"))
+	if err != nil {
+		return
+	}
+
+	// The tunnel is closed once no data is received in one direction for the idle timeout.
+	// Its end is not logged, the access log records the tunnel with the outcome "tunneled".
+	// Data the client sent along with the CONNECT request is read from the buffered reader first
+	tunnelOut := utils.NewBufferedConn(connOut)
+	tunnelOut.SetReadTimeout(time.Duration(timeouts.Idle))
+	connIn.SetReadTimeout(time.Duration(timeouts.Idle))
+	go func() {
+		utils.Tunnel(tunnelOut, connIn.Conn)
+		connIn.Conn.Close()
+	}()
+	utils.Tunnel(connIn, connOut)
+	ex.access.endRequest(connIn)
+	ex.access.bytesOut += tunnelOut.BytesRead()
+}
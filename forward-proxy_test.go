@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"net"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/digital-security-lab/hwl-proxy/config"
+	"github.com/digital-security-lab/hwl-proxy/utils"
+	"github.com/digital-security-lab/hwl-proxy/whitelisting"
+)
+
+func forwardProxyTestConfig(t *testing.T, allowed string) func() {
+	previous := proxyConfig
+	proxyConfig = config.ProxyConfig{
+		Whitelisting:        true,
+		Origin:              true,
+		ForwardProxy:        true,
+		ConnTimeout:         config.Duration(5 * time.Second),
+		AllowedDestinations: []string{allowed},
+		ProxyCredentials:    []string{"user:secret"},
+	}
+	whitelist = []whitelisting.WhitelistItem{
+		whitelisting.WhitelistItem{Key: "host"},
+		whitelisting.WhitelistItem{Key: "content-length", Val: `\d+`},
+	}
+	return func() {
+		proxyConfig = previous
+	}
+}
+
+func forwardProxyTestRequest(t *testing.T, request string) (*bufio.Reader, func()) {
+	client, clientBr, stop := startClientTest(t, handleConnIncoming)
+	_, err := client.Write([]byte(request))
+	if err != nil {
+		stop()
+		t.Fatal(err)
+	}
+	return clientBr, stop
+}
+
+func TestForwardProxyAbsoluteForm(t *testing.T) {
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+	defer forwardProxyTestConfig(t, target.Addr().String())()
+
+	received := make(chan []byte, 1)
+	go func() {
+		conn, err := target.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		data, _ := utils.ReadUntilBytes(bufio.NewReader(conn), []byte("\r\n\r\n"))
+		received <- data
+		conn.Write(utils.CreateResponse(200, "OK", []byte("OK")))
+	}()
+
+	auth := base64.StdEncoding.EncodeToString([]byte("user:secret"))
+	br, closeClient := forwardProxyTestRequest(t, "GET http://"+target.Addr().String()+"/index?a=1 HTTP/1.1\r\nHost: other.example\r\nProxy-Authorization: Basic "+auth+"\r\nX-Test: 1\r\n\r\n")
+	response, err := utils.ReadUntilBytes(br, []byte("\r\n\r\n"))
+	if err != nil || !regexp.MustCompile(`^HTTP/1.1 200 OK\r\n`).Match(response) {
+		t.Error("Invalid response:", string(response), err)
+	}
+
+	closeClient()
+	forwarded := <-received
+	expected := "GET /index?a=1 HTTP/1.1\r\nHost: " + target.Addr().String() + "\r\n\r\n"
+	if string(forwarded) != expected {
+		t.Error("Forwarded request:", string(forwarded), "Expected:", expected)
+	}
+}
+
+func TestForwardProxyConnect(t *testing.T) {
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+	defer forwardProxyTestConfig(t, target.Addr().String())()
+
+	go func() {
+		conn, err := target.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		buf := make([]byte, 4)
+		n, _ := conn.Read(buf)
+		conn.Write(append([]byte("echo "), buf[:n]...))
+	}()
+
+	auth := base64.StdEncoding.EncodeToString([]byte("user:secret"))
+	br, closeClient := forwardProxyTestRequest(t, "CONNECT "+target.Addr().String()+" HTTP/1.1\r\nHost: "+target.Addr().String()+"\r\nProxy-Authorization: Basic "+auth+"\r\n\r\nping")
+	response, err := utils.ReadUntilBytes(br, []byte("\r\n\r\n"))
+	if err != nil || string(response) != "HTTP/1.1 200 Connection Established\r\n\r\n" {
+		t.Fatal("Invalid response:", string(response), err)
+	}
+	tunneled, err := utils.ReadByContentLength(br, 9)
+	if err != nil || string(tunneled) != "echo ping" {
+		t.Error("Invalid tunnel data:", string(tunneled), err)
+	}
+	closeClient()
+}
+
+func TestForwardProxyRejections(t *testing.T) {
+	defer forwardProxyTestConfig(t, "example.com:443")()
+	auth := base64.StdEncoding.EncodeToString([]byte("user:secret"))
+	wrongAuth := base64.StdEncoding.EncodeToString([]byte("user:wrong"))
+
+	tests := map[string]string{
+		"CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n":                                             `^HTTP/1.1 407 Proxy Authentication Required\r\n.*Proxy-Authenticate: Basic`,
+		"CONNECT example.com:443 HTTP/1.1\r\nProxy-Authorization: Basic " + wrongAuth + "\r\n\r\n":                      `^HTTP/1.1 407 `,
+		"CONNECT example.com:8443 HTTP/1.1\r\nProxy-Authorization: Basic " + auth + "\r\n\r\n":                          `^HTTP/1.1 403 Forbidden\r\n`,
+		"GET http://example.com/ HTTP/1.1\r\nHost: example.com\r\nProxy-Authorization: Basic " + auth + "\r\n\r\n":      `^HTTP/1.1 403 Forbidden\r\n`,
+		"GET /index HTTP/1.1\r\nHost: example.com\r\nProxy-Authorization: Basic " + auth + "\r\n\r\n":                   `^HTTP/1.1 400 Bad Request\r\n`,
+		"GET https://example.com:443/ HTTP/1.1\r\nHost: example.com\r\nProxy-Authorization: Basic " + auth + "\r\n\r\n": `^HTTP/1.1 400 Bad Request\r\n`,
+	}
+	for request, expected := range tests {
+		br, closeClient := forwardProxyTestRequest(t, request)
+		response, err := utils.ReadUntilBytes(br, []byte("\r\n\r\n"))
+		if err != nil || !regexp.MustCompile(`(?s)`+expected).Match(response) {
+			t.Error("Invalid response:", string(response), "Request:", request)
+		}
+		closeClient()
+	}
+
+	// CONNECT is not supported without forward-proxy mode
+	proxyConfig.ForwardProxy = false
+	br, closeClient := forwardProxyTestRequest(t, "CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n")
+	response, err := utils.ReadUntilBytes(br, []byte("\r\n\r\n"))
+	if err != nil || !regexp.MustCompile(`^HTTP/1.1 405 Method Not Allowed\r\n`).Match(response) {
+		t.Error("Invalid response:", string(response))
+	}
+	closeClient()
+}
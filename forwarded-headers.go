@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"strings"
+
+	"github.com/digital-security-lab/hwl-proxy/utils"
+)
+
+// header fields describing the clients and proxies a request passed
+var forwardedHeaderNames = []string{"Forwarded", "X-Forwarded-For", "X-Forwarded-Proto", "X-Forwarded-Host"}
+
+//forwardedValues holds the values of the Forwarded and X-Forwarded-* header fields of a request.
+type forwardedValues struct {
+	forwarded     string // elements of Forwarded sent by trusted proxies
+	forwardedFor  string // addresses of X-Forwarded-For sent by trusted proxies
+	proto         string // X-Forwarded-Proto sent by a trusted proxy
+	forwardedHost string // X-Forwarded-Host sent by a trusted proxy
+	host          string // Host of the request
+}
+
+//takeForwardedHeaders removes the Forwarded and X-Forwarded-* header fields from a request.
+//Their values are returned if the client is a trusted proxy, otherwise they are replaced by the values of the proxy.
+func takeForwardedHeaders(data []byte, client net.Addr) ([]byte, forwardedValues) {
+	var values forwardedValues
+	if utils.MatchIP(proxyConfig.TrustedProxies, client) {
+		values.forwarded = joinHeaderValues(data, "Forwarded")
+		values.forwardedFor = joinHeaderValues(data, "X-Forwarded-For")
+		values.proto = firstHeaderValue(data, "X-Forwarded-Proto")
+		values.forwardedHost = firstHeaderValue(data, "X-Forwarded-Host")
+	}
+	values.host = firstHeaderValue(data, "Host")
+	for _, name := range forwardedHeaderNames {
+		data = utils.RemoveHeader(data, name, 0)
+	}
+	return data, values
+}
+
+//addForwardedHeaders adds the configured header fields for the client to a request.
+//Values of trusted proxies are kept and the client is appended. Values of trusted proxies in header fields
+//that are not configured are forwarded unchanged, so the backend does not lose them.
+func addForwardedHeaders(data []byte, client net.Addr, values forwardedValues) []byte {
+	mode := proxyConfig.ForwardedHeaders
+	node := "unknown"
+	if ip := utils.GetIP(client); ip != nil {
+		node = ip.String()
+	}
+	host := values.forwardedHost
+	if len(host) == 0 {
+		host = values.host
+	}
+	if mode == "forwarded" || mode == "both" {
+		element := "for=" + forwardedNode(node) + ";proto=http"
+		if len(host) > 0 {
+			element += ";host=" + forwardedValue(host)
+		}
+		data = utils.AddHeader(data, "Forwarded", appendValue(values.forwarded, element))
+	} else if len(values.forwarded) > 0 {
+		data = utils.AddHeader(data, "Forwarded", values.forwarded)
+	}
+	if mode == "x-forwarded" || mode == "both" {
+		data = utils.AddHeader(data, "X-Forwarded-For", appendValue(values.forwardedFor, node))
+		proto := values.proto
+		if len(proto) == 0 {
+			proto = "http"
+		}
+		data = utils.AddHeader(data, "X-Forwarded-Proto", proto)
+		if len(host) > 0 {
+			data = utils.AddHeader(data, "X-Forwarded-Host", host)
+		}
+	} else {
+		for _, field := range [][2]string{{"X-Forwarded-For", values.forwardedFor}, {"X-Forwarded-Proto", values.proto}, {"X-Forwarded-Host", values.forwardedHost}} {
+			if len(field[1]) > 0 {
+				data = utils.AddHeader(data, field[0], field[1])
+			}
+		}
+	}
+	return data
+}
+
+//joinHeaderValues returns the values of all header fields with the name as comma-separated list.
+func joinHeaderValues(data []byte, name string) string {
+	return string(bytes.Join(utils.GetHeaderFieldValues(data, []byte(name)), []byte(", ")))
+}
+
+//firstHeaderValue returns the value of the first header field with the name.
+func firstHeaderValue(data []byte, name string) string {
+	values := utils.GetHeaderFieldValues(data, []byte(name))
+	if len(values) == 0 {
+		return ""
+	}
+	return string(values[0])
+}
+
+//appendValue appends a value to a comma-separated list.
+func appendValue(list string, value string) string {
+	if len(list) == 0 {
+		return value
+	}
+	return list + ", " + value
+}
+
+//forwardedNode formats a node of the Forwarded header field, IPv6 addresses are enclosed in brackets and quotes.
+func forwardedNode(node string) string {
+	if strings.Contains(node, ":") {
+		return `"[` + node + `]"`
+	}
+	return node
+}
+
+//forwardedValue formats a value of the Forwarded header field as token or quoted string according to RFC 7239.
+func forwardedValue(value string) string {
+	for _, c := range value {
+		if !strings.ContainsRune("!#$%&'*+-.^_`|~", c) && !(c >= '0' && c <= '9') && !(c >= 'a' && c <= 'z') && !(c >= 'A' && c <= 'Z') {
+			return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+		}
+	}
+	return value
+}
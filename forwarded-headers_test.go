@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net"
+	"testing"
+
+	"github.com/digital-security-lab/hwl-proxy/config"
+	"github.com/digital-security-lab/hwl-proxy/whitelisting"
+)
+
+func applyForwardedHeaders(data string, client net.Addr) string {
+	request, values := takeForwardedHeaders([]byte(data), client)
+	return string(addForwardedHeaders(request, client, values))
+}
+
+func TestForwardedHeaders(t *testing.T) {
+	previous := proxyConfig
+	defer func() { proxyConfig = previous }()
+	proxyConfig = config.ProxyConfig{TrustedProxies: []string{"10.0.0.0/8"}}
+
+	untrusted := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1234}
+	trusted := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1234}
+	ipv6 := &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 1234}
+	request := "GET / HTTP/1.1\r\nHost: example.com:8080\r\nForwarded: for=198.51.100.1\r\nX-Forwarded-For: 198.51.100.1\r\nX-Forwarded-Proto: https\r\nX-Forwarded-Host: example.org\r\n\r\n"
+
+	var tests = []struct {
+		mode   string
+		client net.Addr
+		data   string
+		result string
+	}{
+		{"forwarded", untrusted, request, "GET / HTTP/1.1\r\nHost: example.com:8080\r\nForwarded: for=192.0.2.1;proto=http;host=\"example.com:8080\"\r\n\r\n"},
+		{"forwarded", trusted, request, "GET / HTTP/1.1\r\nHost: example.com:8080\r\nForwarded: for=198.51.100.1, for=10.0.0.1;proto=http;host=example.org\r\nX-Forwarded-For: 198.51.100.1\r\nX-Forwarded-Proto: https\r\nX-Forwarded-Host: example.org\r\n\r\n"},
+		{"forwarded", ipv6, "GET / HTTP/1.1\r\nHost: a\r\n\r\n", "GET / HTTP/1.1\r\nHost: a\r\nForwarded: for=\"[2001:db8::1]\";proto=http;host=a\r\n\r\n"},
+		{"x-forwarded", untrusted, request, "GET / HTTP/1.1\r\nHost: example.com:8080\r\nX-Forwarded-For: 192.0.2.1\r\nX-Forwarded-Proto: http\r\nX-Forwarded-Host: example.com:8080\r\n\r\n"},
+		{"x-forwarded", trusted, request, "GET / HTTP/1.1\r\nHost: example.com:8080\r\nForwarded: for=198.51.100.1\r\nX-Forwarded-For: 198.51.100.1, 10.0.0.1\r\nX-Forwarded-Proto: https\r\nX-Forwarded-Host: example.org\r\n\r\n"},
+		{"x-forwarded", trusted, "GET / HTTP/1.1\r\nHost: a\r\nX-Forwarded-For: 198.51.100.1\r\nX-Forwarded-For: 198.51.100.2\r\n\r\n", "GET / HTTP/1.1\r\nHost: a\r\nX-Forwarded-For: 198.51.100.1, 198.51.100.2, 10.0.0.1\r\nX-Forwarded-Proto: http\r\nX-Forwarded-Host: a\r\n\r\n"},
+		{"both", untrusted, "GET / HTTP/1.1\r\nHost: a\r\n\r\n", "GET / HTTP/1.1\r\nHost: a\r\nForwarded: for=192.0.2.1;proto=http;host=a\r\nX-Forwarded-For: 192.0.2.1\r\nX-Forwarded-Proto: http\r\nX-Forwarded-Host: a\r\n\r\n"},
+	}
+	for _, test := range tests {
+		proxyConfig.ForwardedHeaders = test.mode
+		result := applyForwardedHeaders(test.data, test.client)
+		if result != test.result {
+			t.Errorf("%s from %v: got %q, expected %q", test.mode, test.client, result, test.result)
+		}
+	}
+}
+
+func TestForwardedHeadersAfterWhitelisting(t *testing.T) {
+	conns, stop := startProxyTest(t, config.ProxyConfig{Whitelisting: true, ForwardedHeaders: "x-forwarded"},
+		append(defaultTestWhitelist, whitelisting.WhitelistItem{Key: "x-forwarded-for"}))
+	defer stop()
+
+	go conns.client.Write([]byte("GET / HTTP/1.1\r\nHost: a\r\nX-Forwarded-For: 198.51.100.1\r\n\r\n"))
+	expectMessage(t, conns.upstreamBr, `^GET / HTTP/1.1\r\nHost: a\r\nX-Forwarded-For: unknown\r\nX-Forwarded-Proto: http\r\nX-Forwarded-Host: a\r\n\r\n$`)
+}
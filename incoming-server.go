@@ -1,125 +1,220 @@
 package main
 
 import (
-	"bufio"
-	"fmt"
 	"log"
 	"net"
 	"time"
 
+	"github.com/digital-security-lab/hwl-proxy/config"
 	"github.com/digital-security-lab/hwl-proxy/session"
 	"github.com/digital-security-lab/hwl-proxy/utils"
+	"github.com/digital-security-lab/hwl-proxy/whitelisting"
 )
 
 func incomingServer() {
-	server, err := net.Listen("tcp", proxyConfig.IncomingAddress)
-	log.Println("Start Incoming module server:", proxyConfig.IncomingAddress)
+	endpoint, err := proxyConfig.IncomingEndpoint()
 	if err != nil {
 		log.Fatal(err.Error())
 	}
-	for {
-		conn, err := server.Accept()
-		if err != nil {
-			log.Println(err.Error())
-			break
-		}
-		go handleConnIncoming(conn)
+	server, err := listen(endpoint)
+	log.Println("Start Incoming module server:", endpoint)
+	if err != nil {
+		log.Fatal(err.Error())
 	}
+	clients.serve(server, handleConnIncoming)
 }
 
 func handleConnIncoming(connIn net.Conn) {
 	defer connIn.Close()
-	connIn.SetDeadline(time.Now().Add(proxyConfig.ConnTimeout * time.Second))
+	connIn.SetReadDeadline(proxyConfig.PhaseTimeouts().Header.Deadline())
+	connIn, err := acceptProxyProtocol(connIn)
+	if err != nil {
+		log.Println("PROXY protocol:", err.Error())
+		return
+	}
+	if !clients.add(connIn) {
+		return
+	}
+	defer clients.remove(connIn)
+	if clientLimiter != nil {
+		err = clientLimiter.Open(connIn.RemoteAddr())
+		if err != nil {
+			rejectConnection(connIn, err)
+			return
+		}
+		defer clientLimiter.Close(connIn.RemoteAddr())
+	}
 	var connOut net.Conn
 	processIncomingRequest(connIn, connOut)
 }
 
 //Handle request from incoming connection.
 func processIncomingRequest(connIn net.Conn, connOut net.Conn) {
-	var currentSession *session.Session
-	connInBr := bufio.NewReader(connIn)
 	var ok bool
+	p := newPipeline(utils.NewBufferedConn(connIn))
+	defer p.close()
+	if connOut != nil {
+		p.setUpstream(utils.NewBufferedConn(connOut))
+	}
 	for {
-		if !proxyConfig.Origin {
-			currentSession = session.Create()
-			defer session.Remove(currentSession.ID)
-		}
+		ex := &exchange{access: accessRecord{module: "incoming"}}
 		// 1 Read headers
-		data, err := utils.ReadUntilBytes(connInBr, []byte("\r\n\r\n"))
-		if err != nil {
+		if !clients.waitForRequest(p.client, proxyConfig.PhaseTimeouts().Idle) {
+			return
+		}
+		data, err := readRequestHeader(p, ex)
+		if err != nil || !allowRequest(p, ex) {
 			return
 		}
 
 		// 2 Check request format
 		if !utils.IsRequest(data) {
-			connIn.Write(utils.CreateResponse(400, "Bad Request", []byte("Bad Request")))
+			p.reject(ex, errorResponse(reasonInvalidSyntax, ""))
+			return
+		}
+		ex.method, ex.access.target, ex.version = utils.GetRequestLine(data)
+		data, framing, err := validateFraming(data)
+		if err != nil {
+			p.reject(ex, framingErrorResponse(err))
+			return
+		}
+		if exceedsBodySize(framing) {
+			p.reject(ex, limitErrorResponse(utils.ErrBodyTooLarge))
+			return
+		}
+
+		// Forward proxy: authorize and resolve the target
+		var endpoint config.Endpoint
+		if proxyConfig.ForwardProxy {
+			if !isAuthorizedProxyRequest(data) {
+				p.reject(ex, errorResponse(reasonProxyAuthRequired, "").withHeader("Proxy-Authenticate", `Basic realm="hwl-proxy"`))
+				return
+			}
+			data = utils.RemoveHeader(data, "Proxy-Authorization", 0)
+			if ex.method == "CONNECT" {
+				if p.drain() {
+					handleConnect(p.client, data, ex)
+					finishRequest(p, ex)
+				}
+				return
+			}
+			var address string
+			data, address, ok = prepareForwardRequest(data)
+			if !ok {
+				p.reject(ex, errorResponse(reasonInvalidTarget, ""))
+				return
+			}
+			if !utils.MatchDestination(proxyConfig.AllowedDestinations, address) {
+				p.reject(ex, errorResponse(reasonForbiddenDestination, ""))
+				return
+			}
+			endpoint = config.Endpoint{Network: "tcp", Address: address}
+		} else if ex.method == "CONNECT" {
+			p.reject(ex, errorResponse(reasonMethodNotAllowed, "").withHeader("Allow", "GET, HEAD, POST, PUT, DELETE, OPTIONS, TRACE"))
+			return
+		}
+
+		// Check expectations
+		var expectContinue bool
+		expectContinue, ok = getExpectation(data)
+		if !ok {
+			p.reject(ex, errorResponse(reasonExpectationFailed, ""))
+			return
+		}
+		if expectContinue && proxyConfig.ExpectContinue != "relay" {
+			data = utils.RemoveHeader(data, "Expect", 0)
+		}
+
+		// Connection semantics
+		data, err = prepareConnectionHeaders(data, ex)
+		if err != nil {
+			p.reject(ex, errorResponse(reasonProtectedNomination, err.Error()))
 			return
 		}
 
+		// Trace context bypasses the whitelist
+		data = takeTraceContext(data, ex)
+
+		// Forwarded headers are replaced unless sent by a trusted proxy
+		var forwarded forwardedValues
+		if len(proxyConfig.ForwardedHeaders) > 0 {
+			data, forwarded = takeForwardedHeaders(data, p.client.Conn.RemoteAddr())
+		}
+
 		// 3 Header whitelisting
 		if proxyConfig.Whitelisting {
+			wl := currentWhitelist()
+			removals := explainWhitelist(wl, data)
 			if proxyConfig.Origin {
-				data, _, ok = whitelist.Apply(data)
+				data, _, ok = applyWhitelist(ex, wl, data)
 				if !ok {
-					connIn.Write(utils.CreateResponse(400, "Bad Request", []byte("Bad Request")))
+					logAudit(p, ex, wl, removals, reasonHeaderRejected)
+					p.reject(ex, errorResponse(reasonHeaderRejected, "invalid header field"))
 					return
 				}
 			} else {
-				data, currentSession.SplitData, ok = whitelist.Apply(data)
+				currentSession := session.Create()
+				ex.sessionID = currentSession.ID
+				data, currentSession.SplitData, ok = applyWhitelist(ex, wl, data)
 				if !ok {
-					connIn.Write(utils.CreateResponse(400, "Bad Request", []byte("Bad Request")))
+					session.Remove(currentSession.ID)
+					logAudit(p, ex, wl, removals, reasonHeaderRejected)
+					p.reject(ex, errorResponse(reasonHeaderRejected, "invalid header field"))
 					return
 				}
 				data = utils.AddHeader(data, "X-Message-ID", currentSession.ID)
 			}
+			if !keepsFraming(data, framing) {
+				session.Remove(ex.sessionID)
+				logAudit(p, ex, wl, removals, reasonFramingConflict)
+				p.reject(ex, framingErrorResponse(errFramingNotWhitelisted))
+				return
+			}
+			logAudit(p, ex, wl, removals, "")
 		}
+		data = addTraceContext(data, ex)
 
-		// 4 Read body
-		data, err = utils.ReadHTTPBody(connInBr, data, proxyConfig.Whitelisting)
-		if err != nil {
-			connIn.Write(utils.CreateResponse(400, "Bad Request", []byte("Bad Request")))
-			return
+		if len(proxyConfig.ForwardedHeaders) > 0 {
+			data = addForwardedHeaders(data, p.client.Conn.RemoteAddr(), forwarded)
 		}
 
-		// 5 Forward request
-		if connOut == nil {
-			// Open connection if first request
-			connOut, err = net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", proxyConfig.PortOutLocal))
-			if err != nil {
-				return
-			}
-			defer connOut.Close()
-			connOut.SetDeadline(time.Now().Add(proxyConfig.ConnTimeout * time.Second))
+		// 4 Forward request
+		connectStart := time.Now()
+		if !proxyConfig.Origin {
+			endpoint, err = proxyConfig.OutLocalEndpoint()
+		} else if !proxyConfig.ForwardProxy {
+			endpoint, err = selectUpstream(p, data, proxyConfig.OutLocalEndpoint)
 		}
-		connOut.Write(data)
-
-		// Receive response
-		err = processIncomingResponse(connOut, connIn)
+		if err == nil {
+			err = p.connectUpstream(endpoint, p.client.Conn)
+		}
+		ex.access.connect = time.Since(connectStart)
 		if err != nil {
+			session.Remove(ex.sessionID)
+			if response := upstreamErrorResponse(endpoint.Address, err); response != nil {
+				ex.access.outcome = "failed"
+				p.reject(ex, response)
+			}
+			return
+		}
+		if !p.forward(data, expectContinue, ex) || framing.Close || ex.close {
 			return
 		}
 	}
 }
 
-//Handle response from outgoing connection.
-func processIncomingResponse(connIn net.Conn, connOut net.Conn) error {
-	connInBr := bufio.NewReader(connIn)
-	// 1 Read headers
-	data, err := utils.ReadUntilBytes(connInBr, []byte("\r\n\r\n"))
-	if err != nil {
-		return err
-	}
-
-	// 2 Check request format
-	if !utils.IsResponse(data) {
-		return err
-	}
-
-	// 3 Read body
-	data, err = utils.ReadHTTPBody(connInBr, data, proxyConfig.Whitelisting)
-	if err != nil {
-		return err
+//applyWhitelist applies the whitelist to a request and records the removed header fields for the access log and metrics.
+//In report-only mode, the removed header fields are only recorded and the request is forwarded unchanged,
+//unless it is rejected for invalid syntax.
+func applyWhitelist(ex *exchange, wl whitelisting.Whitelist, data []byte) ([]byte, []byte, bool) {
+	report := isReportOnly()
+	ex.trace.whitelistStart = time.Now()
+	whitelisted, stripped, matches, ok := wl.ApplyMatches(data)
+	ex.trace.whitelistEnd = time.Now()
+	ex.access.recordWhitelist(stripped, ok, report)
+	countWhitelist(wl, stripped, matches)
+	if report && ok {
+		return data, nil, true
 	}
-	_, err = connOut.Write(data)
-	return err
+	return whitelisted, stripped, ok
 }
@@ -29,7 +29,16 @@ func ProcessIncomingRequestTest(t *testing.T, request string, testRegex string)
 		t.Error(err)
 	}
 	connIn, connOut := net.Pipe()
-	go processIncomingRequest(connIn, connOut)
+	done := make(chan bool)
+	go func() {
+		processIncomingRequest(connIn, connOut)
+		close(done)
+	}()
+	defer func() {
+		connIn.Close()
+		connOut.Close()
+		<-done
+	}()
 
 	bw := bufio.NewWriter(connOut)
 	_, err = bw.Write(reqData)
@@ -51,5 +60,7 @@ func TestProcessIncomingRequest(t *testing.T) {
 	proxyConfig.Whitelisting = true
 	ProcessIncomingRequestTest(t, "GET /index HTTP/1.1\r\nHost: 127.0.0.1\r\nConnection: keep-alive\r\n\r\n", `^GET /index HTTP/1.1\r\nHost: 127.0.0.1\r\nConnection: keep-alive\r\nX-Message-ID: \d+\r\n\r\n$`)
 	ProcessIncomingRequestTest(t, "POST /index HTTP/1.1\r\nHost: 127.0.0.1\r\nConnection: keep-alive\r\nContent-Length: 0\r\n\r\n", `^POST /index HTTP/1.1\r\nHost: 127.0.0.1\r\nConnection: keep-alive\r\nContent-Length: 0\r\nX-Message-ID: \d+\r\n\r\n$`)
+	proxyConfig.FramingPolicy = "lenient"
+	defer func() { proxyConfig.FramingPolicy = "" }()
 	ProcessIncomingRequestTest(t, "POST /index HTTP/1.1\r\nHost: 127.0.0.1\r\nConnection: keep-alive\r\nContent-Length: 0\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n", `^POST /index HTTP/1.1\r\nHost: 127.0.0.1\r\nConnection: keep-alive\r\nTransfer-Encoding: chunked\r\nX-Message-ID: \d+\r\n\r\n0\r\n\r\n$`)
 }
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"net"
+	"os"
+
+	"github.com/digital-security-lab/hwl-proxy/config"
+)
+
+//listen listens on a TCP or Unix domain socket endpoint.
+//A Unix domain socket left behind by a previous run is removed first, unless another process still accepts connections on it.
+func listen(endpoint config.Endpoint) (net.Listener, error) {
+	if endpoint.Network == "unix" {
+		info, err := os.Stat(endpoint.Address)
+		if err == nil && info.Mode()&os.ModeSocket != 0 {
+			conn, err := net.Dial("unix", endpoint.Address)
+			if err != nil {
+				os.Remove(endpoint.Address)
+			} else {
+				conn.Close()
+			}
+		}
+	}
+	return net.Listen(endpoint.Network, endpoint.Address)
+}
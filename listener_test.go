@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/digital-security-lab/hwl-proxy/config"
+	"github.com/digital-security-lab/hwl-proxy/utils"
+)
+
+func TestUnixSocketUpstream(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hwl-proxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "app.sock")
+
+	// a stale socket file is replaced
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+	server, err := listen(config.Endpoint{Network: "unix", Address: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	go func() {
+		conn, err := server.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		data, err := utils.ReadUntilBytes(bufio.NewReader(conn), []byte("\r\n\r\n"))
+		if err != nil || string(data) != "GET / HTTP/1.1\r\nHost: a\r\n\r\n" {
+			t.Error("Unexpected request:", string(data), err)
+		}
+		conn.Write([]byte("HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok"))
+	}()
+
+	previous := proxyConfig
+	defer func() { proxyConfig = previous }()
+	proxyConfig = config.ProxyConfig{Origin: true, ConnTimeout: config.Duration(5 * time.Second), AddressOutLocal: "unix://" + path}
+
+	client, clientBr, stop := startClientTest(t, func(proxyIn net.Conn) {
+		processIncomingRequest(proxyIn, nil)
+	})
+	defer stop()
+	go client.Write([]byte("GET / HTTP/1.1\r\nHost: a\r\n\r\n"))
+	expectMessage(t, clientBr, `^HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\n$`)
+	body, err := utils.ReadByContentLength(clientBr, 2)
+	if err != nil || string(body) != "ok" {
+		t.Fatal("Invalid body:", string(body), err)
+	}
+}
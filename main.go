@@ -4,6 +4,9 @@ import (
 	"flag"
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/digital-security-lab/hwl-proxy/config"
 	"github.com/digital-security-lab/hwl-proxy/whitelisting"
@@ -12,30 +15,86 @@ import (
 var reqLog *log.Logger
 var proxyConfig config.ProxyConfig
 var whitelist whitelisting.Whitelist
+var trailerWhitelist whitelisting.Whitelist
 
 func main() {
 	// Flags
-	var configFile, whitelistFile string
 	flag.StringVar(&configFile, "c", "config.json", "config file path")
 	flag.StringVar(&whitelistFile, "wl", "whitelist.json", "whitelist file path")
+	flag.StringVar(&trailerWhitelistFile, "twl", "", "trailer whitelist file path (no trailer fields are forwarded if empty)")
 	flag.Parse()
 
 	// Load config
-	err := proxyConfig.Load(configFile)
+	err := loadConfig()
 	if err != nil {
 		log.Fatal(err)
 	}
-	err = whitelist.Load(whitelistFile)
+	err = loadWhitelists()
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	// Configure logger
-	reqLog = log.New(os.Stdout, log.Prefix(), 0)
+	upstreamPool = newUpstreamPool()
+	upstreamBalancer = newUpstreamBalancer()
+	clientLimiter = newClientLimiter()
+	rejectTemplates, err = loadRejections()
+	if err != nil {
+		log.Fatal(err)
+	}
+	configureMetrics()
+	tracer, err = newTracer()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Configure access and audit log, which are reopened on SIGUSR1 after log rotation
+	reqLog, err = newAccessLog()
+	if err != nil {
+		log.Fatal(err)
+	}
+	auditLog, err = newAuditLog()
+	if err != nil {
+		log.Fatal(err)
+	}
+	reopen := make(chan os.Signal, 1)
+	signal.Notify(reopen, syscall.SIGUSR1)
+	go func() {
+		for range reopen {
+			reopenLog(reqLog)
+			reopenLog(auditLog)
+		}
+	}()
 
 	// Start servers
 	if !proxyConfig.Origin {
 		go outgoingServer()
 	}
-	incomingServer()
+	go incomingServer()
+	if len(proxyConfig.AdminAddress) > 0 {
+		go adminServer()
+	}
+
+	// Shut down gracefully
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	select {
+	case received := <-signals:
+		log.Println("Received", received, "signal, shutting down")
+	case <-drainRequests:
+		log.Println("Received drain request, shutting down")
+	}
+	timeout := time.Duration(proxyConfig.ShutdownTimeout)
+	if timeout <= 0 {
+		timeout = defaultShutdownTimeout
+	}
+	drained := clients.shutdown(timeout)
+	upstreamPool.CloseIdle()
+	if tracer != nil {
+		tracer.Close()
+	}
+	if !drained {
+		log.Println("Shutdown incomplete, in-flight requests were interrupted")
+		os.Exit(1)
+	}
+	log.Println("Shutdown complete")
 }
@@ -0,0 +1,302 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// label value of the series that collects all label values beyond the series limit
+const OtherLabel = "other"
+
+//Registry holds metrics and writes them in the Prometheus text exposition format.
+type Registry struct {
+	metrics []metric
+	mutex   sync.Mutex
+}
+
+//metric is a metric family, which writes its samples.
+type metric interface {
+	write(w *bufio.Writer)
+}
+
+//desc describes a metric family.
+type desc struct {
+	name   string
+	help   string
+	kind   string   // "counter", "gauge" or "histogram"
+	labels []string // label names
+}
+
+//vector holds the series of a metric family by their label values.
+//If the series limit is reached, new label values are counted in a series with all labels set to OtherLabel.
+type vector struct {
+	desc
+	limit  int // maximum number of series, unlimited if 0
+	series map[string][]string
+	mutex  sync.Mutex
+}
+
+//Counter is a counter with labels.
+type Counter struct {
+	vector
+	values map[string]float64
+}
+
+//Histogram counts observations in buckets with labels.
+type Histogram struct {
+	vector
+	buckets []float64 // upper bounds in ascending order, without +Inf
+	counts  map[string][]uint64
+	sums    map[string]float64
+}
+
+//valueFunc is a counter or gauge without labels whose value is read when the metrics are written.
+type valueFunc struct {
+	desc
+	value func() float64
+}
+
+// default buckets of latency histograms in seconds
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+//NewRegistry creates an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+//NewCounter registers a counter with the label names.
+func (r *Registry) NewCounter(name string, help string, labels ...string) *Counter {
+	c := &Counter{vector: newVector(name, help, "counter", labels), values: make(map[string]float64)}
+	r.register(c)
+	return c
+}
+
+//NewHistogram registers a histogram with the bucket upper bounds and label names.
+func (r *Registry) NewHistogram(name string, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{
+		vector:  newVector(name, help, "histogram", labels),
+		buckets: buckets,
+		counts:  make(map[string][]uint64),
+		sums:    make(map[string]float64),
+	}
+	r.register(h)
+	return h
+}
+
+//NewGaugeFunc registers a gauge whose value is returned by the function.
+func (r *Registry) NewGaugeFunc(name string, help string, value func() float64) {
+	r.register(&valueFunc{desc: desc{name: name, help: help, kind: "gauge"}, value: value})
+}
+
+//NewCounterFunc registers a counter whose value is returned by the function.
+func (r *Registry) NewCounterFunc(name string, help string, value func() float64) {
+	r.register(&valueFunc{desc: desc{name: name, help: help, kind: "counter"}, value: value})
+}
+
+//register adds a metric family.
+func (r *Registry) register(m metric) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.metrics = append(r.metrics, m)
+}
+
+//Write writes all metrics in the Prometheus text exposition format.
+func (r *Registry) Write(w io.Writer) error {
+	r.mutex.Lock()
+	metrics := append([]metric(nil), r.metrics...)
+	r.mutex.Unlock()
+	bw := bufio.NewWriter(w)
+	for _, m := range metrics {
+		m.write(bw)
+	}
+	return bw.Flush()
+}
+
+//Handler returns an HTTP handler serving the metrics.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.Write(w)
+	})
+}
+
+//newVector creates a vector without series.
+func newVector(name string, help string, kind string, labels []string) vector {
+	return vector{desc: desc{name: name, help: help, kind: kind, labels: labels}, series: make(map[string][]string)}
+}
+
+//Limit sets the maximum number of series of the counter.
+func (c *Counter) Limit(limit int) *Counter {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.limit = limit
+	return c
+}
+
+//Limit sets the maximum number of series of the histogram.
+func (h *Histogram) Limit(limit int) *Histogram {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.limit = limit
+	return h
+}
+
+//key returns the key of the series with the label values, or of the other series if the limit is reached.
+//The mutex of the vector must be held.
+func (v *vector) key(values []string) string {
+	key := strings.Join(values, "\xff")
+	if _, ok := v.series[key]; ok {
+		return key
+	}
+	if v.limit > 0 && len(v.series) >= v.limit {
+		other := make([]string, len(values))
+		for i := range other {
+			other[i] = OtherLabel
+		}
+		values = other
+		key = strings.Join(values, "\xff")
+		if _, ok := v.series[key]; ok {
+			return key
+		}
+	}
+	v.series[key] = append([]string(nil), values...)
+	return key
+}
+
+//sortedKeys returns the keys of all series in order of their label values.
+func (v *vector) sortedKeys() []string {
+	keys := make([]string, 0, len(v.series))
+	for key := range v.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+//Inc increments the series with the label values.
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+//Add adds a non-negative value to the series with the label values.
+func (c *Counter) Add(value float64, values ...string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.values[c.key(values)] += value
+}
+
+//Value returns the value of the series with the label values.
+func (c *Counter) Value(values ...string) float64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.values[strings.Join(values, "\xff")]
+}
+
+//write writes the samples of all series.
+func (c *Counter) write(w *bufio.Writer) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.writeHeader(w)
+	for _, key := range c.sortedKeys() {
+		writeSample(w, c.name, c.labels, c.series[key], "", "", c.values[key])
+	}
+}
+
+//Observe adds an observation to the series with the label values.
+func (h *Histogram) Observe(observation float64, values ...string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	key := h.key(values)
+	counts, ok := h.counts[key]
+	if !ok {
+		counts = make([]uint64, len(h.buckets)+1)
+		h.counts[key] = counts
+	}
+	i := sort.SearchFloat64s(h.buckets, observation)
+	counts[i]++
+	h.sums[key] += observation
+}
+
+//write writes the cumulative buckets, sum and count of all series.
+func (h *Histogram) write(w *bufio.Writer) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.writeHeader(w)
+	for _, key := range h.sortedKeys() {
+		values := h.series[key]
+		var count uint64
+		for i, bucketCount := range h.counts[key] {
+			count += bucketCount
+			bound := math.Inf(1)
+			if i < len(h.buckets) {
+				bound = h.buckets[i]
+			}
+			writeSample(w, h.name+"_bucket", h.labels, values, "le", formatFloat(bound), float64(count))
+		}
+		writeSample(w, h.name+"_sum", h.labels, values, "", "", h.sums[key])
+		writeSample(w, h.name+"_count", h.labels, values, "", "", float64(count))
+	}
+}
+
+//write writes the current value.
+func (f *valueFunc) write(w *bufio.Writer) {
+	f.writeHeader(w)
+	writeSample(w, f.name, nil, nil, "", "", f.value())
+}
+
+//writeHeader writes the HELP and TYPE lines of the metric family.
+func (d *desc) writeHeader(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.name, strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(d.help), d.name, d.kind)
+}
+
+//writeSample writes a sample line with the labels and an optional extra label, e.g. the bucket bound.
+func writeSample(w *bufio.Writer, name string, labels []string, values []string, extraLabel string, extraValue string, value float64) {
+	w.WriteString(name)
+	if len(labels) > 0 || len(extraLabel) > 0 {
+		w.WriteByte('{')
+		for i, label := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			writeLabel(w, label, values[i])
+		}
+		if len(extraLabel) > 0 {
+			if len(labels) > 0 {
+				w.WriteByte(',')
+			}
+			writeLabel(w, extraLabel, extraValue)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(value))
+	w.WriteByte('\n')
+}
+
+//writeLabel writes a label pair with the value escaped.
+func writeLabel(w *bufio.Writer, label string, value string) {
+	w.WriteString(label)
+	w.WriteString(`="`)
+	w.WriteString(strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value))
+	w.WriteByte('"')
+}
+
+//formatFloat formats a sample value or bucket bound.
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
@@ -0,0 +1,85 @@
+package metrics_test
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/digital-security-lab/hwl-proxy/metrics"
+)
+
+//scrape returns the metrics of the registry as written by its handler.
+func scrape(t *testing.T, registry *metrics.Registry) string {
+	recorder := httptest.NewRecorder()
+	registry.Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Error("Unexpected content type:", recorder.Header().Get("Content-Type"))
+	}
+	return recorder.Body.String()
+}
+
+func TestCounter(t *testing.T) {
+	registry := metrics.NewRegistry()
+	counter := registry.NewCounter("test_total", "Test counter.", "code", "path")
+	counter.Inc("200", "/b")
+	counter.Add(2, "200", "/a")
+	counter.Inc("404", `/"\`+"\n")
+
+	expected := "# HELP test_total Test counter.\n# TYPE test_total counter\n" +
+		"test_total{code=\"200\",path=\"/a\"} 2\n" +
+		"test_total{code=\"200\",path=\"/b\"} 1\n" +
+		"test_total{code=\"404\",path=\"/\\\"\\\\\\n\"} 1\n"
+	if output := scrape(t, registry); output != expected {
+		t.Error("Unexpected output:", output)
+	}
+	if counter.Value("200", "/a") != 2 {
+		t.Error("Unexpected value:", counter.Value("200", "/a"))
+	}
+}
+
+func TestCounterLimit(t *testing.T) {
+	registry := metrics.NewRegistry()
+	counter := registry.NewCounter("test_total", "Test counter.", "name").Limit(2)
+	for _, name := range []string{"a", "b", "c", "d", "a"} {
+		counter.Inc(name)
+	}
+	if counter.Value("a") != 2 || counter.Value("b") != 1 || counter.Value(metrics.OtherLabel) != 2 || counter.Value("c") != 0 {
+		t.Error("Series beyond the limit not collapsed:", scrape(t, registry))
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	registry := metrics.NewRegistry()
+	histogram := registry.NewHistogram("test_seconds", "Test histogram.", []float64{0.1, 1}, "upstream")
+	histogram.Observe(0.05, "a")
+	histogram.Observe(0.1, "a")
+	histogram.Observe(0.5, "a")
+	histogram.Observe(3, "a")
+
+	expected := "# HELP test_seconds Test histogram.\n# TYPE test_seconds histogram\n" +
+		"test_seconds_bucket{upstream=\"a\",le=\"0.1\"} 2\n" +
+		"test_seconds_bucket{upstream=\"a\",le=\"1\"} 3\n" +
+		"test_seconds_bucket{upstream=\"a\",le=\"+Inf\"} 4\n" +
+		"test_seconds_sum{upstream=\"a\"} 3.65\n" +
+		"test_seconds_count{upstream=\"a\"} 4\n"
+	if output := scrape(t, registry); output != expected {
+		t.Error("Unexpected output:", output)
+	}
+}
+
+func TestValueFuncs(t *testing.T) {
+	registry := metrics.NewRegistry()
+	value := 3.0
+	registry.NewGaugeFunc("test_current", "Test gauge.", func() float64 { return value })
+	registry.NewCounterFunc("test_events_total", "Test counter.", func() float64 { return 7 })
+	value = 4
+
+	var buf bytes.Buffer
+	err := registry.Write(&buf)
+	expected := "# HELP test_current Test gauge.\n# TYPE test_current gauge\ntest_current 4\n" +
+		"# HELP test_events_total Test counter.\n# TYPE test_events_total counter\ntest_events_total 7\n"
+	if err != nil || buf.String() != expected {
+		t.Error("Unexpected output:", buf.String(), err)
+	}
+}
@@ -1,12 +1,8 @@
 package main
 
 import (
-	"bufio"
-	"bytes"
-	"fmt"
 	"log"
 	"net"
-	"strconv"
 	"time"
 
 	"github.com/digital-security-lab/hwl-proxy/session"
@@ -16,72 +12,55 @@ import (
 
 func outgoingServer() {
 	// listen for incoming connections from intermediary
-	server, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", proxyConfig.PortInLocal))
-	log.Println("Start Outgoing module server:", fmt.Sprintf("127.0.0.1:%d", proxyConfig.PortInLocal))
+	endpoint, err := proxyConfig.InLocalEndpoint()
 	if err != nil {
 		log.Fatal(err.Error())
 	}
-	for {
-		conn, err := server.Accept()
-		if err != nil {
-			log.Println(err.Error())
-			break
-		}
-		go handleConnOutgoing(conn)
+	server, err := listen(endpoint)
+	log.Println("Start Outgoing module server:", endpoint)
+	if err != nil {
+		log.Fatal(err.Error())
 	}
+	clients.serve(server, handleConnOutgoing)
 }
 
 func handleConnOutgoing(connIn net.Conn) {
 	defer connIn.Close()
-	connIn.SetDeadline(time.Now().Add(proxyConfig.ConnTimeout * time.Second))
+	if !clients.add(connIn) {
+		return
+	}
+	defer clients.remove(connIn)
 	var connOut net.Conn
 	processOutgoingRequest(connIn, connOut)
 }
 
 //Handle request to outgoing connection.
 func processOutgoingRequest(connIn net.Conn, connOut net.Conn) {
-	connInBr := bufio.NewReader(connIn)
+	p := newPipeline(utils.NewBufferedConn(connIn))
+	defer p.close()
+	if connOut != nil {
+		p.setUpstream(utils.NewBufferedConn(connOut))
+	}
 	for {
+		ex := &exchange{access: accessRecord{module: "outgoing"}}
 		// 1 Read headers
-		data, err := utils.ReadUntilBytes(connInBr, []byte("\r\n\r\n"))
+		if !clients.waitForRequest(p.client, proxyConfig.PhaseTimeouts().Idle) {
+			return
+		}
+		data, err := readRequestHeader(p, ex)
 		if err != nil {
 			return
 		}
 
 		// 2 Check request format
 		if !utils.IsRequest(data) {
-			connIn.Write(utils.CreateResponse(400, "Bad Request", []byte("Bad Request")))
+			p.reject(ex, errorResponse(reasonInvalidSyntax, ""))
 			return
 		}
 
-		// 3 Read body
-		contentLength := utils.GetHeaderFieldValues(data, []byte("Content-Length"))
-		transferEncoding := utils.GetHeaderFieldValues(data, []byte("Transfer-Encoding"))
-		if len(transferEncoding) > 0 && bytes.Equal(transferEncoding[0], []byte("chunked")) {
-			if proxyConfig.Whitelisting {
-				data = utils.RemoveHeader(data, "Content-Length", 0)
-			}
-			body, err := utils.ReadChunks(connInBr)
-			if err != nil {
-				connIn.Write(utils.CreateResponse(400, "Bad Request", []byte("Bad Request")))
-				return
-			}
-			data = append(data, body...)
-		} else if len(contentLength) > 0 {
-			clNum, err := strconv.Atoi(string(contentLength[0]))
-			if err != nil {
-				connIn.Write(utils.CreateResponse(400, "Bad Request", []byte("Bad Request")))
-				return
-			}
-			body, err := utils.ReadByContentLength(connInBr, clNum)
-			if err != nil {
-				connIn.Write(utils.CreateResponse(400, "Bad Request", []byte("Bad Request")))
-				return
-			}
-			data = append(data, body...)
-		}
+		ex.method, ex.access.target, ex.version = utils.GetRequestLine(data)
 
-		// 4 Join headers
+		// 3 Join headers
 		if proxyConfig.Whitelisting {
 			messageIDs := utils.GetHeaderFieldValues(data, []byte("X-Message-ID"))
 			if len(messageIDs) == 1 {
@@ -97,45 +76,53 @@ func processOutgoingRequest(connIn net.Conn, connOut net.Conn) {
 				return
 			}
 		}
-		// 5 Forward request
-		if connOut == nil {
-			// Open connection if first request
-			connOut, err = net.Dial("tcp", proxyConfig.OutgoingAddress)
-			if err != nil {
-				return
-			}
-			defer connOut.Close()
-			connOut.SetDeadline(time.Now().Add(proxyConfig.ConnTimeout * time.Second))
-		}
-		connOut.Write(data)
 
-		// Receive response
-		err = processOutgoingResponse(connOut, connIn)
+		data, framing, err := validateFraming(data)
 		if err != nil {
+			p.reject(ex, framingErrorResponse(err))
+			return
+		}
+		if exceedsBodySize(framing) {
+			p.reject(ex, limitErrorResponse(utils.ErrBodyTooLarge))
 			return
 		}
-	}
-}
 
-//Handle response from outgoing connection.
-func processOutgoingResponse(connIn net.Conn, connOut net.Conn) error {
-	connInBr := bufio.NewReader(connIn)
-	// 1 Read headers
-	data, err := utils.ReadUntilBytes(connInBr, []byte("\r\n\r\n"))
-	if err != nil {
-		return err
-	}
+		// Check expectations
+		expectContinue, ok := getExpectation(data)
+		if !ok {
+			p.reject(ex, errorResponse(reasonExpectationFailed, ""))
+			return
+		}
+		if expectContinue && proxyConfig.ExpectContinue != "relay" {
+			data = utils.RemoveHeader(data, "Expect", 0)
+		}
 
-	// 2 Check request format
-	if !utils.IsResponse(data) {
-		return err
-	}
+		// Connection semantics
+		data, err = prepareConnectionHeaders(data, ex)
+		if err != nil {
+			p.reject(ex, errorResponse(reasonProtectedNomination, err.Error()))
+			return
+		}
 
-	// 3 Read body
-	data, err = utils.ReadHTTPBody(connInBr, data, proxyConfig.Whitelisting)
-	if err != nil {
-		return err
+		// Trace context is validated and forwarded regardless of the whitelist
+		data = addTraceContext(takeTraceContext(data, ex), ex)
+
+		// 4 Forward request
+		connectStart := time.Now()
+		endpoint, err := selectUpstream(p, data, proxyConfig.OutgoingEndpoint)
+		if err == nil {
+			err = p.connectUpstream(endpoint, nil)
+		}
+		ex.access.connect = time.Since(connectStart)
+		if err != nil {
+			if response := upstreamErrorResponse(endpoint.Address, err); response != nil {
+				ex.access.outcome = "failed"
+				p.reject(ex, response)
+			}
+			return
+		}
+		if !p.forward(data, expectContinue, ex) || framing.Close || ex.close {
+			return
+		}
 	}
-	_, err = connOut.Write(data)
-	return err
 }
@@ -0,0 +1,455 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/digital-security-lab/hwl-proxy/config"
+	"github.com/digital-security-lab/hwl-proxy/pool"
+	"github.com/digital-security-lab/hwl-proxy/session"
+	"github.com/digital-security-lab/hwl-proxy/utils"
+)
+
+var errPipelineFailed = errors.New("relaying a pending response failed")
+var errInvalidResponse = errors.New("invalid response")
+var errConnectionClosed = errors.New("connection closed after response")
+var errNoResponse = errors.New("no response from upstream")
+var errResponseTimeout = errors.New("upstream did not respond in time")
+var errResponseTooLarge = errors.New("response header exceeds the header limits")
+
+//exchange holds the state of a forwarded request until its response was relayed to the client.
+type exchange struct {
+	sessionID     string       // session of the request, removed after the response was relayed
+	method        string       // request method, which determines whether the response has a body
+	version       string       // request version, interim responses are not relayed to HTTP/1.0 clients
+	close         bool         // client connection is closed after the response
+	upstreamClose bool         // upstream connection is closed after the response
+	access        accessRecord // fields of the access log line
+	trace         requestTrace // trace context and spans of the request
+}
+
+//pipeline relays the responses to forwarded requests in the order the requests were received.
+//Up to depth requests are forwarded to the upstream before their responses were received.
+//The request loop owns the upstream writer and the client reader, the response loop owns the upstream reader and the client writer.
+//The request loop may only write to the client or replace the upstream after the pipeline was drained.
+//A pooled upstream connection is returned to the pool if all responses were received and the upstream keeps it alive.
+type pipeline struct {
+	client        *utils.BufferedConn
+	upstream      *utils.BufferedConn
+	pooled        *pool.Conn      // pooled connection of upstream, nil if the upstream is not pooled
+	endpoint      config.Endpoint // endpoint of the pooled connection
+	sent          int             // number of requests sent on the upstream connection
+	relayed       int             // number of responses relayed from the upstream connection
+	upstreamClose bool            // upstream connection is not reused
+	depth         int
+	pending       []*exchange
+	closed        bool
+	failed        bool
+	mutex         sync.Mutex
+	cond          *sync.Cond
+	done          chan bool
+}
+
+//newPipeline creates a pipeline for a client connection and starts relaying responses.
+func newPipeline(client *utils.BufferedConn) *pipeline {
+	p := &pipeline{
+		client: client,
+		depth:  proxyConfig.PipelineDepth,
+		done:   make(chan bool),
+	}
+	client.SetWriteTimeout(time.Duration(proxyConfig.PhaseTimeouts().Write))
+	if p.depth < 1 {
+		p.depth = 1
+	}
+	p.cond = sync.NewCond(&p.mutex)
+	go p.relayResponses()
+	return p
+}
+
+//getUpstream returns the current upstream connection or nil.
+func (p *pipeline) getUpstream() *utils.BufferedConn {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.upstream
+}
+
+//setUpstream replaces the upstream connection. The pipeline must be drained before.
+func (p *pipeline) setUpstream(upstream *utils.BufferedConn) {
+	p.replaceUpstream(upstream, nil, config.Endpoint{})
+}
+
+//setPooledUpstream replaces the upstream connection by a pooled connection to the endpoint. The pipeline must be drained before.
+func (p *pipeline) setPooledUpstream(conn *pool.Conn, endpoint config.Endpoint) {
+	p.replaceUpstream(conn.BufferedConn, conn, endpoint)
+}
+
+//replaceUpstream releases or closes the current upstream connection and sets the new one.
+func (p *pipeline) replaceUpstream(upstream *utils.BufferedConn, pooled *pool.Conn, endpoint config.Endpoint) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.pooled != nil {
+		p.pooled.Release(!p.upstreamClose && p.sent == p.relayed)
+	} else if p.upstream != nil {
+		p.upstream.Close()
+	}
+	p.upstream = upstream
+	p.pooled = pooled
+	p.endpoint = endpoint
+	p.sent = 0
+	p.relayed = 0
+	p.upstreamClose = false
+}
+
+//upstreamAddress returns the address of the current upstream, which is logged on upstream failures.
+func (p *pipeline) upstreamAddress() string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if len(p.endpoint.Address) > 0 {
+		return p.endpoint.Address
+	}
+	if p.upstream != nil {
+		return p.upstream.Conn.RemoteAddr().String()
+	}
+	return ""
+}
+
+//currentEndpoint returns the endpoint of the pooled upstream connection, if it can be used for further requests.
+func (p *pipeline) currentEndpoint() (config.Endpoint, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.endpoint, p.pooled != nil && !p.upstreamClose
+}
+
+//connectUpstream prepares the upstream connection for the next request to the endpoint.
+//The current connection is kept, unless the upstream closes it or the request is sent to another endpoint,
+//e.g. a forward proxy target or another balanced upstream.
+//Otherwise, a pooled connection is acquired once the pending responses were relayed.
+func (p *pipeline) connectUpstream(endpoint config.Endpoint, proxyClient net.Conn) error {
+	if !p.reserve() {
+		return errPipelineFailed
+	}
+	p.mutex.Lock()
+	keep := p.upstream != nil && !p.upstreamClose && (p.pooled == nil || endpoint == p.endpoint)
+	p.mutex.Unlock()
+	if keep {
+		return nil
+	}
+	if !p.drain() {
+		return errPipelineFailed
+	}
+	p.setUpstream(nil)
+	conn, err := acquireUpstream(endpoint, proxyClient)
+	if err != nil {
+		if err != pool.ErrPoolExhausted {
+			reportUpstream(endpoint, false)
+		}
+		return err
+	}
+	p.setPooledUpstream(conn, endpoint)
+	return nil
+}
+
+//wait blocks until less than n requests are pending. It returns false if relaying a response failed.
+func (p *pipeline) wait(n int) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for len(p.pending) >= n && !p.failed {
+		p.cond.Wait()
+	}
+	return !p.failed
+}
+
+//reserve blocks until another request can be forwarded.
+func (p *pipeline) reserve() bool {
+	return p.wait(p.depth)
+}
+
+//drain blocks until all pending responses were relayed to the client.
+func (p *pipeline) drain() bool {
+	return p.wait(1)
+}
+
+//push adds a forwarded request, whose response is relayed next.
+func (p *pipeline) push(ex *exchange) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.pending = append(p.pending, ex)
+	p.cond.Broadcast()
+}
+
+//respond writes a response generated by the proxy after all pending responses were relayed.
+func (p *pipeline) respond(response []byte) error {
+	if !p.drain() {
+		return errPipelineFailed
+	}
+	return p.client.WriteAndFlush(response)
+}
+
+//reject answers a request with an error response generated by the proxy after all pending responses were relayed and logs it.
+func (p *pipeline) reject(ex *exchange, r *rejection) error {
+	if !p.drain() {
+		return errPipelineFailed
+	}
+	ex.access.endRequest(p.client)
+	err := ex.access.writeRejection(p.client, r)
+	finishRequest(p, ex)
+	return err
+}
+
+//forward reads the body of a request from the client and forwards the request to the upstream.
+//A 100-continue expectation is answered by the proxy or, if relayed, the body is forwarded after the upstream accepted it.
+//It returns false if no further requests can be read from the client.
+func (p *pipeline) forward(data []byte, expectContinue bool, ex *exchange) bool {
+	pushed := false
+	defer func() {
+		if !pushed && len(ex.sessionID) > 0 {
+			session.Remove(ex.sessionID)
+		}
+	}()
+	dechunk := isDechunkedRequest(data)
+	relayContinue := expectContinue && utils.HasBody(data) && isRelayedExpectation(data) && !dechunk
+	if dechunk {
+		// the proxy reads the whole body before forwarding, so it answers the expectation itself
+		data = utils.RemoveHeader(data, "Expect", 0)
+	}
+	header := data
+	ex.access.forwardStart = time.Now()
+	ex.access.upstream = p.upstreamAddress()
+	p.client.SetReadTimeout(time.Duration(proxyConfig.PhaseTimeouts().Body))
+	p.client.SetMinReadRate(proxyConfig.MinBodyRate, minRateGrace())
+	p.mutex.Lock()
+	upstream := p.upstream
+	p.sent++
+	p.mutex.Unlock()
+
+	if !relayContinue {
+		if expectContinue && utils.HasBody(data) && sendContinue(p) != nil {
+			return false
+		}
+		if !p.reserve() {
+			return false
+		}
+		var err error
+		if dechunk {
+			err = forwardDechunked(upstream, p.client, header)
+		} else {
+			err = forwardBody(upstream, p.client, header, true)
+		}
+		if err != nil {
+			p.reject(ex, bodyErrorResponse(err))
+			return false
+		}
+		ex.access.forwardEnd = time.Now()
+		ex.access.endRequest(p.client)
+		p.push(ex)
+		pushed = true
+		return true
+	}
+
+	// Forward body only after the upstream accepted the expectation
+	if !p.drain() {
+		return false
+	}
+	var ok bool
+	err := upstream.WriteAndFlush(header)
+	if err == nil {
+		ok, err = awaitContinue(upstream, p.client)
+	}
+	if err != nil {
+		// no final response was relayed yet
+		ex.access.outcome = "failed"
+		p.reject(ex, upstreamErrorResponse(p.upstreamAddress(), err))
+		return false
+	}
+	// the client sends the body only after the interim response
+	p.client.SetMinReadRate(proxyConfig.MinBodyRate, minRateGrace())
+	if !ok {
+		// the final response is relayed, but the connections are closed as the body was not read
+		ex.upstreamClose = true
+		ex.access.forwardEnd = time.Now()
+		ex.access.endRequest(p.client)
+		p.push(ex)
+		pushed = true
+		return false
+	}
+	err = forwardBody(upstream, p.client, header, false)
+	if err != nil {
+		p.reject(ex, bodyErrorResponse(err))
+		return false
+	}
+	ex.access.forwardEnd = time.Now()
+	ex.access.endRequest(p.client)
+	p.push(ex)
+	pushed = true
+	return true
+}
+
+//forwardBody streams the request body from the client to the upstream, optionally preceded by the request headers.
+//If the body cannot be read, the upstream connection is closed, as the request cannot be completed anymore.
+func forwardBody(upstream *utils.BufferedConn, client *utils.BufferedConn, header []byte, withHeader bool) error {
+	if withHeader {
+		_, err := upstream.Write(header)
+		if err != nil {
+			return err
+		}
+	}
+	_, err := utils.CopyHTTPBody(upstream, client.Reader, header, requestChunkOptions())
+	if err == nil {
+		err = upstream.Flush()
+	}
+	if err != nil {
+		upstream.Conn.Close()
+	}
+	return err
+}
+
+//close waits until all pending responses were relayed and closes the upstream connection.
+func (p *pipeline) close() {
+	p.mutex.Lock()
+	p.closed = true
+	p.cond.Broadcast()
+	p.mutex.Unlock()
+	<-p.done
+	p.setUpstream(nil)
+}
+
+//relayResponses relays responses for pending requests until the pipeline is closed.
+//If relaying fails, both connections are closed, as the order of the remaining responses is lost.
+func (p *pipeline) relayResponses() {
+	defer close(p.done)
+	for {
+		p.mutex.Lock()
+		for len(p.pending) == 0 && !p.closed {
+			p.cond.Wait()
+		}
+		if len(p.pending) == 0 {
+			p.mutex.Unlock()
+			return
+		}
+		ex := p.pending[0]
+		upstream := p.upstream
+		endpoint := p.endpoint
+		p.mutex.Unlock()
+
+		written := p.client.BytesWritten()
+		closeAfter, err := processResponse(upstream, p.client, ex)
+		if err == nil {
+			reportUpstream(endpoint, true)
+		} else if isResponseError(err) {
+			reportUpstream(endpoint, false)
+		}
+		if isResponseError(err) {
+			// no part of the final response was relayed yet
+			ex.access.outcome = "failed"
+			if response := upstreamErrorResponse(p.upstreamAddress(), err); response != nil {
+				ex.access.writeRejection(p.client, response)
+			}
+		}
+		if err == nil {
+			err = p.client.Flush()
+		}
+		if len(ex.sessionID) > 0 {
+			session.Remove(ex.sessionID)
+		}
+		ex.access.bytesOut = p.client.BytesWritten() - written
+		finishRequest(p, ex)
+
+		p.mutex.Lock()
+		p.pending = p.pending[1:]
+		if err == nil {
+			p.relayed++
+			if ex.upstreamClose {
+				// the upstream does not expect further requests on the connection
+				p.upstreamClose = true
+				upstream.Conn.Close()
+			}
+			if closeAfter {
+				err = errConnectionClosed
+			}
+		} else {
+			p.upstreamClose = true
+		}
+		if err != nil {
+			p.failed = true
+			for _, remaining := range p.pending {
+				if len(remaining.sessionID) > 0 {
+					session.Remove(remaining.sessionID)
+				}
+			}
+			p.pending = nil
+			p.client.Conn.Close()
+			if upstream != nil && (p.upstreamClose || p.sent != p.relayed) {
+				upstream.Conn.Close()
+			}
+		}
+		p.cond.Broadcast()
+		p.mutex.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+//isResponseError checks whether the upstream did not send a valid response header, so nothing was relayed yet.
+func isResponseError(err error) bool {
+	return err == errNoResponse || err == errInvalidResponse || err == errResponseTimeout || err == errResponseTooLarge
+}
+
+//processResponse reads a response from the upstream and writes it to the client.
+//Interim responses are forwarded until the final response is received.
+//Each response header must be received within the response header timeout and must not exceed the header limits.
+//It returns true if the client connection must be closed after the response.
+func processResponse(connIn *utils.BufferedConn, connOut *utils.BufferedConn, ex *exchange) (bool, error) {
+	timeouts := proxyConfig.PhaseTimeouts()
+	for {
+		// 1 Read headers
+		connIn.SetReadTimeout(0)
+		connIn.SetReadDeadline(timeouts.ResponseHeader.Deadline())
+		data, err := utils.ReadHeader(connIn.Reader, headerLimits())
+		if utils.IsTimeout(err) {
+			return false, errResponseTimeout
+		}
+		if isHeaderLimitError(err) {
+			return false, errResponseTooLarge
+		}
+		if err != nil {
+			return false, errNoResponse
+		}
+
+		// 2 Check response format
+		if !utils.IsResponse(data) {
+			return false, errInvalidResponse
+		}
+		if utils.IsInterimResponse(data) {
+			if ex.version == "HTTP/1.0" {
+				continue
+			}
+			err = connOut.WriteAndFlush(data)
+			if err != nil {
+				return false, err
+			}
+			continue
+		}
+
+		// 3 Relay body
+		ex.access.responseStart = time.Now()
+		ex.access.status = utils.GetStatusCode(data)
+		ex.access.outcome = "forwarded"
+		data, framing, err := utils.ResponseFraming(data, ex.method)
+		if err != nil {
+			return false, errInvalidResponse
+		}
+		data, closeAfter := prepareResponseConnection(data, ex)
+		_, err = connOut.Write(data)
+		if err != nil {
+			return false, err
+		}
+		connIn.SetReadTimeout(time.Duration(timeouts.ResponseBody))
+		_, err = utils.CopyBody(connOut, connIn.Reader, framing, responseChunkOptions())
+		// the body of the response ended with the upstream connection
+		ex.upstreamClose = ex.upstreamClose || framing.Close
+		return framing.Close || closeAfter, err
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bufio"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/digital-security-lab/hwl-proxy/config"
+	"github.com/digital-security-lab/hwl-proxy/utils"
+	"github.com/digital-security-lab/hwl-proxy/whitelisting"
+)
+
+// whitelist of the pipeline tests
+var pipelineTestWhitelist = whitelisting.Whitelist{
+	whitelisting.WhitelistItem{Key: "host"},
+	whitelisting.WhitelistItem{Key: "content-length", Val: `\d+`},
+}
+
+func readPipelineMessage(t *testing.T, br *bufio.Reader, expected string) {
+	data, err := utils.ReadUntilBytes(br, []byte("\r\n\r\n"))
+	if err == nil && utils.IsResponse(data) {
+		data, err = utils.ReadHTTPBody(br, data, false)
+	}
+	if err != nil || string(data) != expected {
+		t.Fatal("Unexpected message:", string(data), err, "Expected:", expected)
+	}
+}
+
+func TestPipelinedRequests(t *testing.T) {
+	conns, stop := startProxyTest(t, config.ProxyConfig{Whitelisting: true, PipelineDepth: 2}, pipelineTestWhitelist)
+	defer stop()
+
+	go conns.client.Write([]byte("GET /1 HTTP/1.1\r\nHost: a\r\n\r\nGET /2 HTTP/1.1\r\nHost: a\r\n\r\n"))
+
+	// both requests are forwarded before a response was received
+	readPipelineMessage(t, conns.upstreamBr, "GET /1 HTTP/1.1\r\nHost: a\r\n\r\n")
+	readPipelineMessage(t, conns.upstreamBr, "GET /2 HTTP/1.1\r\nHost: a\r\n\r\n")
+
+	// responses arriving within a single read are relayed completely and in order
+	responses := append(utils.CreateResponse(200, "OK", []byte("1")), utils.CreateResponse(200, "OK", []byte("2"))...)
+	go conns.upstream.Write(responses)
+	readPipelineMessage(t, conns.clientBr, string(utils.CreateResponse(200, "OK", []byte("1"))))
+	readPipelineMessage(t, conns.clientBr, string(utils.CreateResponse(200, "OK", []byte("2"))))
+}
+
+func TestPipelineDepth(t *testing.T) {
+	conns, stop := startProxyTest(t, config.ProxyConfig{Whitelisting: true, PipelineDepth: 1}, pipelineTestWhitelist)
+	defer stop()
+
+	go conns.client.Write([]byte("GET /1 HTTP/1.1\r\nHost: a\r\n\r\nGET /2 HTTP/1.1\r\nHost: a\r\n\r\n"))
+	readPipelineMessage(t, conns.upstreamBr, "GET /1 HTTP/1.1\r\nHost: a\r\n\r\n")
+
+	// the second request is held back until the first response was relayed
+	conns.upstream.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	_, err := conns.upstreamBr.Peek(1)
+	if err == nil {
+		t.Fatal("Second request forwarded before the first response")
+	}
+	conns.upstream.SetDeadline(time.Now().Add(3 * time.Second))
+
+	go conns.upstream.Write(utils.CreateResponse(200, "OK", []byte("1")))
+	readPipelineMessage(t, conns.clientBr, string(utils.CreateResponse(200, "OK", []byte("1"))))
+	readPipelineMessage(t, conns.upstreamBr, "GET /2 HTTP/1.1\r\nHost: a\r\n\r\n")
+	go conns.upstream.Write(utils.CreateResponse(200, "OK", []byte("2")))
+	readPipelineMessage(t, conns.clientBr, string(utils.CreateResponse(200, "OK", []byte("2"))))
+}
+
+func TestPipelineErrorResponseOrder(t *testing.T) {
+	conns, stop := startProxyTest(t, config.ProxyConfig{Whitelisting: true, PipelineDepth: 2}, pipelineTestWhitelist)
+	defer stop()
+
+	// the error response to the invalid second request follows the response to the first request
+	go conns.client.Write([]byte("GET /1 HTTP/1.1\r\nHost: a\r\n\r\nGET /2 HTTP/1.1\r\nHost\r: a\r\n\r\n"))
+	readPipelineMessage(t, conns.upstreamBr, "GET /1 HTTP/1.1\r\nHost: a\r\n\r\n")
+	go conns.upstream.Write(utils.CreateResponse(200, "OK", []byte("1")))
+	readPipelineMessage(t, conns.clientBr, string(utils.CreateResponse(200, "OK", []byte("1"))))
+	readPipelineMessage(t, conns.clientBr, string(errorResponse(reasonHeaderRejected, "invalid header field").response))
+}
+
+func TestStreamedRequestBody(t *testing.T) {
+	conns, stop := startProxyTest(t, config.ProxyConfig{Whitelisting: true, PipelineDepth: 1}, pipelineTestWhitelist)
+	defer stop()
+
+	// the first part of the body is forwarded before the conns.client sent the rest
+	go conns.client.Write([]byte("POST /upload HTTP/1.1\r\nHost: a\r\nContent-Length: 10\r\n\r\nfirst"))
+	readPipelineMessage(t, conns.upstreamBr, "POST /upload HTTP/1.1\r\nHost: a\r\nContent-Length: 10\r\n\r\n")
+	body, err := utils.ReadByContentLength(conns.upstreamBr, 5)
+	if err != nil || string(body) != "first" {
+		t.Fatal("Invalid body:", string(body), err)
+	}
+	go conns.client.Write([]byte("after"))
+	body, err = utils.ReadByContentLength(conns.upstreamBr, 5)
+	if err != nil || string(body) != "after" {
+		t.Fatal("Invalid body:", string(body), err)
+	}
+}
+
+func TestStreamedResponseChunks(t *testing.T) {
+	conns, stop := startProxyTest(t, config.ProxyConfig{Whitelisting: true, PipelineDepth: 1}, pipelineTestWhitelist)
+	defer stop()
+
+	go conns.client.Write([]byte("GET /events HTTP/1.1\r\nHost: a\r\n\r\n"))
+	readPipelineMessage(t, conns.upstreamBr, "GET /events HTTP/1.1\r\nHost: a\r\n\r\n")
+
+	// each event is relayed to the conns.client before the conns.upstream sent the next one
+	go conns.upstream.Write([]byte("HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n"))
+	header, err := utils.ReadUntilBytes(conns.clientBr, []byte("\r\n\r\n"))
+	if err != nil || string(header) != "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n" {
+		t.Fatal("Invalid response header:", string(header), err)
+	}
+	for _, chunk := range []string{"9\r\ndata: 1\n\n\r\n", "9\r\ndata: 2\n\n\r\n"} {
+		go conns.upstream.Write([]byte(chunk))
+		received, err := utils.ReadByContentLength(conns.clientBr, len(chunk))
+		if err != nil || string(received) != chunk {
+			t.Fatal("Invalid chunk:", string(received), err)
+		}
+	}
+}
+
+func TestResponseTrailers(t *testing.T) {
+	conns, stop := startProxyTest(t, config.ProxyConfig{Whitelisting: true, PipelineDepth: 1}, pipelineTestWhitelist)
+	defer stop()
+
+	go conns.client.Write([]byte("GET / HTTP/1.1\r\nHost: a\r\n\r\n"))
+	readPipelineMessage(t, conns.upstreamBr, "GET / HTTP/1.1\r\nHost: a\r\n\r\n")
+	go conns.upstream.Write([]byte("HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n" +
+		"2\r\nOK\r\n0\r\nContent-Length: 0\r\nChecksum: abc\r\nConnection: close\r\n\r\n"))
+	expected := "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n2\r\nOK\r\n0\r\nChecksum: abc\r\n\r\n"
+	received, err := utils.ReadByContentLength(conns.clientBr, len(expected))
+	if err != nil || string(received) != expected {
+		t.Fatal("Invalid response:", string(received), err)
+	}
+}
+
+func TestResponsesWithoutBody(t *testing.T) {
+	conns, stop := startProxyTest(t, config.ProxyConfig{Whitelisting: true, PipelineDepth: 1}, pipelineTestWhitelist)
+	defer stop()
+
+	go conns.client.Write([]byte("HEAD /1 HTTP/1.1\r\nHost: a\r\n\r\nGET /2 HTTP/1.1\r\nHost: a\r\n\r\nGET /3 HTTP/1.1\r\nHost: a\r\n\r\n"))
+
+	// the announced length of a response to HEAD does not apply to the message
+	readPipelineMessage(t, conns.upstreamBr, "HEAD /1 HTTP/1.1\r\nHost: a\r\n\r\n")
+	go conns.upstream.Write([]byte("HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\n"))
+	expectMessage(t, conns.clientBr, `^HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\n$`)
+
+	readPipelineMessage(t, conns.upstreamBr, "GET /2 HTTP/1.1\r\nHost: a\r\n\r\n")
+	go conns.upstream.Write([]byte("HTTP/1.1 204 No Content\r\n\r\n"))
+	expectMessage(t, conns.clientBr, `^HTTP/1.1 204 No Content\r\n\r\n$`)
+
+	readPipelineMessage(t, conns.upstreamBr, "GET /3 HTTP/1.1\r\nHost: a\r\n\r\n")
+	go conns.upstream.Write([]byte("HTTP/1.1 304 Not Modified\r\nContent-Length: 5\r\n\r\n"))
+	expectMessage(t, conns.clientBr, `^HTTP/1.1 304 Not Modified\r\nContent-Length: 5\r\n\r\n$`)
+}
+
+func TestInterimResponses(t *testing.T) {
+	conns, stop := startProxyTest(t, config.ProxyConfig{Whitelisting: true, PipelineDepth: 1}, pipelineTestWhitelist)
+	defer stop()
+
+	go conns.client.Write([]byte("GET /1 HTTP/1.1\r\nHost: a\r\n\r\nGET /2 HTTP/1.0\r\nHost: a\r\n\r\n"))
+	interim := "HTTP/1.1 102 Processing\r\n\r\nHTTP/1.1 103 Early Hints\r\nLink: </style.css>; rel=preload\r\n\r\n"
+
+	readPipelineMessage(t, conns.upstreamBr, "GET /1 HTTP/1.1\r\nHost: a\r\n\r\n")
+	go conns.upstream.Write([]byte(interim + string(utils.CreateResponse(200, "OK", []byte("1")))))
+	expectMessage(t, conns.clientBr, `^HTTP/1.1 102 Processing\r\n\r\n$`)
+	expectMessage(t, conns.clientBr, `^HTTP/1.1 103 Early Hints\r\n`)
+	readPipelineMessage(t, conns.clientBr, string(utils.CreateResponse(200, "OK", []byte("1"))))
+
+	// interim responses are not relayed to HTTP/1.0 clients
+	readPipelineMessage(t, conns.upstreamBr, "GET /2 HTTP/1.0\r\nHost: a\r\n\r\n")
+	go conns.upstream.Write([]byte(interim + string(utils.CreateResponse(200, "OK", []byte("2")))))
+	readPipelineMessage(t, conns.clientBr, "HTTP/1.1 200 OK\r\nContent-Length: 1\r\nConnection: close\r\n\r\n2")
+}
+
+func TestCloseDelimitedResponse(t *testing.T) {
+	conns, stop := startProxyTest(t, config.ProxyConfig{Whitelisting: true, PipelineDepth: 1}, pipelineTestWhitelist)
+	defer stop()
+
+	go conns.client.Write([]byte("GET / HTTP/1.1\r\nHost: a\r\n\r\n"))
+	readPipelineMessage(t, conns.upstreamBr, "GET / HTTP/1.1\r\nHost: a\r\n\r\n")
+	go func() {
+		conns.upstream.Write([]byte("HTTP/1.1 200 OK\r\n\r\nbody until close"))
+		conns.upstream.Close()
+	}()
+
+	// the body is relayed completely and the conns.client connection is closed afterwards
+	expectMessage(t, conns.clientBr, `^HTTP/1.1 200 OK\r\n\r\n$`)
+	body, err := ioutil.ReadAll(conns.clientBr)
+	if err != nil || string(body) != "body until close" {
+		t.Error("Invalid body:", string(body), err)
+	}
+}
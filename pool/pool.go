@@ -0,0 +1,278 @@
+package pool
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/digital-security-lab/hwl-proxy/utils"
+)
+
+var ErrPoolExhausted = errors.New("maximum number of upstream connections reached")
+
+//Options limits the connections of a pool.
+type Options struct {
+	MaxIdle     int           // maximum number of idle connections kept per endpoint
+	MaxOpen     int           // maximum number of open connections per endpoint, unlimited if 0
+	IdleTimeout time.Duration // idle connections are closed after this duration, unlimited if 0
+	WaitTimeout time.Duration // maximum time to wait for a connection if MaxOpen is reached, unlimited if 0
+	DialTimeout time.Duration // maximum time to establish a connection, unlimited if 0
+}
+
+//Pool keeps idle keep-alive connections to upstream endpoints, so they can be reused for subsequent clients.
+type Pool struct {
+	options Options
+	mutex   sync.Mutex
+	hosts   map[string]*host
+}
+
+//host holds the connections to a single endpoint.
+type host struct {
+	idle    []*Conn     // idle connections, the most recently used last
+	open    int         // number of open connections including idle ones
+	waiters []chan bool // callers waiting for a connection, as MaxOpen was reached
+}
+
+//Conn is a connection of a pool. It must be released once it is not used anymore.
+type Conn struct {
+	*utils.BufferedConn
+	pool      *Pool
+	key       string
+	private   bool       // connection is bound to a single client and not reused
+	idle      bool       // connection is in the idle list
+	idleSince time.Time  // time the connection became idle
+	watching  chan error // result of the read the idle connection is watched with
+}
+
+//New creates an empty pool.
+func New(options Options) *Pool {
+	return &Pool{options: options, hosts: make(map[string]*host)}
+}
+
+//Get returns an idle connection to the endpoint or dials a new one.
+//Idle connections that were closed by the endpoint, sent unexpected data or exceeded the idle timeout are discarded.
+func (pool *Pool) Get(network string, address string) (*Conn, error) {
+	key := network + "://" + address
+	for {
+		conn, err := pool.take(key, true)
+		if err != nil {
+			return nil, err
+		}
+		if conn == nil {
+			break
+		}
+		if conn.check() {
+			return conn, nil
+		}
+		pool.discard(conn)
+	}
+	return pool.open(key, network, address, false)
+}
+
+//Dial dials a new connection to the endpoint, which is closed on release instead of being reused.
+//The connection still counts towards MaxOpen.
+func (pool *Pool) Dial(network string, address string) (*Conn, error) {
+	key := network + "://" + address
+	_, err := pool.take(key, false)
+	if err != nil {
+		return nil, err
+	}
+	return pool.open(key, network, address, true)
+}
+
+//Active returns the number of connections to the endpoint that are in use.
+func (pool *Pool) Active(network string, address string) int {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	h, ok := pool.hosts[network+"://"+address]
+	if !ok {
+		return 0
+	}
+	return h.open - len(h.idle)
+}
+
+//CloseIdle closes all idle connections. Connections released afterwards are closed as well.
+func (pool *Pool) CloseIdle() {
+	pool.mutex.Lock()
+	pool.options.MaxIdle = 0
+	var idle []*Conn
+	for _, h := range pool.hosts {
+		for _, conn := range h.idle {
+			conn.idle = false
+			h.closed()
+		}
+		idle = append(idle, h.idle...)
+		h.idle = nil
+	}
+	pool.mutex.Unlock()
+	for _, conn := range idle {
+		conn.Close()
+	}
+}
+
+//take removes an idle connection from the pool if reuse is true, or reserves the opening of a new connection.
+//It waits until a connection is released if MaxOpen was reached.
+func (pool *Pool) take(key string, reuse bool) (*Conn, error) {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	h, ok := pool.hosts[key]
+	if !ok {
+		h = &host{}
+		pool.hosts[key] = h
+	}
+	for {
+		if reuse && len(h.idle) > 0 {
+			conn := h.idle[len(h.idle)-1]
+			h.idle = h.idle[:len(h.idle)-1]
+			conn.idle = false
+			return conn, nil
+		}
+		if pool.options.MaxOpen <= 0 || h.open < pool.options.MaxOpen {
+			h.open++
+			return nil, nil
+		}
+		wait := make(chan bool, 1)
+		h.waiters = append(h.waiters, wait)
+		pool.mutex.Unlock()
+		signaled := pool.await(wait)
+		pool.mutex.Lock()
+		if !signaled {
+			h.removeWaiter(wait)
+			return nil, ErrPoolExhausted
+		}
+	}
+}
+
+//await waits for a signal until the wait timeout expires.
+func (pool *Pool) await(wait chan bool) bool {
+	if pool.options.WaitTimeout <= 0 {
+		return <-wait
+	}
+	timer := time.NewTimer(pool.options.WaitTimeout)
+	defer timer.Stop()
+	select {
+	case <-wait:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+//open dials a new connection for which a slot was reserved.
+func (pool *Pool) open(key string, network string, address string, private bool) (*Conn, error) {
+	conn, err := net.DialTimeout(network, address, pool.options.DialTimeout)
+	if err != nil {
+		pool.mutex.Lock()
+		pool.hosts[key].closed()
+		pool.mutex.Unlock()
+		return nil, err
+	}
+	return &Conn{BufferedConn: utils.NewBufferedConn(conn), pool: pool, key: key, private: private}, nil
+}
+
+//discard closes a connection taken from the pool.
+func (pool *Pool) discard(conn *Conn) {
+	pool.mutex.Lock()
+	pool.hosts[conn.key].closed()
+	pool.mutex.Unlock()
+	conn.Close()
+}
+
+//Release returns the connection to the pool if reuse is true, i.e. no response is pending and the endpoint keeps it alive.
+//Otherwise, or if MaxIdle connections are idle already, the connection is closed.
+//Read and write timeouts set by the user of the connection are reset.
+func (conn *Conn) Release(reuse bool) {
+	conn.SetReadTimeout(0)
+	conn.SetWriteTimeout(0)
+	pool := conn.pool
+	pool.mutex.Lock()
+	h := pool.hosts[conn.key]
+	if !reuse || conn.private || len(h.idle) >= pool.options.MaxIdle || conn.Reader.Buffered() > 0 || conn.Writer.Buffered() > 0 {
+		h.closed()
+		pool.mutex.Unlock()
+		conn.Close()
+		return
+	}
+	conn.idle = true
+	conn.idleSince = time.Now()
+	conn.watch()
+	h.idle = append(h.idle, conn)
+	h.signal()
+	pool.mutex.Unlock()
+}
+
+//watch reads from the idle connection in the background. If the read returns while the connection is idle,
+//the endpoint closed the connection, sent unexpected data or the idle timeout expired, so the connection is removed.
+//Otherwise, the read was interrupted by check and its result is passed on.
+func (conn *Conn) watch() {
+	var deadline time.Time
+	if conn.pool.options.IdleTimeout > 0 {
+		deadline = conn.idleSince.Add(conn.pool.options.IdleTimeout)
+	}
+	conn.SetReadDeadline(deadline)
+	conn.watching = make(chan error, 1)
+	go func() {
+		_, err := conn.Reader.Peek(1)
+		pool := conn.pool
+		pool.mutex.Lock()
+		if conn.idle {
+			h := pool.hosts[conn.key]
+			h.removeIdle(conn)
+			h.closed()
+			pool.mutex.Unlock()
+			conn.Close()
+			return
+		}
+		pool.mutex.Unlock()
+		conn.watching <- err
+	}()
+}
+
+//check interrupts the watching read of a connection taken from the idle list
+//and returns whether the connection can be reused.
+func (conn *Conn) check() bool {
+	conn.SetReadDeadline(time.Unix(1, 0))
+	err := <-conn.watching
+	if !utils.IsTimeout(err) || conn.Reader.Buffered() > 0 {
+		return false
+	}
+	return conn.pool.options.IdleTimeout <= 0 || time.Since(conn.idleSince) < conn.pool.options.IdleTimeout
+}
+
+//closed releases the slot of a closed connection.
+func (h *host) closed() {
+	h.open--
+	h.signal()
+}
+
+//signal wakes up the first waiting caller.
+func (h *host) signal() {
+	if len(h.waiters) > 0 {
+		h.waiters[0] <- true
+		h.waiters = h.waiters[1:]
+	}
+}
+
+//removeWaiter removes a caller that stopped waiting. A signal it received meanwhile is passed on.
+func (h *host) removeWaiter(wait chan bool) {
+	for i, waiter := range h.waiters {
+		if waiter == wait {
+			h.waiters = append(h.waiters[:i], h.waiters[i+1:]...)
+			return
+		}
+	}
+	if len(wait) > 0 {
+		h.signal()
+	}
+}
+
+//removeIdle removes a connection from the idle list.
+func (h *host) removeIdle(conn *Conn) {
+	for i, idle := range h.idle {
+		if idle == conn {
+			h.idle = append(h.idle[:i], h.idle[i+1:]...)
+			return
+		}
+	}
+}
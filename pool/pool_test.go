@@ -0,0 +1,193 @@
+package pool_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/digital-security-lab/hwl-proxy/pool"
+)
+
+// startUpstream accepts connections and sends them on the returned channel.
+func startUpstream(t *testing.T) (net.Listener, chan net.Conn) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	accepted := make(chan net.Conn, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+		}
+	}()
+	return listener, accepted
+}
+
+func TestReuse(t *testing.T) {
+	listener, accepted := startUpstream(t)
+	defer listener.Close()
+	p := pool.New(pool.Options{MaxIdle: 1})
+	address := listener.Addr().String()
+
+	first, err := p.Get("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first.Release(true)
+	second, err := p.Get("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.LocalAddr().String() != first.LocalAddr().String() {
+		t.Error("Idle connection not reused")
+	}
+	second.Release(true)
+	<-accepted
+
+	// connections that are not reusable or exceed MaxIdle are closed
+	third, err := p.Get("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fourth, err := p.Get("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upstream := <-accepted
+	third.Release(true)
+	fourth.Release(false)
+	if third.LocalAddr().String() != first.LocalAddr().String() || fourth.LocalAddr().String() == first.LocalAddr().String() {
+		t.Error("Unexpected connections")
+	}
+	upstream.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = upstream.Read(make([]byte, 1))
+	if err == nil {
+		t.Error("Connection not closed on release")
+	}
+}
+
+func TestIdleConnectionClosed(t *testing.T) {
+	listener, accepted := startUpstream(t)
+	defer listener.Close()
+	p := pool.New(pool.Options{MaxIdle: 2})
+	address := listener.Addr().String()
+
+	closed, _ := p.Get("tcp", address)
+	unexpected, _ := p.Get("tcp", address)
+	closed.Release(true)
+	unexpected.Release(true)
+	(<-accepted).Close()
+	(<-accepted).Write([]byte("HTTP/1.1 408 Request Timeout\r\n\r\n"))
+	time.Sleep(50 * time.Millisecond)
+
+	// both idle connections are discarded and a new one is dialled
+	conn, err := p.Get("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conn.LocalAddr().String() == closed.LocalAddr().String() || conn.LocalAddr().String() == unexpected.LocalAddr().String() {
+		t.Error("Idle connection reused after the endpoint closed it or sent data")
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	listener, _ := startUpstream(t)
+	defer listener.Close()
+	p := pool.New(pool.Options{MaxIdle: 1, IdleTimeout: 20 * time.Millisecond})
+	address := listener.Addr().String()
+
+	idle, _ := p.Get("tcp", address)
+	idle.Release(true)
+	time.Sleep(50 * time.Millisecond)
+	conn, err := p.Get("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conn.LocalAddr().String() == idle.LocalAddr().String() {
+		t.Error("Idle connection reused after the idle timeout")
+	}
+}
+
+func TestMaxOpen(t *testing.T) {
+	listener, _ := startUpstream(t)
+	defer listener.Close()
+	p := pool.New(pool.Options{MaxIdle: 1, MaxOpen: 1, WaitTimeout: 20 * time.Millisecond})
+	address := listener.Addr().String()
+
+	conn, err := p.Get("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = p.Get("tcp", address)
+	if err != pool.ErrPoolExhausted {
+		t.Error("Expected ErrPoolExhausted, got", err)
+	}
+
+	// a waiting caller receives the released connection
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		conn.Release(true)
+	}()
+	waiting, err := p.Get("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if waiting.LocalAddr().String() != conn.LocalAddr().String() {
+		t.Error("Released connection not passed to the waiting caller")
+	}
+}
+
+func TestPrivateConnection(t *testing.T) {
+	listener, _ := startUpstream(t)
+	defer listener.Close()
+	p := pool.New(pool.Options{MaxIdle: 1})
+	address := listener.Addr().String()
+
+	private, err := p.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	private.Release(true)
+	conn, err := p.Get("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conn.LocalAddr().String() == private.LocalAddr().String() {
+		t.Error("Private connection reused")
+	}
+}
+
+func TestCloseIdle(t *testing.T) {
+	listener, accepted := startUpstream(t)
+	defer listener.Close()
+	p := pool.New(pool.Options{MaxIdle: 2})
+	address := listener.Addr().String()
+
+	idle, _ := p.Get("tcp", address)
+	active, _ := p.Get("tcp", address)
+	idle.Release(true)
+	p.CloseIdle()
+	active.Release(true)
+
+	// both the idle connection and the connection released afterwards are closed
+	for i := 0; i < 2; i++ {
+		upstream := <-accepted
+		upstream.SetReadDeadline(time.Now().Add(time.Second))
+		_, err := upstream.Read(make([]byte, 1))
+		if err == nil || isTimeout(err) {
+			t.Error("Connection not closed:", err)
+		}
+	}
+	if p.Active("tcp", address) != 0 {
+		t.Error("Closed connections still counted")
+	}
+}
+
+func isTimeout(err error) bool {
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/digital-security-lab/hwl-proxy/metrics"
+	"github.com/digital-security-lab/hwl-proxy/session"
+	"github.com/digital-security-lab/hwl-proxy/utils"
+	"github.com/digital-security-lab/hwl-proxy/whitelisting"
+)
+
+// maximum number of series of metrics labelled by header field name or upstream if not configured
+const defaultMaxMetricSeries = 100
+
+// metrics served on the admin listener
+var metricsRegistry = newMetricsRegistry()
+
+var requestsTotal = metricsRegistry.NewCounter("hwl_requests_total",
+	"Requests by module, outcome and status of the final response.", "module", "outcome", "status")
+var rejectionsTotal = metricsRegistry.NewCounter("hwl_rejections_total",
+	"Error responses generated by the proxy by reason.", "reason")
+var strippedHeadersTotal = metricsRegistry.NewCounter("hwl_stripped_headers_total",
+	"Header fields removed by the whitelist by lowercase field name.", "name")
+var whitelistRuleHits = metricsRegistry.NewCounter("hwl_whitelist_rule_hits_total",
+	"Header fields matching a whitelist item by index and key of the item.", "rule", "key")
+var upstreamResponseSeconds = metricsRegistry.NewHistogram("hwl_upstream_response_seconds",
+	"Time from forwarding a request until the upstream response header was received.", metrics.DefaultBuckets, "upstream")
+var upstreamErrorsTotal = metricsRegistry.NewCounter("hwl_upstream_errors_total",
+	"Requests answered by the proxy as the upstream failed, by upstream and reason.", "upstream", "reason")
+
+//newMetricsRegistry creates the registry with the gauges of the current connections and sessions.
+func newMetricsRegistry() *metrics.Registry {
+	registry := metrics.NewRegistry()
+	registry.NewGaugeFunc("hwl_active_connections", "Client connections processing a request.", func() float64 {
+		active, _ := clients.count()
+		return float64(active)
+	})
+	registry.NewGaugeFunc("hwl_idle_connections", "Client connections waiting for the next request.", func() float64 {
+		_, idle := clients.count()
+		return float64(idle)
+	})
+	registry.NewGaugeFunc("hwl_sessions", "Live sessions of requests forwarded to the intermediary.", func() float64 {
+		return float64(session.Count())
+	})
+	registry.NewCounterFunc("hwl_dropped_spans_total", "Spans that could not be exported to the collector.", func() float64 {
+		if tracer == nil {
+			return 0
+		}
+		return float64(tracer.Dropped())
+	})
+	return registry
+}
+
+//configureMetrics limits the series of metrics labelled by header field name or upstream to the configured maximum.
+func configureMetrics() {
+	limit := proxyConfig.MaxMetricSeries
+	if limit <= 0 {
+		limit = defaultMaxMetricSeries
+	}
+	strippedHeadersTotal.Limit(limit)
+	upstreamResponseSeconds.Limit(limit)
+	upstreamErrorsTotal.Limit(limit)
+}
+
+//finishRequest counts a request once it was answered, exports its spans and writes its access log line.
+//Requests forwarded to the upstream are counted with the time the upstream took to respond.
+func finishRequest(p *pipeline, ex *exchange) {
+	record := &ex.access
+	outcome := record.outcome
+	if len(outcome) == 0 {
+		outcome = "aborted"
+	}
+	requestsTotal.Inc(record.module, outcome, strconv.Itoa(record.status))
+	if record.outcome == "forwarded" && !record.forwardEnd.IsZero() {
+		upstreamResponseSeconds.Observe(seconds(record.forwardEnd, record.responseStart), record.upstream)
+	}
+	exportSpans(ex)
+	logAccess(p, ex)
+}
+
+//countWhitelist counts the header fields removed by the whitelist and the whitelist items that matched a header field.
+func countWhitelist(wl whitelisting.Whitelist, stripped []byte, matches []bool) {
+	for _, line := range strings.Split(string(stripped), "\r\n") {
+		if len(line) > 0 {
+			strippedHeadersTotal.Inc(strings.ToLower(string(utils.GetHeaderFieldName([]byte(line)))))
+		}
+	}
+	for i, matched := range matches {
+		if matched && i < len(wl) {
+			whitelistRuleHits.Inc(strconv.Itoa(i), wl[i].Key)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/digital-security-lab/hwl-proxy/config"
+	"github.com/digital-security-lab/hwl-proxy/utils"
+)
+
+//scrapeMetrics returns the metrics served on the admin listener.
+func scrapeMetrics(t *testing.T) string {
+	recorder := httptest.NewRecorder()
+	adminHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	if recorder.Code != 200 {
+		t.Fatal("Unexpected status of metrics:", recorder.Code)
+	}
+	return recorder.Body.String()
+}
+
+func TestForwardedRequestMetrics(t *testing.T) {
+	forwarded := requestsTotal.Value("incoming", "forwarded", "200")
+	stripped := strippedHeadersTotal.Value("x-unknown")
+	hits := whitelistRuleHits.Value("0", "host")
+	conns, stop := startProxyTest(t, config.ProxyConfig{Whitelisting: true}, defaultTestWhitelist)
+
+	go conns.client.Write([]byte("GET / HTTP/1.1\r\nHost: a\r\nX-Unknown: 1\r\n\r\n"))
+	expectMessage(t, conns.upstreamBr, `^GET / HTTP/1.1\r\nHost: a\r\n\r\n$`)
+	go conns.upstream.Write(utils.CreateResponse(200, "OK", []byte("hello")))
+	expectMessage(t, conns.clientBr, `^HTTP/1.1 200 OK\r\n`)
+	utils.ReadByContentLength(conns.clientBr, 5)
+	stop()
+
+	if requestsTotal.Value("incoming", "forwarded", "200") != forwarded+1 {
+		t.Error("Forwarded request not counted")
+	}
+	if strippedHeadersTotal.Value("x-unknown") != stripped+1 {
+		t.Error("Stripped header field not counted")
+	}
+	if whitelistRuleHits.Value("0", "host") != hits+1 {
+		t.Error("Whitelist rule hit not counted")
+	}
+	output := scrapeMetrics(t)
+	for _, pattern := range []string{
+		`(?m)^hwl_requests_total\{module="incoming",outcome="forwarded",status="200"\} \d+$`,
+		`(?m)^hwl_stripped_headers_total\{name="x-unknown"\} \d+$`,
+		`(?m)^hwl_whitelist_rule_hits_total\{rule="0",key="host"\} \d+$`,
+		`(?m)^hwl_upstream_response_seconds_bucket\{upstream="pipe",le="\+Inf"\} \d+$`,
+		`(?m)^hwl_active_connections \d+$`,
+		`(?m)^hwl_sessions \d+$`,
+	} {
+		if !regexp.MustCompile(pattern).MatchString(output) {
+			t.Error("Metric not found:", pattern)
+		}
+	}
+}
+
+func TestRejectedRequestMetrics(t *testing.T) {
+	rejected := requestsTotal.Value("incoming", "rejected", "400")
+	reasons := rejectionsTotal.Value(string(reasonFramingConflict))
+	conns, stop := startProxyTest(t, config.ProxyConfig{}, defaultTestWhitelist)
+
+	go conns.client.Write([]byte("POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 1\r\nContent-Length: 2\r\n\r\n"))
+	expectMessage(t, conns.clientBr, `^HTTP/1.1 400 Bad Request\r\n`)
+	stop()
+
+	if requestsTotal.Value("incoming", "rejected", "400") != rejected+1 {
+		t.Error("Rejected request not counted")
+	}
+	if rejectionsTotal.Value(string(reasonFramingConflict)) != reasons+1 {
+		t.Error("Rejection reason not counted")
+	}
+}
+
+func TestUpstreamErrorMetrics(t *testing.T) {
+	failed := upstreamErrorsTotal.Value("10.0.0.1:80", string(reasonUpstreamFailed))
+	rejected := rejectionsTotal.Value(string(reasonUpstreamFailed))
+	upstreamErrorResponse("10.0.0.1:80", errors.New("connection refused")).record()
+	if upstreamErrorsTotal.Value("10.0.0.1:80", string(reasonUpstreamFailed)) != failed+1 {
+		t.Error("Upstream error not counted")
+	}
+	if rejectionsTotal.Value(string(reasonUpstreamFailed)) != rejected {
+		t.Error("Upstream error counted as rejection")
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net"
+
+	"github.com/digital-security-lab/hwl-proxy/proxyproto"
+	"github.com/digital-security-lab/hwl-proxy/utils"
+)
+
+//acceptProxyProtocol reads the PROXY protocol header of an incoming connection from a trusted source,
+//so the connection reports the address of the original client. Connections from other sources are returned unchanged.
+func acceptProxyProtocol(conn net.Conn) (net.Conn, error) {
+	if !proxyConfig.ProxyProtocol || !utils.MatchIP(proxyConfig.ProxyProtocolFrom, conn.RemoteAddr()) {
+		return conn, nil
+	}
+	return proxyproto.NewConn(conn)
+}
+
+//sendProxyProtocol sends a PROXY protocol header with the addresses of the client connection to the upstream, if configured.
+func sendProxyProtocol(upstream net.Conn, client net.Conn) error {
+	version := 0
+	switch proxyConfig.SendProxyProtocol {
+	case "v1":
+		version = 1
+	case "v2":
+		version = 2
+	default:
+		return nil
+	}
+	header := proxyproto.NewHeader(client.RemoteAddr(), client.LocalAddr())
+	_, err := upstream.Write(header.Format(version))
+	return err
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/digital-security-lab/hwl-proxy/config"
+)
+
+func acceptProxyProtocolTest(t *testing.T, data string) (net.Conn, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		client, err := net.Dial("tcp", listener.Addr().String())
+		if err == nil {
+			client.Write([]byte(data))
+			defer client.Close()
+			time.Sleep(100 * time.Millisecond)
+		}
+	}()
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(time.Second))
+	return acceptProxyProtocol(conn)
+}
+
+func TestProxyProtocol(t *testing.T) {
+	previous := proxyConfig
+	defer func() {
+		proxyConfig = previous
+	}()
+	proxyConfig = config.ProxyConfig{ProxyProtocol: true, ProxyProtocolFrom: []string{"127.0.0.0/8"}, SendProxyProtocol: "v1"}
+
+	conn, err := acceptProxyProtocolTest(t, "PROXY TCP4 192.0.2.1 198.51.100.1 56324 80\r\nGET / HTTP/1.1\r\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if conn.RemoteAddr().String() != "192.0.2.1:56324" {
+		t.Error("Client address not taken from PROXY protocol header:", conn.RemoteAddr())
+	}
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil || line != "GET / HTTP/1.1\r\n" {
+		t.Error("Invalid request line:", line, err)
+	}
+
+	// the client address is sent to the upstream
+	upstream, proxyOut := net.Pipe()
+	defer upstream.Close()
+	go func() {
+		sendProxyProtocol(proxyOut, conn)
+		proxyOut.Close()
+	}()
+	line, err = bufio.NewReader(upstream).ReadString('\n')
+	if err != nil || line != "PROXY TCP4 192.0.2.1 198.51.100.1 56324 80\r\n" {
+		t.Error("Invalid PROXY protocol header:", line, err)
+	}
+
+	_, err = acceptProxyProtocolTest(t, "GET / HTTP/1.1\r\n")
+	if err == nil {
+		t.Error("Connection without PROXY protocol header accepted")
+	}
+
+	// untrusted sources cannot set the client address
+	proxyConfig.ProxyProtocolFrom = []string{"192.0.2.0/24"}
+	conn, err = acceptProxyProtocolTest(t, "PROXY TCP4 192.0.2.1 198.51.100.1 56324 80\r\n")
+	if err != nil || conn.RemoteAddr().String() == "192.0.2.1:56324" {
+		t.Error("PROXY protocol header of untrusted source accepted:", conn.RemoteAddr(), err)
+	}
+	conn.Close()
+}
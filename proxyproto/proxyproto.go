@@ -0,0 +1,240 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+const (
+	maxV1HeaderSize = 107 // maximum length of a version 1 header including CRLF
+
+	commandLocal = 0x0
+	commandProxy = 0x1
+
+	familyUnspec = 0x00
+	familyTCP4   = 0x11
+	familyTCP6   = 0x21
+)
+
+// signature of a version 2 header
+var v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+var ErrNoHeader = errors.New("no PROXY protocol header")
+var ErrInvalidHeader = errors.New("invalid PROXY protocol header")
+
+//Header holds the addresses of the original connection, as reported by a load balancer.
+type Header struct {
+	Version     int          // protocol version, 1 or 2
+	Source      *net.TCPAddr // address of the client, nil if unknown
+	Destination *net.TCPAddr // address the client connected to, nil if unknown
+}
+
+//NewHeader creates a header for a connection between the source and the destination.
+//The addresses are unknown if they are no TCP addresses, e.g. for Unix domain sockets.
+func NewHeader(source net.Addr, destination net.Addr) *Header {
+	header := &Header{}
+	sourceTCP, sourceOK := source.(*net.TCPAddr)
+	destinationTCP, destinationOK := destination.(*net.TCPAddr)
+	if sourceOK && destinationOK && (sourceTCP.IP.To4() == nil) == (destinationTCP.IP.To4() == nil) {
+		header.Source = sourceTCP
+		header.Destination = destinationTCP
+	}
+	return header
+}
+
+//Read reads a version 1 or version 2 header from the reader.
+func Read(br *bufio.Reader) (*Header, error) {
+	first, err := br.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	switch first[0] {
+	case 'P':
+		return readV1(br)
+	case '\r':
+		return readV2(br)
+	}
+	return nil, ErrNoHeader
+}
+
+//readV1 reads a human-readable version 1 header, e.g. "PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\n".
+func readV1(br *bufio.Reader) (*Header, error) {
+	var line []byte
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+		if len(line) >= maxV1HeaderSize {
+			return nil, ErrInvalidHeader
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, ErrInvalidHeader
+	}
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if fields[0] != "PROXY" || len(fields) < 2 {
+		return nil, ErrInvalidHeader
+	}
+	header := &Header{Version: 1}
+	if fields[1] == "UNKNOWN" {
+		return header, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, ErrInvalidHeader
+	}
+	source, err := parseV1Address(fields[2], fields[4], fields[1] == "TCP4")
+	if err != nil {
+		return nil, err
+	}
+	destination, err := parseV1Address(fields[3], fields[5], fields[1] == "TCP4")
+	if err != nil {
+		return nil, err
+	}
+	header.Source = source
+	header.Destination = destination
+	return header, nil
+}
+
+//parseV1Address parses an IP address of the given family and a port of a version 1 header.
+func parseV1Address(host string, port string, ipv4 bool) (*net.TCPAddr, error) {
+	ip := net.ParseIP(host)
+	if ip == nil || (ip.To4() != nil) != ipv4 || (ipv4 && strings.Contains(host, ":")) {
+		return nil, ErrInvalidHeader
+	}
+	number, err := strconv.ParseUint(port, 10, 16)
+	if err != nil || (len(port) > 1 && port[0] == '0') {
+		return nil, ErrInvalidHeader
+	}
+	return &net.TCPAddr{IP: ip, Port: int(number)}, nil
+}
+
+//readV2 reads a binary version 2 header. Addresses of other families than TCP over IPv4 or IPv6 are unknown.
+func readV2(br *bufio.Reader) (*Header, error) {
+	prefix := make([]byte, 16)
+	_, err := io.ReadFull(br, prefix)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(prefix[:12], v2Signature) || prefix[12]>>4 != 2 {
+		return nil, ErrInvalidHeader
+	}
+	command := prefix[12] & 0x0F
+	if command != commandLocal && command != commandProxy {
+		return nil, ErrInvalidHeader
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(prefix[14:]))
+	_, err = io.ReadFull(br, payload)
+	if err != nil {
+		return nil, err
+	}
+	header := &Header{Version: 2}
+	if command == commandLocal {
+		return header, nil
+	}
+	switch prefix[13] {
+	case familyTCP4:
+		if len(payload) < 12 {
+			return nil, ErrInvalidHeader
+		}
+		header.Source = &net.TCPAddr{IP: net.IP(payload[0:4]), Port: int(binary.BigEndian.Uint16(payload[8:]))}
+		header.Destination = &net.TCPAddr{IP: net.IP(payload[4:8]), Port: int(binary.BigEndian.Uint16(payload[10:]))}
+	case familyTCP6:
+		if len(payload) < 36 {
+			return nil, ErrInvalidHeader
+		}
+		header.Source = &net.TCPAddr{IP: net.IP(payload[0:16]), Port: int(binary.BigEndian.Uint16(payload[32:]))}
+		header.Destination = &net.TCPAddr{IP: net.IP(payload[16:32]), Port: int(binary.BigEndian.Uint16(payload[34:]))}
+	}
+	return header, nil
+}
+
+//Format encodes the header in the given protocol version.
+func (header *Header) Format(version int) []byte {
+	if version == 2 {
+		return header.formatV2()
+	}
+	if header.Source == nil || header.Destination == nil {
+		return []byte("PROXY UNKNOWN\r\n")
+	}
+	family := "TCP4"
+	if header.Source.IP.To4() == nil {
+		family = "TCP6"
+	}
+	return []byte(fmt.Sprintf("PROXY %s %s %s %d %d\r\n", family, header.Source.IP, header.Destination.IP, header.Source.Port, header.Destination.Port))
+}
+
+//formatV2 encodes the header in the binary version 2 format.
+func (header *Header) formatV2() []byte {
+	data := append([]byte{}, v2Signature...)
+	if header.Source == nil || header.Destination == nil {
+		return append(data, 0x20|commandLocal, familyUnspec, 0, 0)
+	}
+	var addresses []byte
+	family := byte(familyTCP4)
+	if source := header.Source.IP.To4(); source != nil {
+		addresses = append(append(addresses, source...), header.Destination.IP.To4()...)
+	} else {
+		family = familyTCP6
+		addresses = append(append(addresses, header.Source.IP.To16()...), header.Destination.IP.To16()...)
+	}
+	ports := make([]byte, 4)
+	binary.BigEndian.PutUint16(ports, uint16(header.Source.Port))
+	binary.BigEndian.PutUint16(ports[2:], uint16(header.Destination.Port))
+	addresses = append(addresses, ports...)
+	length := make([]byte, 2)
+	binary.BigEndian.PutUint16(length, uint16(len(addresses)))
+	data = append(data, 0x20|commandProxy, family)
+	data = append(data, length...)
+	return append(data, addresses...)
+}
+
+//Conn is a connection whose addresses are taken from the PROXY protocol header received first.
+type Conn struct {
+	net.Conn
+	reader *bufio.Reader
+	Header *Header
+}
+
+//NewConn reads the PROXY protocol header of a connection.
+//Data the client sent after the header is read from the connection afterwards.
+func NewConn(conn net.Conn) (*Conn, error) {
+	reader := bufio.NewReader(conn)
+	header, err := Read(reader)
+	if err != nil {
+		return nil, err
+	}
+	return &Conn{Conn: conn, reader: reader, Header: header}, nil
+}
+
+//Read reads data following the PROXY protocol header.
+func (conn *Conn) Read(b []byte) (int, error) {
+	return conn.reader.Read(b)
+}
+
+//RemoteAddr returns the address of the original client, if known.
+func (conn *Conn) RemoteAddr() net.Addr {
+	if conn.Header.Source != nil {
+		return conn.Header.Source
+	}
+	return conn.Conn.RemoteAddr()
+}
+
+//LocalAddr returns the address the original client connected to, if known.
+func (conn *Conn) LocalAddr() net.Addr {
+	if conn.Header.Destination != nil {
+		return conn.Header.Destination
+	}
+	return conn.Conn.LocalAddr()
+}
@@ -0,0 +1,111 @@
+package proxyproto_test
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/digital-security-lab/hwl-proxy/proxyproto"
+)
+
+func TestReadV1(t *testing.T) {
+	br := bufio.NewReader(bytes.NewBufferString("PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\nGET / HTTP/1.1\r\n"))
+	header, err := proxyproto.Read(br)
+	if err != nil || header.Version != 1 || header.Source.String() != "192.0.2.1:56324" || header.Destination.String() != "198.51.100.1:443" {
+		t.Fatal("Invalid header:", header, err)
+	}
+	rest, _ := br.ReadString('\n')
+	if rest != "GET / HTTP/1.1\r\n" {
+		t.Error("Data after header not preserved:", rest)
+	}
+
+	header, err = proxyproto.Read(bufio.NewReader(bytes.NewBufferString("PROXY TCP6 2001:db8::1 2001:db8::2 1 2\r\n")))
+	if err != nil || header.Source.String() != "[2001:db8::1]:1" {
+		t.Error("Invalid header:", header, err)
+	}
+
+	header, err = proxyproto.Read(bufio.NewReader(bytes.NewBufferString("PROXY UNKNOWN ffff::1 ffff::2 1 2\r\n")))
+	if err != nil || header.Source != nil {
+		t.Error("Invalid header:", header, err)
+	}
+
+	invalid := []string{
+		"PROXY TCP4 192.0.2.1 198.51.100.1 56324\r\n",
+		"PROXY TCP4 2001:db8::1 198.51.100.1 1 2\r\n",
+		"PROXY TCP6 192.0.2.1 198.51.100.1 1 2\r\n",
+		"PROXY TCP4 192.0.2.1 198.51.100.1 65536 2\r\n",
+		"PROXY TCP4 192.0.2.1 198.51.100.1 01 2\r\n",
+		"PROXY TCP4 192.0.2.1 198.51.100.1 1 2\n",
+		"PROXY UDP4 192.0.2.1 198.51.100.1 1 2\r\n",
+		"PROXY TCP4 " + string(bytes.Repeat([]byte("1"), 100)) + "\r\n",
+		"GET / HTTP/1.1\r\n",
+	}
+	for _, data := range invalid {
+		_, err := proxyproto.Read(bufio.NewReader(bytes.NewBufferString(data)))
+		if err == nil {
+			t.Error("Error not returned for header:", data)
+		}
+	}
+}
+
+func TestReadV2(t *testing.T) {
+	data := []byte("\r\n\r\n\x00\r\nQUIT\n\x21\x11\x00\x10" + "\xc0\x00\x02\x01" + "\xc6\x33\x64\x01" + "\xdc\x04\x01\xbb" + "\x03\x00\x01\x00")
+	br := bufio.NewReader(bytes.NewBuffer(append(data, []byte("GET")...)))
+	header, err := proxyproto.Read(br)
+	if err != nil || header.Version != 2 || header.Source.String() != "192.0.2.1:56324" || header.Destination.String() != "198.51.100.1:443" {
+		t.Fatal("Invalid header:", header, err)
+	}
+	rest := make([]byte, 3)
+	br.Read(rest)
+	if string(rest) != "GET" {
+		t.Error("Data after header not preserved:", string(rest))
+	}
+
+	// LOCAL command, e.g. health checks of the load balancer
+	header, err = proxyproto.Read(bufio.NewReader(bytes.NewBufferString("\r\n\r\n\x00\r\nQUIT\n\x20\x00\x00\x00")))
+	if err != nil || header.Source != nil {
+		t.Error("Invalid header:", header, err)
+	}
+
+	invalid := []string{
+		"\r\n\r\n\x00\r\nQUIT\n\x11\x11\x00\x00",
+		"\r\n\r\n\x00\r\nQUIX\n\x21\x11\x00\x00",
+		"\r\n\r\n\x00\r\nQUIT\n\x21\x11\x00\x04\x00\x00\x00\x00",
+		"\r\n\r\n\x00\r\nQUIT\n\x21\x11\x00\x0c\x00\x00",
+	}
+	for _, data := range invalid {
+		_, err := proxyproto.Read(bufio.NewReader(bytes.NewBufferString(data)))
+		if err == nil {
+			t.Error("Error not returned for header:", []byte(data))
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	source := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 56324}
+	destination := &net.TCPAddr{IP: net.ParseIP("198.51.100.1"), Port: 443}
+	header := proxyproto.NewHeader(source, destination)
+	if string(header.Format(1)) != "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n" {
+		t.Error("Invalid v1 header:", string(header.Format(1)))
+	}
+	for _, version := range []int{1, 2} {
+		parsed, err := proxyproto.Read(bufio.NewReader(bytes.NewBuffer(header.Format(version))))
+		if err != nil || parsed.Source.String() != source.String() || parsed.Destination.String() != destination.String() {
+			t.Error("Invalid header:", version, parsed, err)
+		}
+	}
+
+	source = &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 1}
+	destination = &net.TCPAddr{IP: net.ParseIP("2001:db8::2"), Port: 2}
+	parsed, err := proxyproto.Read(bufio.NewReader(bytes.NewBuffer(proxyproto.NewHeader(source, destination).Format(2))))
+	if err != nil || parsed.Source.String() != source.String() || parsed.Destination.String() != destination.String() {
+		t.Error("Invalid header:", parsed, err)
+	}
+
+	// addresses of Unix domain sockets are unknown
+	header = proxyproto.NewHeader(&net.UnixAddr{Name: "/run/a.sock", Net: "unix"}, destination)
+	if string(header.Format(1)) != "PROXY UNKNOWN\r\n" || !bytes.Equal(header.Format(2), []byte("\r\n\r\n\x00\r\nQUIT\n\x20\x00\x00\x00")) {
+		t.Error("Invalid header for unknown addresses:", header.Format(1), header.Format(2))
+	}
+}
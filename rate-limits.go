@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net"
+	"time"
+
+	"github.com/digital-security-lab/hwl-proxy/ratelimit"
+	"github.com/digital-security-lab/hwl-proxy/utils"
+)
+
+// request rate and connection limits of the clients of the incoming module, nil if no limits are configured
+var clientLimiter *ratelimit.Limiter
+
+//newClientLimiter creates the limiter of the configured request rate and connection limits.
+//It returns nil if no limits are configured.
+func newClientLimiter() *ratelimit.Limiter {
+	if proxyConfig.RequestRate <= 0 && proxyConfig.GlobalRequestRate <= 0 && proxyConfig.MaxClientConns <= 0 && proxyConfig.MaxConns <= 0 {
+		return nil
+	}
+	return ratelimit.New(ratelimit.Options{
+		Rate:          proxyConfig.RequestRate,
+		Burst:         proxyConfig.RequestBurst,
+		GlobalRate:    proxyConfig.GlobalRequestRate,
+		GlobalBurst:   proxyConfig.GlobalRequestBurst,
+		MaxConns:      proxyConfig.MaxClientConns,
+		MaxTotalConns: proxyConfig.MaxConns,
+		Exempt:        proxyConfig.RateLimitExempt,
+	})
+}
+
+//allowRequest checks the request rate limits of the client. Otherwise, the request is rejected and the connection closed.
+func allowRequest(p *pipeline, ex *exchange) bool {
+	if clientLimiter == nil {
+		return true
+	}
+	err := clientLimiter.Allow(p.client.Conn.RemoteAddr())
+	if err != nil {
+		p.reject(ex, rateLimitResponse(err))
+		return false
+	}
+	return true
+}
+
+//rejectConnection answers the first request of a connection exceeding a connection limit before it is closed.
+//The request header is read first, so the client receives the response instead of a connection reset.
+func rejectConnection(conn net.Conn, err error) {
+	client := utils.NewBufferedConn(conn)
+	client.SetReadDeadline(proxyConfig.PhaseTimeouts().Header.Deadline())
+	client.SetWriteTimeout(time.Duration(proxyConfig.PhaseTimeouts().Write))
+	_, readErr := utils.ReadHeader(client.Reader, headerLimits())
+	if readErr == nil {
+		response := rateLimitResponse(err)
+		client.WriteAndFlush(response.response)
+		response.record()
+	}
+}
+
+//rateLimitResponse returns the response to a request exceeding a limit.
+//Limits of the client are answered with 429 Too Many Requests, global limits with 503 Service Unavailable.
+//The connection is closed after the response.
+func rateLimitResponse(err error) *rejection {
+	reason := reasonOverloaded
+	if err == ratelimit.ErrRateLimited || err == ratelimit.ErrTooManyConnections {
+		reason = reasonRateLimited
+	}
+	return errorResponse(reason, err.Error()).withHeader("Retry-After", "1").withHeader("Connection", "close")
+}
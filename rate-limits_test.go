@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/digital-security-lab/hwl-proxy/config"
+	"github.com/digital-security-lab/hwl-proxy/utils"
+)
+
+// startRateLimitTest starts the incoming module with the limits of the configuration in front of an upstream answering each request.
+func startRateLimitTest(t *testing.T, testConfig config.ProxyConfig) (string, func()) {
+	upstream, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := upstream.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				br := bufio.NewReader(conn)
+				for {
+					if _, err := utils.ReadUntilBytes(br, []byte("\r\n\r\n")); err != nil {
+						return
+					}
+					conn.Write(utils.CreateResponse(200, "OK", nil))
+				}
+			}()
+		}
+	}()
+	server, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous, previousClients, previousLimiter := proxyConfig, clients, clientLimiter
+	testConfig.Origin = true
+	testConfig.ConnTimeout = config.Duration(5 * time.Second)
+	testConfig.AddressOutLocal = upstream.Addr().String()
+	proxyConfig = testConfig
+	clients = newClientTracker()
+	clientLimiter = newClientLimiter()
+	served := make(chan bool)
+	go func() {
+		clients.serve(server, handleConnIncoming)
+		close(served)
+	}()
+	return server.Addr().String(), func() {
+		server.Close()
+		<-served
+		clients.shutdown(time.Second)
+		upstream.Close()
+		proxyConfig, clients, clientLimiter = previous, previousClients, previousLimiter
+	}
+}
+
+// sendRequest sends a request on the connection and returns the status line of the response.
+func sendRequest(t *testing.T, conn net.Conn, br *bufio.Reader) string {
+	conn.Write([]byte("GET / HTTP/1.1\r\nHost: a\r\n\r\n"))
+	data, err := utils.ReadUntilBytes(br, []byte("\r\n\r\n"))
+	if err != nil {
+		t.Fatal("No response:", err)
+	}
+	length, _ := strconv.Atoi(string(utils.GetHeaderFieldValues(data, []byte("Content-Length"))[0]))
+	utils.ReadByContentLength(br, length)
+	return string(data[:len("HTTP/1.1 200")])
+}
+
+func dialProxy(t *testing.T, address string) (net.Conn, *bufio.Reader) {
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(3 * time.Second))
+	return conn, bufio.NewReader(conn)
+}
+
+func TestRequestRateLimit(t *testing.T) {
+	address, stop := startRateLimitTest(t, config.ProxyConfig{RequestRate: 1, RequestBurst: 2})
+	defer stop()
+
+	conn, br := dialProxy(t, address)
+	defer conn.Close()
+	for i := 0; i < 2; i++ {
+		if status := sendRequest(t, conn, br); status != "HTTP/1.1 200" {
+			t.Fatal("Request within the burst rejected:", status)
+		}
+	}
+	if status := sendRequest(t, conn, br); status != "HTTP/1.1 429" {
+		t.Error("Request exceeding the rate not rejected:", status)
+	}
+	stats := clientLimiter.Stats()
+	if stats.AllowedRequests != 2 || stats.LimitedRequests != 1 {
+		t.Errorf("Unexpected counters: %+v", stats)
+	}
+}
+
+func TestConnectionLimit(t *testing.T) {
+	address, stop := startRateLimitTest(t, config.ProxyConfig{MaxClientConns: 1})
+	defer stop()
+
+	first, firstBr := dialProxy(t, address)
+	defer first.Close()
+	if status := sendRequest(t, first, firstBr); status != "HTTP/1.1 200" {
+		t.Fatal("First connection rejected:", status)
+	}
+	second, secondBr := dialProxy(t, address)
+	defer second.Close()
+	if status := sendRequest(t, second, secondBr); status != "HTTP/1.1 429" {
+		t.Error("Connection exceeding the limit not rejected:", status)
+	}
+
+	// the connection is accepted once the first one was closed
+	first.Close()
+	time.Sleep(50 * time.Millisecond)
+	third, thirdBr := dialProxy(t, address)
+	defer third.Close()
+	if status := sendRequest(t, third, thirdBr); status != "HTTP/1.1 200" {
+		t.Error("Connection rejected after the first one was closed:", status)
+	}
+}
+
+func TestRateLimitExempt(t *testing.T) {
+	address, stop := startRateLimitTest(t, config.ProxyConfig{RequestRate: 1, MaxConns: 1, RateLimitExempt: []string{"127.0.0.0/8"}})
+	defer stop()
+
+	conn, br := dialProxy(t, address)
+	defer conn.Close()
+	other, otherBr := dialProxy(t, address)
+	defer other.Close()
+	for i := 0; i < 3; i++ {
+		if sendRequest(t, conn, br) != "HTTP/1.1 200" || sendRequest(t, other, otherBr) != "HTTP/1.1 200" {
+			t.Fatal("Exempt client limited")
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package ratelimit
+
+import (
+	"errors"
+	"math"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/digital-security-lab/hwl-proxy/utils"
+)
+
+// interval in which clients without connections and with a full bucket are removed
+const sweepInterval = time.Minute
+
+var ErrRateLimited = errors.New("request rate of the client exceeded")
+var ErrGlobalRateLimited = errors.New("request rate of all clients exceeded")
+var ErrTooManyConnections = errors.New("too many connections from the client")
+var ErrTooManyConnectionsTotal = errors.New("too many connections")
+
+//Options configures the limits. Limits of 0 are not enforced.
+type Options struct {
+	Rate          float64  // requests per second per client IP address
+	Burst         int      // requests a client may send at once, the rate rounded up if not set
+	GlobalRate    float64  // requests per second of all clients
+	GlobalBurst   int      // requests all clients may send at once, the global rate rounded up if not set
+	MaxConns      int      // concurrent connections per client IP address
+	MaxTotalConns int      // concurrent connections of all clients
+	Exempt        []string // IP addresses or CIDR ranges that are not limited
+}
+
+//Stats holds the counters of a limiter.
+type Stats struct {
+	ActiveConns     int    // open connections of limited clients
+	Clients         int    // clients currently tracked
+	AcceptedConns   uint64 // connections within the limits
+	RejectedConns   uint64 // connections rejected by a connection limit
+	AllowedRequests uint64 // requests within the rate limits
+	LimitedRequests uint64 // requests rejected by a rate limit
+}
+
+//Limiter limits the request rate with token buckets and the number of concurrent connections, per client IP address and globally.
+//Clients without an IP address, e.g. on Unix domain sockets, share a single bucket and connection limit.
+type Limiter struct {
+	options   Options
+	clients   map[string]*client
+	global    bucket
+	conns     int
+	stats     Stats
+	lastSweep time.Time
+	mutex     sync.Mutex
+}
+
+//client holds the state of a client IP address.
+type client struct {
+	bucket bucket
+	conns  int
+}
+
+//bucket is a token bucket, which is refilled at a fixed rate up to its burst size.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+//New creates a limiter. The buckets of all clients are full initially.
+func New(options Options) *Limiter {
+	options.Burst = burstSize(options.Rate, options.Burst)
+	options.GlobalBurst = burstSize(options.GlobalRate, options.GlobalBurst)
+	now := time.Now()
+	return &Limiter{
+		options:   options,
+		clients:   make(map[string]*client),
+		global:    bucket{tokens: float64(options.GlobalBurst), last: now},
+		lastSweep: now,
+	}
+}
+
+//Open accounts a new connection of the client, unless it exceeds a connection limit.
+//Accepted connections must be closed with Close.
+func (l *Limiter) Open(addr net.Addr) error {
+	if utils.MatchIP(l.options.Exempt, addr) {
+		return nil
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	now := time.Now()
+	l.sweep(now)
+	if l.options.MaxTotalConns > 0 && l.conns >= l.options.MaxTotalConns {
+		l.stats.RejectedConns++
+		return ErrTooManyConnectionsTotal
+	}
+	c := l.client(addr, now)
+	if l.options.MaxConns > 0 && c.conns >= l.options.MaxConns {
+		l.stats.RejectedConns++
+		return ErrTooManyConnections
+	}
+	c.conns++
+	l.conns++
+	l.stats.AcceptedConns++
+	return nil
+}
+
+//Close releases a connection accepted by Open.
+func (l *Limiter) Close(addr net.Addr) {
+	if utils.MatchIP(l.options.Exempt, addr) {
+		return
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if c, ok := l.clients[clientKey(addr)]; ok && c.conns > 0 {
+		c.conns--
+		l.conns--
+	}
+}
+
+//Allow takes a token for a request of the client from its bucket and the global bucket.
+//It returns an error if either bucket is empty.
+func (l *Limiter) Allow(addr net.Addr) error {
+	if utils.MatchIP(l.options.Exempt, addr) {
+		return nil
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	now := time.Now()
+	l.sweep(now)
+	c := l.client(addr, now)
+	if l.options.Rate > 0 && !c.bucket.available(l.options.Rate, l.options.Burst, now) {
+		l.stats.LimitedRequests++
+		return ErrRateLimited
+	}
+	if l.options.GlobalRate > 0 && !l.global.available(l.options.GlobalRate, l.options.GlobalBurst, now) {
+		l.stats.LimitedRequests++
+		return ErrGlobalRateLimited
+	}
+	if l.options.Rate > 0 {
+		c.bucket.tokens--
+	}
+	if l.options.GlobalRate > 0 {
+		l.global.tokens--
+	}
+	l.stats.AllowedRequests++
+	return nil
+}
+
+//Stats returns the current counters.
+func (l *Limiter) Stats() Stats {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	stats := l.stats
+	stats.ActiveConns = l.conns
+	stats.Clients = len(l.clients)
+	return stats
+}
+
+//client returns the state of the client, which is created with a full bucket if it is not tracked yet.
+func (l *Limiter) client(addr net.Addr, now time.Time) *client {
+	key := clientKey(addr)
+	c, ok := l.clients[key]
+	if !ok {
+		c = &client{bucket: bucket{tokens: float64(l.options.Burst), last: now}}
+		l.clients[key] = c
+	}
+	return c
+}
+
+//sweep removes clients without connections whose bucket is full again, as their state equals that of a new client.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+	for key, c := range l.clients {
+		c.bucket.refill(l.options.Rate, l.options.Burst, now)
+		if c.conns == 0 && c.bucket.tokens >= float64(l.options.Burst) {
+			delete(l.clients, key)
+		}
+	}
+}
+
+//refill adds the tokens accumulated since the last refill.
+func (b *bucket) refill(rate float64, burst int, now time.Time) {
+	b.tokens = math.Min(float64(burst), b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+}
+
+//available refills the bucket and checks whether a token is available.
+func (b *bucket) available(rate float64, burst int, now time.Time) bool {
+	b.refill(rate, burst, now)
+	return b.tokens >= 1
+}
+
+//burstSize returns the configured burst size, or the rate rounded up if not set.
+func burstSize(rate float64, burst int) int {
+	if burst > 0 {
+		return burst
+	}
+	return int(math.Max(1, math.Ceil(rate)))
+}
+
+//clientKey returns the IP address of the client, or an empty key for clients without an IP address.
+func clientKey(addr net.Addr) string {
+	ip := utils.GetIP(addr)
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
@@ -0,0 +1,91 @@
+package ratelimit_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/digital-security-lab/hwl-proxy/ratelimit"
+)
+
+var clientA = &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1000}
+var clientA2 = &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1001}
+var clientB = &net.TCPAddr{IP: net.ParseIP("192.0.2.2"), Port: 1000}
+var exempt = &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1000}
+
+func TestRequestRate(t *testing.T) {
+	l := ratelimit.New(ratelimit.Options{Rate: 20, Burst: 3})
+	for i := 0; i < 3; i++ {
+		if err := l.Allow(clientA); err != nil {
+			t.Fatal("Request within the burst limited:", err)
+		}
+	}
+	if err := l.Allow(clientA2); err != ratelimit.ErrRateLimited {
+		t.Error("Request exceeding the burst of the client IP address allowed:", err)
+	}
+	if err := l.Allow(clientB); err != nil {
+		t.Error("Request of another client limited:", err)
+	}
+
+	// a token is added every 50ms
+	time.Sleep(60 * time.Millisecond)
+	if err := l.Allow(clientA); err != nil {
+		t.Error("Request limited after refill:", err)
+	}
+	if err := l.Allow(clientA); err != ratelimit.ErrRateLimited {
+		t.Error("Request allowed before refill:", err)
+	}
+
+	stats := l.Stats()
+	if stats.AllowedRequests != 5 || stats.LimitedRequests != 2 || stats.Clients != 2 {
+		t.Errorf("Unexpected counters: %+v", stats)
+	}
+}
+
+func TestGlobalRequestRate(t *testing.T) {
+	l := ratelimit.New(ratelimit.Options{GlobalRate: 1, GlobalBurst: 2})
+	if l.Allow(clientA) != nil || l.Allow(clientB) != nil {
+		t.Fatal("Requests within the global burst limited")
+	}
+	if err := l.Allow(clientB); err != ratelimit.ErrGlobalRateLimited {
+		t.Error("Request exceeding the global burst allowed:", err)
+	}
+}
+
+func TestConnectionLimits(t *testing.T) {
+	l := ratelimit.New(ratelimit.Options{MaxConns: 2, MaxTotalConns: 3})
+	if l.Open(clientA) != nil || l.Open(clientA2) != nil {
+		t.Fatal("Connections within the limit rejected")
+	}
+	if err := l.Open(clientA); err != ratelimit.ErrTooManyConnections {
+		t.Error("Connection exceeding the client limit accepted:", err)
+	}
+	if err := l.Open(clientB); err != nil {
+		t.Error("Connection of another client rejected:", err)
+	}
+	if err := l.Open(clientB); err != ratelimit.ErrTooManyConnectionsTotal {
+		t.Error("Connection exceeding the global limit accepted:", err)
+	}
+
+	l.Close(clientA)
+	if err := l.Open(clientB); err != nil {
+		t.Error("Connection rejected after another one was closed:", err)
+	}
+	stats := l.Stats()
+	if stats.ActiveConns != 3 || stats.AcceptedConns != 4 || stats.RejectedConns != 2 {
+		t.Errorf("Unexpected counters: %+v", stats)
+	}
+}
+
+func TestExempt(t *testing.T) {
+	l := ratelimit.New(ratelimit.Options{Rate: 1, MaxConns: 1, Exempt: []string{"10.0.0.0/8"}})
+	for i := 0; i < 3; i++ {
+		if l.Open(exempt) != nil || l.Allow(exempt) != nil {
+			t.Fatal("Exempt client limited")
+		}
+	}
+	l.Close(exempt)
+	if stats := l.Stats(); stats.ActiveConns != 0 || stats.Clients != 0 {
+		t.Errorf("Exempt client tracked: %+v", stats)
+	}
+}
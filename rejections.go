@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"text/template"
+	"time"
+
+	"github.com/digital-security-lab/hwl-proxy/utils"
+)
+
+//rejectReason is the reason code of a rejected request, which is used in the configuration and in error responses.
+type rejectReason string
+
+const (
+	reasonInvalidSyntax        rejectReason = "invalid-syntax"
+	reasonHeaderRejected       rejectReason = "header-rejected"
+	reasonFramingConflict      rejectReason = "framing-conflict"
+	reasonUnsupportedCoding    rejectReason = "unsupported-transfer-coding"
+	reasonProtectedNomination  rejectReason = "protected-nomination"
+	reasonExpectationFailed    rejectReason = "expectation-failed"
+	reasonInvalidTarget        rejectReason = "invalid-target"
+	reasonForbiddenDestination rejectReason = "forbidden-destination"
+	reasonProxyAuthRequired    rejectReason = "proxy-auth-required"
+	reasonMethodNotAllowed     rejectReason = "method-not-allowed"
+	reasonRequestTimeout       rejectReason = "request-timeout"
+	reasonRequestLineTooLong   rejectReason = "request-line-too-long"
+	reasonHeaderTooLarge       rejectReason = "header-too-large"
+	reasonBodyTooLarge         rejectReason = "body-too-large"
+	reasonInvalidBody          rejectReason = "invalid-body"
+	reasonRateLimited          rejectReason = "rate-limited"
+	reasonOverloaded           rejectReason = "overloaded"
+	reasonUpstreamTimeout      rejectReason = "upstream-timeout"
+	reasonUpstreamUnavailable  rejectReason = "upstream-unavailable"
+	reasonUpstreamFailed       rejectReason = "upstream-failed"
+)
+
+// default status codes of the reasons
+var rejectStatus = map[rejectReason]int{
+	reasonInvalidSyntax:        400,
+	reasonHeaderRejected:       400,
+	reasonFramingConflict:      400,
+	reasonUnsupportedCoding:    501,
+	reasonProtectedNomination:  400,
+	reasonExpectationFailed:    417,
+	reasonInvalidTarget:        400,
+	reasonForbiddenDestination: 403,
+	reasonProxyAuthRequired:    407,
+	reasonMethodNotAllowed:     405,
+	reasonRequestTimeout:       408,
+	reasonRequestLineTooLong:   414,
+	reasonHeaderTooLarge:       431,
+	reasonBodyTooLarge:         413,
+	reasonInvalidBody:          400,
+	reasonRateLimited:          429,
+	reasonOverloaded:           503,
+	reasonUpstreamTimeout:      504,
+	reasonUpstreamUnavailable:  503,
+	reasonUpstreamFailed:       502,
+}
+
+// reason phrases of the status codes that differ from or are missing in net/http
+var statusTitles = map[int]string{
+	413: "Payload Too Large",
+	414: "URI Too Long",
+}
+
+// body templates of the reasons configured with a body
+var rejectTemplates map[rejectReason]*template.Template
+
+//problem holds the details of a rejection. It is the body of problem+json responses according to RFC 9457
+//and the data of body templates.
+type problem struct {
+	Type          string       `json:"type"`
+	Title         string       `json:"title"`
+	Status        int          `json:"status"`
+	Detail        string       `json:"detail,omitempty"`
+	Reason        rejectReason `json:"reason"`
+	CorrelationID string       `json:"correlationId,omitempty"`
+}
+
+//loadRejections validates the configured error responses and parses their body templates.
+func loadRejections() (map[rejectReason]*template.Template, error) {
+	templates := make(map[rejectReason]*template.Template)
+	for code, rejection := range proxyConfig.Rejections.Reasons {
+		reason := rejectReason(code)
+		if _, ok := rejectStatus[reason]; !ok {
+			return nil, fmt.Errorf("unknown rejection reason %q", code)
+		}
+		if rejection.Status != 0 && (rejection.Status < 400 || rejection.Status > 599) {
+			return nil, fmt.Errorf("status %d of rejection reason %q is not an error status", rejection.Status, code)
+		}
+		if len(rejection.Body) > 0 {
+			t, err := template.New(code).Parse(rejection.Body)
+			if err != nil {
+				return nil, err
+			}
+			templates[reason] = t
+		}
+	}
+	return templates, nil
+}
+
+//rejection is an error response generated by the proxy and the record of its reason.
+type rejection struct {
+	rejectionRecord
+	response []byte
+}
+
+//errorResponse returns the error response to a request rejected for the reason.
+//The detail describes the rejection further, e.g. the limit that was exceeded, and is part of the default bodies.
+//If a correlation header is configured, the response carries a new correlation ID, which is logged with the reason
+//once the response is written.
+func errorResponse(reason rejectReason, detail string) *rejection {
+	options := proxyConfig.Rejections
+	configured := options.Reasons[string(reason)]
+	p := problem{Type: "about:blank", Status: configured.Status, Detail: detail, Reason: reason}
+	if p.Status == 0 {
+		p.Status = rejectStatus[reason]
+	}
+	p.Title = statusTitle(p.Status)
+	if len(options.ProblemTypeBase) > 0 {
+		p.Type = options.ProblemTypeBase + string(reason)
+	}
+	if len(options.CorrelationHeader) > 0 {
+		p.CorrelationID = newCorrelationID()
+	}
+	body, contentType := rejectionBody(p, rejectTemplates[reason], configured.ContentType)
+	r := &rejection{rejectionRecord: rejectionRecord{Reason: reason, Status: p.Status, Detail: detail, CorrelationID: p.CorrelationID}}
+	r.response = utils.CreateResponse(p.Status, p.Title, body)
+	r.response = utils.AddHeader(r.response, "Content-Type", contentType)
+	if len(p.CorrelationID) > 0 {
+		r.response = utils.AddHeader(r.response, options.CorrelationHeader, p.CorrelationID)
+	}
+	return r
+}
+
+//withHeader adds a header field to the response of the rejection.
+func (r *rejection) withHeader(name string, value string) *rejection {
+	r.response = utils.AddHeader(r.response, name, value)
+	return r
+}
+
+//record logs the correlation ID of a rejection whose response was written. Rejections of the request are counted
+//and listed by the admin API, upstream failures are counted as upstream errors instead.
+func (r *rejection) record() {
+	r.Time = time.Now()
+	if len(r.CorrelationID) > 0 {
+		message := string(r.Reason)
+		if len(r.Detail) > 0 {
+			message += ": " + r.Detail
+		}
+		log.Println("Rejected request", r.CorrelationID+":", message)
+	}
+	if isUpstreamReason(r.Reason) {
+		return
+	}
+	rejectionsTotal.Inc(string(r.Reason))
+	recentRejections.add(r.rejectionRecord)
+}
+
+//isUpstreamReason returns whether the reason is a failure of the upstream rather than a rejection of the request.
+func isUpstreamReason(reason rejectReason) bool {
+	return reason == reasonUpstreamTimeout || reason == reasonUpstreamUnavailable || reason == reasonUpstreamFailed
+}
+
+//rejectionBody returns the body of an error response and its content type.
+//If the body template of the reason fails, the body of the configured format is used.
+func rejectionBody(p problem, t *template.Template, contentType string) ([]byte, string) {
+	if t != nil {
+		var body bytes.Buffer
+		err := t.Execute(&body, p)
+		if err == nil {
+			if len(contentType) == 0 {
+				contentType = "text/plain; charset=utf-8"
+			}
+			return body.Bytes(), contentType
+		}
+		log.Println("Rejection template:", err.Error())
+	}
+	if proxyConfig.Rejections.Format == "problem+json" {
+		body, _ := json.Marshal(p)
+		return body, "application/problem+json"
+	}
+	if len(p.Detail) > 0 {
+		return []byte(p.Title + ": " + p.Detail), "text/plain; charset=utf-8"
+	}
+	return []byte(p.Title), "text/plain; charset=utf-8"
+}
+
+//statusTitle returns the reason phrase of a status code.
+func statusTitle(status int) string {
+	if title, ok := statusTitles[status]; ok {
+		return title
+	}
+	if title := http.StatusText(status); len(title) > 0 {
+		return title
+	}
+	return "Error"
+}
+
+//newCorrelationID returns a random ID correlating an error response with the log.
+func newCorrelationID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return fmt.Sprintf("%x", id)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"testing"
+
+	"github.com/digital-security-lab/hwl-proxy/config"
+	"github.com/digital-security-lab/hwl-proxy/utils"
+)
+
+//setRejections configures the error responses and returns a function restoring the previous configuration.
+func setRejections(t *testing.T, rejections config.Rejections) func() {
+	previous := proxyConfig
+	previousTemplates := rejectTemplates
+	proxyConfig = config.ProxyConfig{Rejections: rejections}
+	templates, err := loadRejections()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rejectTemplates = templates
+	return func() {
+		proxyConfig = previous
+		rejectTemplates = previousTemplates
+	}
+}
+
+func TestDefaultErrorResponse(t *testing.T) {
+	defer setRejections(t, config.Rejections{})()
+
+	expected := "HTTP/1.1 431 Request Header Fields Too Large\r\nContent-Length: 55\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n" +
+		"Request Header Fields Too Large: too many header fields"
+	if response := string(errorResponse(reasonHeaderTooLarge, "too many header fields").response); response != expected {
+		t.Errorf("Unexpected response:\n%q\nexpected:\n%q", response, expected)
+	}
+	expected = "HTTP/1.1 413 Payload Too Large\r\nContent-Length: 17\r\nContent-Type: text/plain; charset=utf-8\r\n\r\nPayload Too Large"
+	if response := string(errorResponse(reasonBodyTooLarge, "").response); response != expected {
+		t.Errorf("Unexpected response:\n%q\nexpected:\n%q", response, expected)
+	}
+}
+
+func TestProblemDetails(t *testing.T) {
+	defer setRejections(t, config.Rejections{
+		Format:            "problem+json",
+		ProblemTypeBase:   "https://example.com/problems/",
+		CorrelationHeader: "X-Correlation-ID",
+	})()
+
+	response := errorResponse(reasonFramingConflict, "conflicting Content-Length").response
+	header := regexp.MustCompile(`^HTTP/1.1 400 Bad Request\r\n(.+\r\n)*Content-Type: application/problem\+json\r\nX-Correlation-ID: ([0-9a-f]{16})\r\n\r\n`).FindSubmatch(response)
+	if header == nil {
+		t.Fatalf("Unexpected response: %q", response)
+	}
+	var details map[string]interface{}
+	err := json.Unmarshal(response[len(header[0]):], &details)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"type":          "https://example.com/problems/framing-conflict",
+		"title":         "Bad Request",
+		"status":        400.0,
+		"detail":        "conflicting Content-Length",
+		"reason":        "framing-conflict",
+		"correlationId": string(header[2]),
+	}
+	for key, value := range expected {
+		if details[key] != value {
+			t.Errorf("Unexpected %s: %v, expected %v", key, details[key], value)
+		}
+	}
+	if bytes.Equal(response, errorResponse(reasonFramingConflict, "conflicting Content-Length").response) {
+		t.Error("Correlation ID reused")
+	}
+}
+
+func TestRejectionTemplate(t *testing.T) {
+	defer setRejections(t, config.Rejections{
+		CorrelationHeader: "X-Correlation-ID",
+		Reasons: map[string]config.Rejection{
+			"header-rejected": {Status: 422, Body: `<p>{{.Title}} ({{.Reason}}, {{.CorrelationID}})</p>`, ContentType: "text/html"},
+			"rate-limited":    {Status: 503},
+		},
+	})()
+
+	pattern := `^HTTP/1.1 422 Unprocessable Entity\r\n(.+\r\n)*Content-Type: text/html\r\nX-Correlation-ID: ([0-9a-f]+)\r\n\r\n<p>Unprocessable Entity \(header-rejected, ([0-9a-f]+)\)</p>$`
+	match := regexp.MustCompile(pattern).FindSubmatch(errorResponse(reasonHeaderRejected, "").response)
+	if match == nil || !bytes.Equal(match[2], match[3]) {
+		t.Errorf("Unexpected response: %q", match)
+	}
+	if response := errorResponse(reasonRateLimited, "").response; !bytes.HasPrefix(response, []byte("HTTP/1.1 503 Service Unavailable\r\n")) {
+		t.Errorf("Status not overridden: %q", response)
+	}
+}
+
+func TestInvalidRejections(t *testing.T) {
+	previous := proxyConfig
+	defer func() { proxyConfig = previous }()
+	var tests = map[string]config.Rejection{
+		"unknown-reason":  {},
+		"header-rejected": {Status: 200},
+		"invalid-syntax":  {Body: "{{.Title"},
+	}
+	for code, rejection := range tests {
+		proxyConfig = config.ProxyConfig{Rejections: config.Rejections{Reasons: map[string]config.Rejection{code: rejection}}}
+		if _, err := loadRejections(); err == nil {
+			t.Errorf("Invalid rejection %q accepted", code)
+		}
+	}
+}
+
+func TestConfiguredRejection(t *testing.T) {
+	defer setRejections(t, config.Rejections{
+		Reasons: map[string]config.Rejection{"method-not-allowed": {Body: "Use a forward proxy for {{.Reason}}"}},
+	})()
+	conns, stop := startProxyTest(t, config.ProxyConfig{Rejections: proxyConfig.Rejections}, defaultTestWhitelist)
+	defer stop()
+
+	go conns.client.Write([]byte("CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n"))
+	expectMessage(t, conns.clientBr, `^HTTP/1.1 405 Method Not Allowed\r\nContent-Length: 42\r\n(.+\r\n)*Allow: .+\r\n\r\n$`)
+	body, err := utils.ReadByContentLength(conns.clientBr, 42)
+	if err != nil || string(body) != "Use a forward proxy for method-not-allowed" {
+		t.Error("Unexpected body:", string(body), err)
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/digital-security-lab/hwl-proxy/utils"
+)
+
+var errFramingNotWhitelisted = errors.New("Content-Length or Transfer-Encoding not whitelisted")
+
+//validateFraming validates the framing of a request according to the configured policy.
+//Ambiguous framing is rejected unless the lenient policy is configured.
+func validateFraming(data []byte) ([]byte, utils.Framing, error) {
+	return utils.ValidateRequestFraming(data, proxyConfig.FramingPolicy != "lenient")
+}
+
+//keepsFraming checks whether header whitelisting kept the framing headers of a request.
+//Otherwise, the next hop would read the body as the next request.
+func keepsFraming(data []byte, framing utils.Framing) bool {
+	_, whitelisted, err := utils.ValidateRequestFraming(data, true)
+	return err == nil && whitelisted.Chunked == framing.Chunked && whitelisted.ContentLength == framing.ContentLength
+}
+
+//framingErrorResponse returns the response to a request with invalid framing, which states the reason.
+func framingErrorResponse(err error) *rejection {
+	if err == utils.ErrUnknownTransferCoding {
+		return errorResponse(reasonUnsupportedCoding, err.Error())
+	}
+	return errorResponse(reasonFramingConflict, err.Error())
+}
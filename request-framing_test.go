@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/digital-security-lab/hwl-proxy/config"
+	"github.com/digital-security-lab/hwl-proxy/utils"
+	"github.com/digital-security-lab/hwl-proxy/whitelisting"
+)
+
+// whitelist of the framing tests
+var framingTestWhitelist = whitelisting.Whitelist{
+	whitelisting.WhitelistItem{Key: "host"},
+	whitelisting.WhitelistItem{Key: "content-length", Val: `\d+`},
+	whitelisting.WhitelistItem{Key: "transfer-encoding", Val: `(?i)(chunked)`},
+}
+
+func TestFramingRejections(t *testing.T) {
+	rejections := map[string]string{
+		"POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 1\r\nContent-Length: 2\r\n\r\n":        `^HTTP/1.1 400 Bad Request\r\n`,
+		"POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked, gzip\r\n\r\n":              `^HTTP/1.1 400 Bad Request\r\n`,
+		"POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: compress, chunked\r\n\r\n0\r\n\r\n": `^HTTP/1.1 400 Bad Request\r\n`,
+		"POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: foo, chunked\r\n\r\n":               `^HTTP/1.1 501 Not Implemented\r\n`,
+	}
+	for request, expected := range rejections {
+		conns, stop := startProxyTest(t, config.ProxyConfig{Whitelisting: true}, framingTestWhitelist)
+		go conns.client.Write([]byte(request))
+		expectMessage(t, conns.clientBr, expected)
+		stop()
+	}
+
+	conns, stop := startProxyTest(t, config.ProxyConfig{Whitelisting: true}, framingTestWhitelist)
+	defer stop()
+	go conns.client.Write([]byte("POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 1\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n"))
+	expectMessage(t, conns.clientBr, `^HTTP/1.1 400 Bad Request\r\n`)
+}
+
+func TestLenientFramingClosesConnection(t *testing.T) {
+	conns, stop := startProxyTest(t, config.ProxyConfig{Whitelisting: true, FramingPolicy: "lenient"}, framingTestWhitelist)
+	defer stop()
+	go conns.client.Write([]byte("POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 1\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n" +
+		"GET /smuggled HTTP/1.1\r\nHost: a\r\n\r\n"))
+	expectMessage(t, conns.upstreamBr, `^POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n$`)
+	body, err := utils.ReadByContentLength(conns.upstreamBr, 5)
+	if err != nil || string(body) != "0\r\n\r\n" {
+		t.Fatal("Invalid body:", string(body), err)
+	}
+	go conns.upstream.Write(utils.CreateResponse(200, "OK", []byte("OK")))
+	expectMessage(t, conns.clientBr, `^HTTP/1.1 200 OK\r\n`)
+	select {
+	case <-conns.done:
+	case <-time.After(time.Second):
+		t.Error("Connection not closed after ambiguous request")
+	}
+}
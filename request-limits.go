@@ -0,0 +1,86 @@
+package main
+
+import (
+	"time"
+
+	"github.com/digital-security-lab/hwl-proxy/utils"
+)
+
+// limits of request and response headers if not configured
+const (
+	defaultMaxStartLine   = 8192
+	defaultMaxHeaderLine  = 8192
+	defaultMaxHeaderCount = 100
+	defaultMaxHeaderBytes = 64 * 1024
+)
+
+//headerLimits returns the configured header limits, which apply to requests and responses of the upstream alike.
+func headerLimits() utils.HeaderLimits {
+	limits := utils.HeaderLimits{
+		StartLine: proxyConfig.MaxStartLine,
+		FieldLine: proxyConfig.MaxHeaderLine,
+		Fields:    proxyConfig.MaxHeaderCount,
+		Size:      proxyConfig.MaxHeaderBytes,
+	}
+	if limits.StartLine <= 0 {
+		limits.StartLine = defaultMaxStartLine
+	}
+	if limits.FieldLine <= 0 {
+		limits.FieldLine = defaultMaxHeaderLine
+	}
+	if limits.Fields <= 0 {
+		limits.Fields = defaultMaxHeaderCount
+	}
+	if limits.Size <= 0 {
+		limits.Size = defaultMaxHeaderBytes
+	}
+	return limits
+}
+
+//readRequestHeader reads the header of the next request, which must be received completely within the header timeout
+//and at the minimum header rate. A client that does not send the header in time or is evicted as one of the slowest
+//is answered with 408 Request Timeout,
+//a header exceeding the limits with 414 URI Too Long or 431 Request Header Fields Too Large.
+func readRequestHeader(p *pipeline, ex *exchange) ([]byte, error) {
+	ex.access.beginRequest(p.client)
+	p.client.SetReadDeadline(proxyConfig.PhaseTimeouts().Header.Deadline())
+	p.client.SetMinReadRate(proxyConfig.MinHeaderRate, minRateGrace())
+	headerReaders.begin(p.client, proxyConfig.MaxHeaderReaders)
+	data, err := utils.ReadHeader(p.client.Reader, headerLimits())
+	headerReaders.end(p.client)
+	ex.access.headerEnd = time.Now()
+	if utils.IsTimeout(err) {
+		p.reject(ex, errorResponse(reasonRequestTimeout, ""))
+	} else if response := limitErrorResponse(err); response != nil {
+		p.reject(ex, response)
+	}
+	return data, err
+}
+
+//exceedsBodySize checks whether the Content-Length of a request exceeds the maximum body size.
+//The size of chunked bodies is checked while they are forwarded.
+func exceedsBodySize(framing utils.Framing) bool {
+	return proxyConfig.MaxBodySize > 0 && framing.ContentLength > proxyConfig.MaxBodySize
+}
+
+//isHeaderLimitError checks whether reading a header failed because it exceeds the header limits.
+func isHeaderLimitError(err error) bool {
+	return err == utils.ErrStartLineTooLong || err == utils.ErrFieldLineTooLong || err == utils.ErrTooManyFields || err == utils.ErrHeaderTooLarge
+}
+
+//limitErrorResponse returns the response to a request exceeding a size limit, or nil for other errors.
+//The connection is closed after the response, as the rest of the request is not read.
+func limitErrorResponse(err error) *rejection {
+	var response *rejection
+	switch err {
+	case utils.ErrStartLineTooLong:
+		response = errorResponse(reasonRequestLineTooLong, err.Error())
+	case utils.ErrFieldLineTooLong, utils.ErrTooManyFields, utils.ErrHeaderTooLarge:
+		response = errorResponse(reasonHeaderTooLarge, err.Error())
+	case utils.ErrBodyTooLarge, utils.ErrTooManyChunks:
+		response = errorResponse(reasonBodyTooLarge, err.Error())
+	default:
+		return nil
+	}
+	return response.withHeader("Connection", "close")
+}
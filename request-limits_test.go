@@ -0,0 +1,76 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/digital-security-lab/hwl-proxy/config"
+)
+
+// configuration of the limit tests
+var limitTestConfig = config.ProxyConfig{
+	MaxStartLine:   64,
+	MaxHeaderLine:  64,
+	MaxHeaderCount: 8,
+	MaxHeaderBytes: 256,
+	MaxBodySize:    8,
+	MaxChunkCount:  2,
+}
+
+func TestRequestLimits(t *testing.T) {
+	var tests = []struct {
+		request string
+		status  string
+	}{
+		{"GET /" + strings.Repeat("a", 64) + " HTTP/1.1\r\nHost: a\r\n\r\n", "414 URI Too Long"},
+		{"GET / HTTP/1.1\r\nHost: a\r\nX-Long: " + strings.Repeat("a", 64) + "\r\n\r\n", "431 Request Header Fields Too Large"},
+		{"GET / HTTP/1.1\r\nHost: a\r\n" + strings.Repeat("X-Field: 1\r\n", 8) + "\r\n", "431 Request Header Fields Too Large"},
+		{"GET / HTTP/1.1\r\nHost: a\r\n" + strings.Repeat("X-Field: "+strings.Repeat("a", 53)+"\r\n", 4) + "\r\n", "431 Request Header Fields Too Large"},
+		{"POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 9\r\n\r\n", "413 Payload Too Large"},
+	}
+	for _, test := range tests {
+		conns, stop := startProxyTest(t, limitTestConfig, defaultTestWhitelist)
+		go conns.client.Write([]byte(test.request))
+		expectMessage(t, conns.clientBr, `^HTTP/1.1 `+test.status+`\r\n(.+\r\n)*Connection: close\r\n`)
+		select {
+		case <-conns.done:
+		case <-time.After(time.Second):
+			t.Error("Connection not closed after", test.status)
+		}
+		stop()
+	}
+}
+
+func TestChunkedRequestLimits(t *testing.T) {
+	var tests = []string{
+		"POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n9\r\nabcdefghi\r\n0\r\n\r\n",
+		"POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n1\r\na\r\n1\r\nb\r\n1\r\nc\r\n0\r\n\r\n",
+	}
+	for _, request := range tests {
+		conns, stop := startProxyTest(t, limitTestConfig, defaultTestWhitelist)
+		go io.Copy(ioutil.Discard, conns.upstreamBr)
+		go conns.client.Write([]byte(request))
+		expectMessage(t, conns.clientBr, `^HTTP/1.1 413 Payload Too Large\r\n`)
+		stop()
+	}
+}
+
+func TestResponseHeaderLimits(t *testing.T) {
+	var tests = []string{
+		"HTTP/1.1 200 " + strings.Repeat("a", 64) + "\r\nContent-Length: 0\r\n\r\n",
+		"HTTP/1.1 200 OK\r\nX-Long: " + strings.Repeat("a", 64) + "\r\nContent-Length: 0\r\n\r\n",
+		"HTTP/1.1 200 OK\r\n" + strings.Repeat("X-Field: 1\r\n", 9) + "\r\n",
+		"HTTP/1.1 100 Continue\r\n" + strings.Repeat("X-Field: "+strings.Repeat("a", 53)+"\r\n", 5) + "\r\n",
+	}
+	for _, response := range tests {
+		conns, stop := startProxyTest(t, limitTestConfig, defaultTestWhitelist)
+		go conns.client.Write([]byte("GET / HTTP/1.1\r\nHost: a\r\n\r\n"))
+		expectMessage(t, conns.upstreamBr, `^GET / HTTP/1.1\r\n`)
+		go conns.upstream.Write([]byte(response))
+		expectMessage(t, conns.clientBr, `^HTTP/1.1 502 Bad Gateway\r\n`)
+		stop()
+	}
+}
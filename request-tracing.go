@@ -0,0 +1,135 @@
+package main
+
+import (
+	"time"
+
+	"github.com/digital-security-lab/hwl-proxy/tracing"
+	"github.com/digital-security-lab/hwl-proxy/utils"
+)
+
+// exporter of the spans of requests, nil if no collector is configured
+var tracer *tracing.Exporter
+
+//requestTrace holds the trace context of a request and the times of the phases exported as spans.
+type requestTrace struct {
+	context        tracing.TraceContext // trace context forwarded to the upstream
+	forward        bool                 // the request carries a valid trace context or starts a trace
+	recording      bool                 // spans of the request are exported
+	spanID         tracing.SpanID       // span of the request, the parent of the forwarded context if recording
+	parentID       tracing.SpanID       // span of the caller, zero if the proxy started the trace
+	whitelistStart time.Time            // whitelist applied
+	whitelistEnd   time.Time
+}
+
+//newTracer creates the exporter of the configured collector. It returns nil if no collector is configured.
+func newTracer() (*tracing.Exporter, error) {
+	options := proxyConfig.Tracing
+	if len(options.Endpoint) == 0 {
+		return nil, nil
+	}
+	return tracing.NewExporter(tracing.Options{
+		Endpoint:    options.Endpoint,
+		ServiceName: options.ServiceName,
+		Headers:     options.Headers,
+		BatchSize:   options.BatchSize,
+		Interval:    time.Duration(options.Interval),
+	})
+}
+
+//takeTraceContext removes the traceparent and tracestate header fields from a request, so they bypass the whitelist, and validates them.
+//An invalid or repeated traceparent is dropped together with tracestate, an invalid tracestate alone.
+//If spans are exported, a request without valid traceparent starts a new trace, and the span of a sampled request
+//becomes the parent of the forwarded context.
+func takeTraceContext(data []byte, ex *exchange) []byte {
+	parents := utils.GetHeaderFieldValues(data, []byte("traceparent"))
+	state := joinHeaderValues(data, "tracestate")
+	data = utils.RemoveHeader(data, "traceparent", 0)
+	data = utils.RemoveHeader(data, "tracestate", 0)
+
+	tc, err := tracing.TraceContext{}, tracing.ErrInvalidTraceParent
+	if len(parents) == 1 {
+		tc, err = tracing.ParseTraceParent(string(parents[0]))
+	}
+	if err == nil {
+		ex.trace.forward = true
+		ex.trace.parentID = tc.ParentID
+		if tracing.ValidateTraceState(state) == nil {
+			tc.State = state
+		}
+	} else if tracer != nil {
+		ex.trace.forward = true
+		tc = tracing.NewTraceContext()
+	}
+	if tracer != nil && tc.Sampled() {
+		ex.trace.recording = true
+		ex.trace.spanID = tracing.NewSpanID()
+		tc.ParentID = ex.trace.spanID
+	}
+	ex.trace.context = tc
+	return data
+}
+
+//addTraceContext adds the trace context taken from a request back to it.
+func addTraceContext(data []byte, ex *exchange) []byte {
+	if !ex.trace.forward {
+		return data
+	}
+	data = utils.AddHeader(data, "traceparent", ex.trace.context.TraceParent())
+	if len(ex.trace.context.State) > 0 {
+		data = utils.AddHeader(data, "tracestate", ex.trace.context.State)
+	}
+	return data
+}
+
+//exportSpans exports the span of a request once it was answered, with the whitelist, the wait for the upstream response
+//and the relay of the response as child spans.
+func exportSpans(ex *exchange) {
+	trace := &ex.trace
+	if tracer == nil || !trace.recording {
+		return
+	}
+	record := &ex.access
+	end := time.Now()
+	request := tracing.Span{
+		TraceID:  trace.context.TraceID,
+		SpanID:   trace.spanID,
+		ParentID: trace.parentID,
+		Name:     "hwl.request",
+		Kind:     tracing.KindServer,
+		Start:    record.start,
+		End:      end,
+		Attributes: []tracing.Attribute{
+			{Key: "hwl.module", Value: record.module},
+			{Key: "hwl.outcome", Value: record.outcome},
+			{Key: "http.method", Value: ex.method},
+			{Key: "http.target", Value: record.target},
+			{Key: "http.status_code", Value: record.status},
+		},
+		Error: record.outcome == "failed" || record.status >= 500,
+	}
+	tracer.Export(request)
+
+	child := func(name string, kind int, start time.Time, end time.Time, attributes ...tracing.Attribute) {
+		tracer.Export(tracing.Span{
+			TraceID:    trace.context.TraceID,
+			SpanID:     tracing.NewSpanID(),
+			ParentID:   trace.spanID,
+			Name:       name,
+			Kind:       kind,
+			Start:      start,
+			End:        end,
+			Attributes: attributes,
+		})
+	}
+	if !trace.whitelistStart.IsZero() {
+		child("hwl.whitelist", tracing.KindInternal, trace.whitelistStart, trace.whitelistEnd,
+			tracing.Attribute{Key: "hwl.whitelist", Value: record.whitelist}, tracing.Attribute{Key: "hwl.stripped", Value: record.stripped})
+	}
+	if !record.forwardEnd.IsZero() && !record.responseStart.IsZero() {
+		child("hwl.upstream_wait", tracing.KindClient, record.forwardEnd, record.responseStart,
+			tracing.Attribute{Key: "hwl.upstream", Value: record.upstream})
+	}
+	if !record.responseStart.IsZero() {
+		child("hwl.response_relay", tracing.KindInternal, record.responseStart, end)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/digital-security-lab/hwl-proxy/config"
+	"github.com/digital-security-lab/hwl-proxy/tracing"
+	"github.com/digital-security-lab/hwl-proxy/utils"
+)
+
+//otlpSpan is a span of an OTLP/HTTP JSON export request.
+type otlpSpan struct {
+	TraceID      string `json:"traceId"`
+	SpanID       string `json:"spanId"`
+	ParentSpanID string `json:"parentSpanId"`
+	Name         string `json:"name"`
+}
+
+//startTraceCollector points the tracer to a stub collector. The returned function closes the tracer and returns the exported spans.
+func startTraceCollector(t *testing.T) func() []otlpSpan {
+	var spans []otlpSpan
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		var request struct {
+			ResourceSpans []struct {
+				ScopeSpans []struct {
+					Spans []otlpSpan `json:"spans"`
+				} `json:"scopeSpans"`
+			} `json:"resourceSpans"`
+		}
+		if err := json.Unmarshal(body, &request); err != nil {
+			t.Error("Invalid export request:", err)
+		}
+		for _, resourceSpans := range request.ResourceSpans {
+			for _, scopeSpans := range resourceSpans.ScopeSpans {
+				spans = append(spans, scopeSpans.Spans...)
+			}
+		}
+	}))
+	var err error
+	tracer, err = tracing.NewExporter(tracing.Options{Endpoint: collector.URL + "/v1/traces"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return func() []otlpSpan {
+		tracer.Close()
+		tracer = nil
+		collector.Close()
+		return spans
+	}
+}
+
+func TestTraceContextBypassesWhitelist(t *testing.T) {
+	conns, stop := startProxyTest(t, config.ProxyConfig{Whitelisting: true}, defaultTestWhitelist)
+	defer stop()
+
+	// the whitelist of the test does not contain traceparent and tracestate
+	go conns.client.Write([]byte("GET / HTTP/1.1\r\nHost: a\r\ntraceparent: 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01\r\ntracestate: rojo=1\r\ntracestate: congo=2\r\n\r\n"))
+	expectMessage(t, conns.upstreamBr, `^GET / HTTP/1.1\r\nHost: a\r\ntraceparent: 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01\r\ntracestate: rojo=1, congo=2\r\n\r\n$`)
+	go conns.upstream.Write(utils.CreateResponse(200, "OK", nil))
+	expectMessage(t, conns.clientBr, `^HTTP/1.1 200 OK\r\n`)
+}
+
+func TestInvalidTraceContext(t *testing.T) {
+	conns, stop := startProxyTest(t, config.ProxyConfig{Whitelisting: true}, defaultTestWhitelist)
+	defer stop()
+
+	// an invalid tracestate is dropped
+	go conns.client.Write([]byte("GET / HTTP/1.1\r\nHost: a\r\ntraceparent: 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00\r\ntracestate: Rojo=1\r\n\r\n"))
+	expectMessage(t, conns.upstreamBr, `^GET / HTTP/1.1\r\nHost: a\r\ntraceparent: 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00\r\n\r\n$`)
+	go conns.upstream.Write(utils.CreateResponse(200, "OK", nil))
+	expectMessage(t, conns.clientBr, `^HTTP/1.1 200 OK\r\n`)
+
+	// an invalid traceparent is dropped with tracestate
+	go conns.client.Write([]byte("GET / HTTP/1.1\r\nHost: a\r\ntraceparent: 00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01\r\ntracestate: rojo=1\r\n\r\n"))
+	expectMessage(t, conns.upstreamBr, `^GET / HTTP/1.1\r\nHost: a\r\n\r\n$`)
+	go conns.upstream.Write(utils.CreateResponse(200, "OK", nil))
+	expectMessage(t, conns.clientBr, `^HTTP/1.1 200 OK\r\n`)
+
+	// repeated traceparent fields are invalid, even if one of them is valid
+	go conns.client.Write([]byte("GET / HTTP/1.1\r\nHost: a\r\ntraceparent: 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01\r\ntraceparent: 00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01\r\n\r\n"))
+	expectMessage(t, conns.upstreamBr, `^GET / HTTP/1.1\r\nHost: a\r\n\r\n$`)
+	go conns.upstream.Write(utils.CreateResponse(200, "OK", nil))
+	expectMessage(t, conns.clientBr, `^HTTP/1.1 200 OK\r\n`)
+}
+
+func TestRequestSpans(t *testing.T) {
+	collect := startTraceCollector(t)
+	conns, stop := startProxyTest(t, config.ProxyConfig{Whitelisting: true}, defaultTestWhitelist)
+
+	go conns.client.Write([]byte("GET / HTTP/1.1\r\nHost: a\r\ntraceparent: 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01\r\n\r\n"))
+	data := expectMessage(t, conns.upstreamBr, `^GET / HTTP/1.1\r\nHost: a\r\ntraceparent: 00-4bf92f3577b34da6a3ce929d0e0e4736-[0-9a-f]{16}-01\r\n\r\n$`)
+	go conns.upstream.Write(utils.CreateResponse(200, "OK", nil))
+	expectMessage(t, conns.clientBr, `^HTTP/1.1 200 OK\r\n`)
+
+	// a request without trace context starts a new trace
+	go conns.client.Write([]byte("GET / HTTP/1.1\r\nHost: a\r\n\r\n"))
+	expectMessage(t, conns.upstreamBr, `^GET / HTTP/1.1\r\nHost: a\r\ntraceparent: 00-[0-9a-f]{32}-[0-9a-f]{16}-01\r\n\r\n$`)
+	go conns.upstream.Write(utils.CreateResponse(200, "OK", nil))
+	expectMessage(t, conns.clientBr, `^HTTP/1.1 200 OK\r\n`)
+
+	// so does a request with repeated traceparent fields
+	go conns.client.Write([]byte("GET / HTTP/1.1\r\nHost: a\r\ntraceparent: 00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01\r\ntraceparent: 00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01\r\n\r\n"))
+	repeated := expectMessage(t, conns.upstreamBr, `^GET / HTTP/1.1\r\nHost: a\r\ntraceparent: 00-[0-9a-f]{32}-[0-9a-f]{16}-01\r\n\r\n$`)
+	if regexp.MustCompile(`0af7651916cd43dd8448eb211c80319c`).Match(repeated) {
+		t.Error("Repeated traceparent continued:", string(repeated))
+	}
+	go conns.upstream.Write(utils.CreateResponse(200, "OK", nil))
+	expectMessage(t, conns.clientBr, `^HTTP/1.1 200 OK\r\n`)
+	stop()
+	spans := collect()
+
+	parent := regexp.MustCompile(`traceparent: 00-[0-9a-f]{32}-([0-9a-f]{16})`).FindSubmatch(data)[1]
+	names := make(map[string]otlpSpan)
+	for _, span := range spans {
+		if span.TraceID == "4bf92f3577b34da6a3ce929d0e0e4736" {
+			names[span.Name] = span
+		}
+	}
+	request, ok := names["hwl.request"]
+	if !ok || request.SpanID != string(parent) || request.ParentSpanID != "00f067aa0ba902b7" {
+		t.Fatal("Unexpected request span:", request)
+	}
+	for _, name := range []string{"hwl.whitelist", "hwl.upstream_wait", "hwl.response_relay"} {
+		if names[name].ParentSpanID != request.SpanID {
+			t.Error("Missing child span:", name)
+		}
+	}
+	if len(spans) != 12 {
+		t.Error("Unexpected number of spans:", len(spans))
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/digital-security-lab/hwl-proxy/config"
+	"github.com/digital-security-lab/hwl-proxy/whitelisting"
+)
+
+// paths of the configuration and whitelist files, set from the flags
+var configFile, whitelistFile, trailerWhitelistFile string
+
+// version of the configuration file, which is loaded once on startup
+var configVersion fileVersion
+
+// versions of the whitelist files
+var whitelistVersion, trailerWhitelistVersion fileVersion
+
+// guards the whitelists and their versions, which are replaced on reload
+var whitelistMutex sync.RWMutex
+
+// serializes reloads
+var reloadMutex sync.Mutex
+
+// whether header fields the whitelist would remove are only reported, 1 if enabled
+var reportOnly int32
+
+//fileVersion identifies the content of a loaded file.
+type fileVersion struct {
+	Path     string    `json:"path"`
+	Version  int       `json:"version"` // incremented whenever a changed file is loaded
+	Hash     string    `json:"hash"`    // SHA-256 of the content
+	LoadedAt time.Time `json:"loadedAt"`
+}
+
+//reloadResult describes the outcome of a reload.
+type reloadResult struct {
+	Config           fileVersion  `json:"config"`
+	ConfigChanged    bool         `json:"configChanged"` // the configuration file changed, which requires a restart
+	Whitelist        fileVersion  `json:"whitelist"`
+	TrailerWhitelist *fileVersion `json:"trailerWhitelist,omitempty"`
+}
+
+//readVersioned reads a file and returns its version, which is incremented if the content differs from the previous version.
+func readVersioned(path string, previous fileVersion) ([]byte, fileVersion, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, previous, err
+	}
+	sum := sha256.Sum256(data)
+	version := fileVersion{Path: path, Version: previous.Version, Hash: "sha256:" + hex.EncodeToString(sum[:]), LoadedAt: time.Now()}
+	if version.Hash != previous.Hash {
+		version.Version++
+	}
+	return data, version, nil
+}
+
+//loadConfig loads the configuration file.
+func loadConfig() error {
+	data, version, err := readVersioned(configFile, configVersion)
+	if err != nil {
+		return err
+	}
+	err = proxyConfig.Parse(data)
+	if err != nil {
+		return err
+	}
+	configVersion = version
+	setReportOnly(proxyConfig.ReportOnly)
+	return nil
+}
+
+//loadWhitelists loads the whitelist and, if configured, the trailer whitelist and replaces the whitelists in use.
+//No whitelist is replaced if a file is invalid. Requests being whitelisted finish with the previous whitelist.
+func loadWhitelists() error {
+	whitelistMutex.RLock()
+	previous, previousTrailers := whitelistVersion, trailerWhitelistVersion
+	whitelistMutex.RUnlock()
+
+	var wl, trailers whitelisting.Whitelist
+	data, version, err := readVersioned(whitelistFile, previous)
+	if err == nil {
+		err = wl.Parse(data)
+	}
+	if err != nil {
+		return err
+	}
+	trailerVersion := previousTrailers
+	if len(trailerWhitelistFile) > 0 {
+		data, trailerVersion, err = readVersioned(trailerWhitelistFile, previousTrailers)
+		if err == nil {
+			err = trailers.Parse(data)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	whitelistMutex.Lock()
+	defer whitelistMutex.Unlock()
+	whitelist, trailerWhitelist = wl, trailers
+	whitelistVersion, trailerWhitelistVersion = version, trailerVersion
+	return nil
+}
+
+//reload loads the whitelists anew and checks whether the configuration file changed.
+//The configuration in use is not replaced, as the listeners and upstreams depend on it.
+func reload() (reloadResult, error) {
+	reloadMutex.Lock()
+	defer reloadMutex.Unlock()
+	var result reloadResult
+	data, version, err := readVersioned(configFile, configVersion)
+	if err == nil {
+		var changed config.ProxyConfig
+		err = changed.Parse(data)
+	}
+	if err != nil {
+		return result, err
+	}
+	err = loadWhitelists()
+	if err != nil {
+		return result, err
+	}
+	result.Config = configVersion
+	result.ConfigChanged = version.Hash != configVersion.Hash
+	result.Whitelist, result.TrailerWhitelist = whitelistVersions()
+	return result, nil
+}
+
+//whitelistVersions returns the versions of the whitelists in use. The trailer whitelist version is nil if none is configured.
+func whitelistVersions() (fileVersion, *fileVersion) {
+	whitelistMutex.RLock()
+	defer whitelistMutex.RUnlock()
+	if len(trailerWhitelistVersion.Path) == 0 {
+		return whitelistVersion, nil
+	}
+	trailers := trailerWhitelistVersion
+	return whitelistVersion, &trailers
+}
+
+//currentWhitelist returns the request header whitelist in use.
+func currentWhitelist() whitelisting.Whitelist {
+	whitelistMutex.RLock()
+	defer whitelistMutex.RUnlock()
+	return whitelist
+}
+
+//currentTrailerWhitelist returns the trailer whitelist in use.
+func currentTrailerWhitelist() whitelisting.Whitelist {
+	whitelistMutex.RLock()
+	defer whitelistMutex.RUnlock()
+	return trailerWhitelist
+}
+
+//isReportOnly checks whether header fields the whitelist would remove are only reported.
+func isReportOnly() bool {
+	return atomic.LoadInt32(&reportOnly) == 1
+}
+
+//setReportOnly enables or disables the report-only mode of the whitelist.
+func setReportOnly(enabled bool) {
+	var value int32
+	if enabled {
+		value = 1
+	}
+	atomic.StoreInt32(&reportOnly, value)
+}
@@ -46,3 +46,10 @@ func Remove(id string) bool {
 	}
 	return false
 }
+
+//Count returns the number of live sessions.
+func Count() int {
+	sessionMutex.Lock()
+	defer sessionMutex.Unlock()
+	return len(sessionMap)
+}
@@ -40,3 +40,15 @@ func TestSession(t *testing.T) {
 		t.Error("Session not deleted properly")
 	}
 }
+
+func TestSessionCount(t *testing.T) {
+	count := session.Count()
+	createSession := session.Create()
+	if session.Count() != count+1 {
+		t.Error("Session count does not include a created session")
+	}
+	session.Remove(createSession.ID)
+	if session.Count() != count {
+		t.Error("Session count includes a removed session")
+	}
+}
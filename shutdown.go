@@ -0,0 +1,171 @@
+package main
+
+import (
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/digital-security-lab/hwl-proxy/config"
+	"github.com/digital-security-lab/hwl-proxy/utils"
+)
+
+// time in-flight requests may take to finish on shutdown if not configured
+const defaultShutdownTimeout = 30 * time.Second
+
+// client connections and listeners of both modules, drained on shutdown
+var clients = newClientTracker()
+
+//clientTracker keeps track of the client connections, so they can be drained on shutdown.
+type clientTracker struct {
+	conns     map[net.Conn]bool // client connections, true while waiting for the next request
+	listeners []net.Listener
+	closing   bool
+	mutex     sync.Mutex
+	wg        sync.WaitGroup
+}
+
+//newClientTracker creates an empty tracker.
+func newClientTracker() *clientTracker {
+	return &clientTracker{conns: make(map[net.Conn]bool)}
+}
+
+//serve accepts connections on the listener until it is closed on shutdown.
+//Temporary errors, e.g. too many open files, are retried after a delay.
+func (tracker *clientTracker) serve(server net.Listener, handle func(net.Conn)) {
+	tracker.mutex.Lock()
+	if tracker.closing {
+		tracker.mutex.Unlock()
+		server.Close()
+		return
+	}
+	tracker.listeners = append(tracker.listeners, server)
+	tracker.mutex.Unlock()
+
+	delay := 5 * time.Millisecond
+	for {
+		conn, err := server.Accept()
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Temporary() {
+				log.Println(err.Error())
+				time.Sleep(delay)
+				if delay < time.Second {
+					delay *= 2
+				}
+				continue
+			}
+			if !tracker.isClosing() {
+				log.Println(err.Error())
+			}
+			return
+		}
+		delay = 5 * time.Millisecond
+		go handle(conn)
+	}
+}
+
+//add tracks a client connection. It returns false if the proxy is shutting down.
+func (tracker *clientTracker) add(conn net.Conn) bool {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	if tracker.closing {
+		return false
+	}
+	tracker.conns[conn] = false
+	tracker.wg.Add(1)
+	return true
+}
+
+//remove stops tracking a client connection once it was handled.
+func (tracker *clientTracker) remove(conn net.Conn) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	if _, ok := tracker.conns[conn]; ok {
+		delete(tracker.conns, conn)
+		tracker.wg.Done()
+	}
+}
+
+//waitForRequest marks the client connection idle until the next request starts or the idle timeout expires.
+//It returns false if the proxy is shutting down or no further request was received.
+func (tracker *clientTracker) waitForRequest(client *utils.BufferedConn, timeout config.Duration) bool {
+	client.SetReadTimeout(0)
+	client.SetMinReadRate(0, 0)
+	client.SetReadDeadline(timeout.Deadline())
+	if !tracker.setIdle(client.Conn, true) {
+		return false
+	}
+	_, err := client.Reader.Peek(1)
+	tracker.setIdle(client.Conn, false)
+	return err == nil
+}
+
+//setIdle marks a tracked connection as idle or active. It returns false if the proxy is shutting down.
+func (tracker *clientTracker) setIdle(conn net.Conn, idle bool) bool {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	if idle && tracker.closing {
+		return false
+	}
+	if _, ok := tracker.conns[conn]; ok {
+		tracker.conns[conn] = idle
+	}
+	return true
+}
+
+//count returns the number of tracked client connections that process a request and that wait for the next request.
+func (tracker *clientTracker) count() (int, int) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	idle := 0
+	for _, isIdle := range tracker.conns {
+		if isIdle {
+			idle++
+		}
+	}
+	return len(tracker.conns) - idle, idle
+}
+
+//isClosing checks whether the proxy is shutting down, so requests are answered with Connection: close.
+func (tracker *clientTracker) isClosing() bool {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	return tracker.closing
+}
+
+//shutdown stops accepting connections and waits until the in-flight requests were answered.
+//Idle connections stop waiting for further requests, while responses still pending on them are relayed.
+//Connections that are still open after the timeout are closed. It returns false in this case.
+func (tracker *clientTracker) shutdown(timeout time.Duration) bool {
+	tracker.mutex.Lock()
+	tracker.closing = true
+	for _, server := range tracker.listeners {
+		server.Close()
+	}
+	for conn, idle := range tracker.conns {
+		if idle {
+			conn.SetReadDeadline(time.Now())
+		}
+	}
+	tracker.mutex.Unlock()
+
+	done := make(chan bool)
+	go func() {
+		tracker.wg.Wait()
+		close(done)
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return true
+	case <-timer.C:
+	}
+	tracker.mutex.Lock()
+	log.Println("Shutdown timeout exceeded, closing", len(tracker.conns), "connections")
+	for conn := range tracker.conns {
+		conn.Close()
+	}
+	tracker.mutex.Unlock()
+	return false
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/digital-security-lab/hwl-proxy/config"
+	"github.com/digital-security-lab/hwl-proxy/utils"
+)
+
+// startShutdownTest starts the incoming module with a fresh tracker in front of the upstream handler.
+func startShutdownTest(t *testing.T, handleUpstream func(net.Conn)) (string, func()) {
+	upstream, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := upstream.Accept()
+			if err != nil {
+				return
+			}
+			go handleUpstream(conn)
+		}
+	}()
+	server, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous, previousClients := proxyConfig, clients
+	proxyConfig = config.ProxyConfig{Origin: true, ConnTimeout: config.Duration(5 * time.Second), AddressOutLocal: upstream.Addr().String()}
+	clients = newClientTracker()
+	served := make(chan bool)
+	go func() {
+		clients.serve(server, handleConnIncoming)
+		close(served)
+	}()
+	return server.Addr().String(), func() {
+		server.Close()
+		<-served
+		// the handlers still read the configuration until they return
+		clients.wg.Wait()
+		upstream.Close()
+		proxyConfig, clients = previous, previousClients
+	}
+}
+
+func TestShutdownDrainsConnections(t *testing.T) {
+	body := strings.Repeat("x", 4096)
+	address, stop := startShutdownTest(t, func(conn net.Conn) {
+		defer conn.Close()
+		_, err := utils.ReadUntilBytes(bufio.NewReader(conn), []byte("\r\n\r\n"))
+		if err != nil {
+			return
+		}
+		// the body is sent slowly, so the shutdown starts while the response is relayed
+		conn.Write([]byte("HTTP/1.1 200 OK\r\nContent-Length: 4096\r\n\r\n"))
+		for i := 0; i < 4; i++ {
+			time.Sleep(20 * time.Millisecond)
+			conn.Write([]byte(body[i*1024 : (i+1)*1024]))
+		}
+	})
+	defer stop()
+
+	active, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer active.Close()
+	idle, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idle.Close()
+	active.SetDeadline(time.Now().Add(3 * time.Second))
+	idle.SetDeadline(time.Now().Add(3 * time.Second))
+
+	active.Write([]byte("GET / HTTP/1.1\r\nHost: a\r\n\r\n"))
+	activeBr := bufio.NewReader(active)
+	expectMessage(t, activeBr, `^HTTP/1.1 200 OK\r\nContent-Length: 4096\r\n\r\n$`)
+	drained := make(chan bool)
+	go func() {
+		drained <- clients.shutdown(time.Second)
+	}()
+
+	// idle connections are closed, new connections are refused
+	_, err = bufio.NewReader(idle).ReadByte()
+	if err == nil {
+		t.Error("Idle connection not closed on shutdown")
+	}
+	time.Sleep(10 * time.Millisecond)
+	if conn, err := net.Dial("tcp", address); err == nil {
+		conn.Close()
+		t.Error("Connection accepted after shutdown")
+	}
+
+	// the in-flight response is relayed completely before the connection is closed
+	received, err := ioutil.ReadAll(activeBr)
+	if err != nil || string(received) != body {
+		t.Error("Truncated response:", len(received), err)
+	}
+	if !<-drained {
+		t.Error("Shutdown did not complete within the timeout")
+	}
+}
+
+func TestShutdownClosesPersistentConnection(t *testing.T) {
+	address, stop := startShutdownTest(t, func(conn net.Conn) {
+		defer conn.Close()
+		br := bufio.NewReader(conn)
+		_, err := utils.ReadUntilBytes(br, []byte("\r\n\r\n"))
+		if err != nil {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+		conn.Write(utils.CreateResponse(200, "OK", []byte("ok")))
+	})
+	defer stop()
+
+	client, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.SetDeadline(time.Now().Add(3 * time.Second))
+	client.Write([]byte("GET / HTTP/1.1\r\nHost: a\r\n\r\n"))
+	time.Sleep(20 * time.Millisecond)
+	drained := make(chan bool)
+	go func() {
+		drained <- clients.shutdown(time.Second)
+	}()
+
+	// requests received before the shutdown are answered with Connection: close
+	clientBr := bufio.NewReader(client)
+	expectMessage(t, clientBr, `^HTTP/1.1 200 OK\r\nContent-Length: 2\r\nConnection: close\r\n\r\n$`)
+	received, err := ioutil.ReadAll(clientBr)
+	if err != nil || string(received) != "ok" {
+		t.Error("Truncated response:", string(received), err)
+	}
+	if !<-drained {
+		t.Error("Shutdown did not complete within the timeout")
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	address, stop := startShutdownTest(t, func(conn net.Conn) {
+		// the upstream never answers
+		ioutil.ReadAll(conn)
+		conn.Close()
+	})
+	defer stop()
+
+	client, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.SetDeadline(time.Now().Add(3 * time.Second))
+	client.Write([]byte("GET / HTTP/1.1\r\nHost: a\r\n\r\n"))
+	time.Sleep(20 * time.Millisecond)
+
+	if clients.shutdown(50 * time.Millisecond) {
+		t.Error("Shutdown completed although a request was in flight")
+	}
+	_, err = ioutil.ReadAll(client)
+	if err != nil {
+		t.Error("Connection not closed after the shutdown timeout:", err)
+	}
+}
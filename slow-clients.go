@@ -0,0 +1,74 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/digital-security-lab/hwl-proxy/utils"
+)
+
+// time before the minimum data rates are enforced if not configured
+const defaultMinRateGrace = 5 * time.Second
+
+// connections of both modules currently reading a request header
+var headerReaders = newHeaderGuard()
+
+//headerGuard limits the number of connections reading a request header.
+//If the limit is reached, the connection reading its header at the lowest rate is evicted for a new one,
+//so clients trickling header bytes cannot occupy all connections.
+type headerGuard struct {
+	readers map[*utils.BufferedConn]headerRead
+	mutex   sync.Mutex
+}
+
+//headerRead holds the progress of a connection reading a request header.
+type headerRead struct {
+	start     time.Time
+	bytesRead int64 // bytes consumed from the connection before the header started
+}
+
+//newHeaderGuard creates a guard without connections.
+func newHeaderGuard() *headerGuard {
+	return &headerGuard{readers: make(map[*utils.BufferedConn]headerRead)}
+}
+
+//begin registers a connection that starts reading a request header.
+//If max connections are reading a header already, the slowest of them is evicted by expiring its read deadline.
+func (guard *headerGuard) begin(conn *utils.BufferedConn, max int) {
+	guard.mutex.Lock()
+	defer guard.mutex.Unlock()
+	now := time.Now()
+	if max > 0 && len(guard.readers) >= max {
+		var slowest *utils.BufferedConn
+		slowestRate := 0.0
+		for reader, read := range guard.readers {
+			rate := math.Inf(1)
+			if elapsed := now.Sub(read.start).Seconds(); elapsed > 0 {
+				rate = float64(reader.BytesRead()-read.bytesRead) / elapsed
+			}
+			if slowest == nil || rate < slowestRate {
+				slowest = reader
+				slowestRate = rate
+			}
+		}
+		delete(guard.readers, slowest)
+		slowest.SetReadDeadline(time.Unix(1, 0))
+	}
+	guard.readers[conn] = headerRead{start: now, bytesRead: conn.BytesConsumed()}
+}
+
+//end removes a connection that finished or aborted reading its request header.
+func (guard *headerGuard) end(conn *utils.BufferedConn) {
+	guard.mutex.Lock()
+	defer guard.mutex.Unlock()
+	delete(guard.readers, conn)
+}
+
+//minRateGrace returns the time after which the minimum data rates are enforced.
+func minRateGrace() time.Duration {
+	if proxyConfig.MinRateGrace <= 0 {
+		return defaultMinRateGrace
+	}
+	return time.Duration(proxyConfig.MinRateGrace)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/digital-security-lab/hwl-proxy/config"
+)
+
+func TestMinHeaderRate(t *testing.T) {
+	address, stop := startRateLimitTest(t, config.ProxyConfig{MinHeaderRate: 100, MinRateGrace: config.Duration(100 * time.Millisecond)})
+	defer stop()
+
+	conn, br := dialProxy(t, address)
+	defer conn.Close()
+	conn.Write([]byte("GET / HTTP/1.1\r\n"))
+	go func() {
+		for i := 0; i < 40; i++ {
+			time.Sleep(50 * time.Millisecond)
+			if _, err := conn.Write([]byte("X")); err != nil {
+				return
+			}
+		}
+	}()
+	expectMessage(t, br, `^HTTP/1.1 408 Request Timeout\r\n`)
+}
+
+func TestHeaderReaderEviction(t *testing.T) {
+	address, stop := startRateLimitTest(t, config.ProxyConfig{MaxHeaderReaders: 1})
+	defer stop()
+
+	// the slow client occupies the only header reader until another client arrives
+	slow, slowBr := dialProxy(t, address)
+	defer slow.Close()
+	slow.Write([]byte("GET / HTTP/1.1\r\nHost: a\r\n"))
+	time.Sleep(50 * time.Millisecond)
+
+	fast, fastBr := dialProxy(t, address)
+	defer fast.Close()
+	if status := sendRequest(t, fast, fastBr); status != "HTTP/1.1 200" {
+		t.Error("Request of a new client rejected:", status)
+	}
+	expectMessage(t, slowBr, `^HTTP/1.1 408 Request Timeout\r\n`)
+}
+
+func TestMinBodyRate(t *testing.T) {
+	address, stop := startRateLimitTest(t, config.ProxyConfig{MinBodyRate: 100, MinRateGrace: config.Duration(100 * time.Millisecond)})
+	defer stop()
+
+	conn, br := dialProxy(t, address)
+	defer conn.Close()
+	conn.Write([]byte("POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 100\r\n\r\n"))
+	go func() {
+		for i := 0; i < 40; i++ {
+			time.Sleep(50 * time.Millisecond)
+			if _, err := conn.Write([]byte("x")); err != nil {
+				return
+			}
+		}
+	}()
+	expectMessage(t, br, `^HTTP/1.1 408 Request Timeout\r\n`)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/digital-security-lab/hwl-proxy/config"
+	"github.com/digital-security-lab/hwl-proxy/utils"
+	"github.com/digital-security-lab/hwl-proxy/whitelisting"
+)
+
+// whitelist of proxy tests that do not test specific header fields
+var defaultTestWhitelist = whitelisting.Whitelist{
+	whitelisting.WhitelistItem{Key: "host"},
+	whitelisting.WhitelistItem{Key: "connection", Val: `(?i)(close|keep-alive)`},
+	whitelisting.WhitelistItem{Key: "authorization"},
+}
+
+//proxyTestConns holds the client and upstream side of a connection handled by the incoming module.
+type proxyTestConns struct {
+	client     net.Conn
+	clientBr   *bufio.Reader
+	upstream   net.Conn
+	upstreamBr *bufio.Reader
+	done       chan bool // closed when the proxy finished handling the connection
+}
+
+//startClientTest serves a piped client connection with the handler.
+//The returned function closes the client connection and waits until the handler returned.
+func startClientTest(t *testing.T, serve func(conn net.Conn)) (net.Conn, *bufio.Reader, func()) {
+	client, proxyIn := net.Pipe()
+	client.SetDeadline(time.Now().Add(3 * time.Second))
+	done := make(chan bool)
+	go func() {
+		serve(proxyIn)
+		close(done)
+	}()
+	return client, bufio.NewReader(client), func() {
+		client.Close()
+		<-done
+	}
+}
+
+//startProxyTest handles a piped client connection by the incoming module in origin mode, which forwards requests
+//to a piped upstream. ConnTimeout defaults to 5 seconds.
+//The configuration and the whitelists are replaced until the returned function is called.
+func startProxyTest(t *testing.T, testConfig config.ProxyConfig, testWhitelist whitelisting.Whitelist) (*proxyTestConns, func()) {
+	previous, previousWhitelist, previousTrailers := proxyConfig, whitelist, trailerWhitelist
+	proxyConfig = testConfig
+	proxyConfig.Origin = true
+	if proxyConfig.ConnTimeout == 0 {
+		proxyConfig.ConnTimeout = config.Duration(5 * time.Second)
+	}
+	whitelist = testWhitelist
+
+	proxyOut, upstream := net.Pipe()
+	upstream.SetDeadline(time.Now().Add(3 * time.Second))
+	conns := &proxyTestConns{upstream: upstream, upstreamBr: bufio.NewReader(upstream), done: make(chan bool)}
+	client, clientBr, stopClient := startClientTest(t, func(proxyIn net.Conn) {
+		processIncomingRequest(proxyIn, proxyOut)
+		close(conns.done)
+	})
+	conns.client, conns.clientBr = client, clientBr
+	return conns, func() {
+		upstream.Close()
+		stopClient()
+		proxyConfig, whitelist, trailerWhitelist = previous, previousWhitelist, previousTrailers
+	}
+}
+
+//expectMessage reads a message header and fails the test if it does not match the pattern.
+func expectMessage(t *testing.T, br *bufio.Reader, pattern string) []byte {
+	data, err := utils.ReadUntilBytes(br, []byte("\r\n\r\n"))
+	if err != nil || !regexp.MustCompile(pattern).Match(data) {
+		t.Fatal("Unexpected message:", string(data), err, "Expected:", pattern)
+	}
+	return data
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/digital-security-lab/hwl-proxy/config"
+	"github.com/digital-security-lab/hwl-proxy/utils"
+)
+
+func TestKeepAliveOutlivesConnTimeout(t *testing.T) {
+	conns, stop := startProxyTest(t, config.ProxyConfig{Whitelisting: true, ConnTimeout: config.Duration(200 * time.Millisecond)}, defaultTestWhitelist)
+	defer stop()
+
+	// the timeouts apply to each request, so an active connection is kept longer than connTimeout
+	for i := 0; i < 4; i++ {
+		time.Sleep(100 * time.Millisecond)
+		go conns.client.Write([]byte("GET / HTTP/1.1\r\nHost: a\r\n\r\n"))
+		expectMessage(t, conns.upstreamBr, `^GET / HTTP/1.1\r\n`)
+		go conns.upstream.Write(utils.CreateResponse(200, "OK", nil))
+		expectMessage(t, conns.clientBr, `^HTTP/1.1 200 OK\r\n`)
+	}
+}
+
+func TestHeaderTimeout(t *testing.T) {
+	conns, stop := startProxyTest(t, config.ProxyConfig{Timeouts: config.Timeouts{Header: config.Duration(100 * time.Millisecond)}}, defaultTestWhitelist)
+	defer stop()
+
+	conns.client.Write([]byte("GET / HTTP/1.1\r\nHo"))
+	expectMessage(t, conns.clientBr, `^HTTP/1.1 408 Request Timeout\r\n`)
+}
+
+func TestIdleTimeout(t *testing.T) {
+	conns, stop := startProxyTest(t, config.ProxyConfig{Timeouts: config.Timeouts{Idle: config.Duration(100 * time.Millisecond)}}, defaultTestWhitelist)
+	defer stop()
+
+	go conns.client.Write([]byte("GET / HTTP/1.1\r\nHost: a\r\n\r\n"))
+	expectMessage(t, conns.upstreamBr, `^GET / HTTP/1.1\r\n`)
+	go conns.upstream.Write(utils.CreateResponse(200, "OK", nil))
+	expectMessage(t, conns.clientBr, `^HTTP/1.1 200 OK\r\n`)
+
+	// the idle connection is closed without response
+	select {
+	case <-conns.done:
+	case <-time.After(time.Second):
+		t.Fatal("Idle connection not closed")
+	}
+}
+
+func TestRequestBodyTimeout(t *testing.T) {
+	conns, stop := startProxyTest(t, config.ProxyConfig{Timeouts: config.Timeouts{Body: config.Duration(100 * time.Millisecond)}}, defaultTestWhitelist)
+	defer stop()
+	go io.Copy(ioutil.Discard, conns.upstreamBr)
+
+	conns.client.Write([]byte("POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 10\r\n\r\nab"))
+	expectMessage(t, conns.clientBr, `^HTTP/1.1 408 Request Timeout\r\n`)
+}
+
+func TestResponseHeaderTimeout(t *testing.T) {
+	conns, stop := startProxyTest(t, config.ProxyConfig{Timeouts: config.Timeouts{ResponseHeader: config.Duration(100 * time.Millisecond)}}, defaultTestWhitelist)
+	defer stop()
+
+	// the upstream receives the request, but does not answer
+	go conns.client.Write([]byte("GET / HTTP/1.1\r\nHost: a\r\n\r\n"))
+	expectMessage(t, conns.upstreamBr, `^GET / HTTP/1.1\r\n`)
+	expectMessage(t, conns.clientBr, `^HTTP/1.1 504 Gateway Timeout\r\n`)
+}
@@ -0,0 +1,239 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaults of Options that are not set
+const (
+	defaultBatchSize   = 512
+	defaultInterval    = 5 * time.Second
+	defaultTimeout     = 10 * time.Second
+	defaultServiceName = "hwl-proxy"
+)
+
+// kinds of spans, as defined by OTLP
+const (
+	KindInternal = 1
+	KindServer   = 2
+	KindClient   = 3
+)
+
+//Span is a timed operation within a trace.
+type Span struct {
+	TraceID    TraceID
+	SpanID     SpanID
+	ParentID   SpanID // parent span, zero for the root span of a trace
+	Name       string
+	Kind       int
+	Start      time.Time
+	End        time.Time
+	Attributes []Attribute
+	Error      bool // the operation failed
+}
+
+//Attribute is a key and a string, int, int64, float64 or bool value describing a span.
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+//Options configures an exporter.
+type Options struct {
+	Endpoint    string            // OTLP/HTTP traces endpoint, e.g. "http://127.0.0.1:4318/v1/traces"
+	ServiceName string            // service.name of the resource, "hwl-proxy" if empty
+	Headers     map[string]string // HTTP header fields sent with each export request
+	BatchSize   int               // maximum number of spans per export request, 512 if not set
+	Interval    time.Duration     // time after which pending spans are exported, 5s if not set
+	Timeout     time.Duration     // timeout of an export request, 10s if not set
+}
+
+//Exporter sends spans in batches to an OTLP collector, encoded as OTLP/HTTP JSON.
+//Spans are queued without blocking and dropped if the queue is full.
+type Exporter struct {
+	options Options
+	client  *http.Client
+	spans   chan Span
+	done    chan bool
+	closed  bool
+	mutex   sync.RWMutex
+	dropped uint64 // spans dropped as the queue was full or the export failed
+}
+
+//NewExporter creates an exporter and starts sending spans in the background.
+func NewExporter(options Options) (*Exporter, error) {
+	target, err := url.Parse(options.Endpoint)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || len(target.Host) == 0 {
+		return nil, fmt.Errorf("invalid OTLP endpoint %q", options.Endpoint)
+	}
+	if options.BatchSize <= 0 {
+		options.BatchSize = defaultBatchSize
+	}
+	if options.Interval <= 0 {
+		options.Interval = defaultInterval
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = defaultTimeout
+	}
+	if len(options.ServiceName) == 0 {
+		options.ServiceName = defaultServiceName
+	}
+	e := &Exporter{
+		options: options,
+		client:  &http.Client{Timeout: options.Timeout},
+		spans:   make(chan Span, 4*options.BatchSize),
+		done:    make(chan bool),
+	}
+	go e.run()
+	return e, nil
+}
+
+//Export queues a span. It returns false if the span was dropped, e.g. as the exporter was closed.
+func (e *Exporter) Export(span Span) bool {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	if e.closed {
+		atomic.AddUint64(&e.dropped, 1)
+		return false
+	}
+	select {
+	case e.spans <- span:
+		return true
+	default:
+		atomic.AddUint64(&e.dropped, 1)
+		return false
+	}
+}
+
+//Dropped returns the number of spans that were not exported.
+func (e *Exporter) Dropped() uint64 {
+	return atomic.LoadUint64(&e.dropped)
+}
+
+//Close exports the queued spans and stops the exporter. Spans exported afterwards are dropped.
+func (e *Exporter) Close() {
+	e.mutex.Lock()
+	if !e.closed {
+		e.closed = true
+		close(e.spans)
+	}
+	e.mutex.Unlock()
+	<-e.done
+}
+
+//run collects spans into batches, which are sent once they are full or the interval expired.
+func (e *Exporter) run() {
+	defer close(e.done)
+	ticker := time.NewTicker(e.options.Interval)
+	defer ticker.Stop()
+	var batch []Span
+	for {
+		select {
+		case span, ok := <-e.spans:
+			if !ok {
+				e.send(batch)
+				return
+			}
+			batch = append(batch, span)
+			if len(batch) >= e.options.BatchSize {
+				e.send(batch)
+				batch = nil
+			}
+		case <-ticker.C:
+			e.send(batch)
+			batch = nil
+		}
+	}
+}
+
+//send posts a batch of spans to the collector. Failed batches are dropped.
+func (e *Exporter) send(batch []Span) {
+	if len(batch) == 0 {
+		return
+	}
+	body, err := json.Marshal(encodeSpans(e.options.ServiceName, batch))
+	if err == nil {
+		var req *http.Request
+		req, err = http.NewRequest("POST", e.options.Endpoint, bytes.NewReader(body))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/json")
+			for name, value := range e.options.Headers {
+				req.Header.Set(name, value)
+			}
+			var resp *http.Response
+			resp, err = e.client.Do(req)
+			if err == nil {
+				resp.Body.Close()
+				if resp.StatusCode/100 != 2 {
+					err = fmt.Errorf("collector answered %s", resp.Status)
+				}
+			}
+		}
+	}
+	if err != nil {
+		atomic.AddUint64(&e.dropped, uint64(len(batch)))
+	}
+}
+
+//encodeSpans returns the OTLP/HTTP JSON request of a batch of spans.
+func encodeSpans(serviceName string, batch []Span) map[string]interface{} {
+	spans := make([]map[string]interface{}, 0, len(batch))
+	for _, span := range batch {
+		encoded := map[string]interface{}{
+			"traceId":           span.TraceID.String(),
+			"spanId":            span.SpanID.String(),
+			"name":              span.Name,
+			"kind":              span.Kind,
+			"startTimeUnixNano": strconv.FormatInt(span.Start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(span.End.UnixNano(), 10),
+			"attributes":        encodeAttributes(span.Attributes),
+		}
+		if span.ParentID.IsValid() {
+			encoded["parentSpanId"] = span.ParentID.String()
+		}
+		if span.Error {
+			encoded["status"] = map[string]interface{}{"code": 2}
+		}
+		spans = append(spans, encoded)
+	}
+	resource := []Attribute{{"service.name", serviceName}}
+	return map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{"attributes": encodeAttributes(resource)},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]interface{}{"name": defaultServiceName},
+				"spans": spans,
+			}},
+		}},
+	}
+}
+
+//encodeAttributes returns the OTLP JSON key-value list of the attributes.
+func encodeAttributes(attributes []Attribute) []interface{} {
+	encoded := make([]interface{}, 0, len(attributes))
+	for _, attribute := range attributes {
+		var value map[string]interface{}
+		switch v := attribute.Value.(type) {
+		case int:
+			value = map[string]interface{}{"intValue": strconv.Itoa(v)}
+		case int64:
+			value = map[string]interface{}{"intValue": strconv.FormatInt(v, 10)}
+		case float64:
+			value = map[string]interface{}{"doubleValue": v}
+		case bool:
+			value = map[string]interface{}{"boolValue": v}
+		default:
+			value = map[string]interface{}{"stringValue": fmt.Sprint(v)}
+		}
+		encoded = append(encoded, map[string]interface{}{"key": attribute.Key, "value": value})
+	}
+	return encoded
+}
@@ -0,0 +1,134 @@
+package tracing_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/digital-security-lab/hwl-proxy/tracing"
+)
+
+//startCollector starts a stub OTLP collector, which sends the decoded export requests on the channel.
+func startCollector(t *testing.T, status int) (*httptest.Server, chan map[string]interface{}) {
+	requests := make(chan map[string]interface{}, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		var request map[string]interface{}
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" || json.Unmarshal(body, &request) != nil {
+			t.Error("Invalid export request:", r.URL.Path, string(body))
+		}
+		if r.Header.Get("Authorization") != "Bearer test" {
+			t.Error("Missing configured header field")
+		}
+		w.WriteHeader(status)
+		requests <- request
+	}))
+	return server, requests
+}
+
+func TestExporter(t *testing.T) {
+	server, requests := startCollector(t, 200)
+	defer server.Close()
+	exporter, err := tracing.NewExporter(tracing.Options{
+		Endpoint:  server.URL + "/v1/traces",
+		Headers:   map[string]string{"Authorization": "Bearer test"},
+		BatchSize: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Unix(1, 500)
+	span := tracing.Span{
+		TraceID:    tracing.NewTraceID(),
+		SpanID:     tracing.NewSpanID(),
+		Name:       "test",
+		Kind:       tracing.KindServer,
+		Start:      start,
+		End:        start.Add(time.Second),
+		Attributes: []tracing.Attribute{{Key: "http.status_code", Value: 502}, {Key: "module", Value: "incoming"}},
+		Error:      true,
+	}
+	child := span
+	child.ParentID = span.SpanID
+	child.SpanID = tracing.NewSpanID()
+	exporter.Export(span)
+	exporter.Export(child)
+
+	var request map[string]interface{}
+	select {
+	case request = <-requests:
+	case <-time.After(3 * time.Second):
+		t.Fatal("Full batch not exported")
+	}
+	resourceSpans := request["resourceSpans"].([]interface{})[0].(map[string]interface{})
+	service := resourceSpans["resource"].(map[string]interface{})["attributes"].([]interface{})[0].(map[string]interface{})
+	if service["key"] != "service.name" || service["value"].(map[string]interface{})["stringValue"] != "hwl-proxy" {
+		t.Error("Unexpected resource:", resourceSpans["resource"])
+	}
+	spans := resourceSpans["scopeSpans"].([]interface{})[0].(map[string]interface{})["spans"].([]interface{})
+	if len(spans) != 2 {
+		t.Fatal("Unexpected number of spans:", len(spans))
+	}
+	root := spans[0].(map[string]interface{})
+	expected := map[string]interface{}{
+		"traceId":           span.TraceID.String(),
+		"spanId":            span.SpanID.String(),
+		"name":              "test",
+		"kind":              2.0,
+		"startTimeUnixNano": "1000000500",
+		"endTimeUnixNano":   "2000000500",
+	}
+	for key, value := range expected {
+		if root[key] != value {
+			t.Errorf("Unexpected %s: %v", key, root[key])
+		}
+	}
+	if _, ok := root["parentSpanId"]; ok {
+		t.Error("Root span with parent")
+	}
+	if root["status"].(map[string]interface{})["code"] != 2.0 {
+		t.Error("Failed span without error status")
+	}
+	status := root["attributes"].([]interface{})[0].(map[string]interface{})
+	if status["value"].(map[string]interface{})["intValue"] != "502" {
+		t.Error("Unexpected attribute:", status)
+	}
+	if spans[1].(map[string]interface{})["parentSpanId"] != span.SpanID.String() {
+		t.Error("Child span without parent")
+	}
+	exporter.Close()
+	if exporter.Dropped() != 0 {
+		t.Error("Spans dropped:", exporter.Dropped())
+	}
+}
+
+func TestExporterFlush(t *testing.T) {
+	server, requests := startCollector(t, 503)
+	defer server.Close()
+	exporter, err := tracing.NewExporter(tracing.Options{Endpoint: server.URL + "/v1/traces", Headers: map[string]string{"Authorization": "Bearer test"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exporter.Export(tracing.Span{TraceID: tracing.NewTraceID(), SpanID: tracing.NewSpanID(), Start: time.Now(), End: time.Now()})
+	exporter.Close()
+	if len(requests) != 1 {
+		t.Error("Pending span not exported on close")
+	}
+	if exporter.Export(tracing.Span{}) {
+		t.Error("Span exported after close")
+	}
+	if exporter.Dropped() != 2 {
+		t.Error("Rejected span not counted as dropped:", exporter.Dropped())
+	}
+}
+
+func TestInvalidExporterEndpoint(t *testing.T) {
+	for _, endpoint := range []string{"", "127.0.0.1:4318", "ftp://collector/v1/traces"} {
+		if _, err := tracing.NewExporter(tracing.Options{Endpoint: endpoint}); err == nil {
+			t.Error("Invalid endpoint accepted:", endpoint)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package tracing
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// flag of the trace flags indicating that the caller may have recorded the trace
+const FlagSampled = 0x01
+
+// maximum number of list members of tracestate
+const maxTraceStateMembers = 32
+
+var ErrInvalidTraceParent = errors.New("invalid traceparent")
+var ErrInvalidTraceState = errors.New("invalid tracestate")
+
+// traceparent of version 00 and the prefix of later versions, with lowercase hex fields
+var traceParentRegexp = regexp.MustCompile(`^([0-9a-f]{2})-([0-9a-f]{32})-([0-9a-f]{16})-([0-9a-f]{2})$`)
+
+// list member of tracestate, a simple or multi-tenant key and a value without trailing space
+var traceStateMemberRegexp = regexp.MustCompile(`^([a-z][a-z0-9_\-*/]{0,255}|[a-z0-9][a-z0-9_\-*/]{0,240}@[a-z][a-z0-9_\-*/]{0,13})=([\x20-\x2b\x2d-\x3c\x3e-\x7e]{0,255}[\x21-\x2b\x2d-\x3c\x3e-\x7e])$`)
+
+//TraceID identifies a trace.
+type TraceID [16]byte
+
+//SpanID identifies a span within a trace.
+type SpanID [8]byte
+
+//TraceContext is the W3C trace context of a request.
+type TraceContext struct {
+	TraceID  TraceID
+	ParentID SpanID // span of the caller
+	Flags    byte
+	State    string // tracestate, passed on unchanged
+}
+
+//ParseTraceParent parses the value of a traceparent header field.
+//Fields following the flags are ignored for versions other than 00, as required for forward compatibility.
+func ParseTraceParent(value string) (TraceContext, error) {
+	var tc TraceContext
+	if len(value) > 55 && !strings.HasPrefix(value, "00") && value[55] == '-' {
+		value = value[:55]
+	}
+	match := traceParentRegexp.FindStringSubmatch(value)
+	if match == nil || match[1] == "ff" {
+		return tc, ErrInvalidTraceParent
+	}
+	hex.Decode(tc.TraceID[:], []byte(match[2]))
+	hex.Decode(tc.ParentID[:], []byte(match[3]))
+	flags := make([]byte, 1)
+	hex.Decode(flags, []byte(match[4]))
+	tc.Flags = flags[0]
+	if !tc.TraceID.IsValid() || !tc.ParentID.IsValid() {
+		return tc, ErrInvalidTraceParent
+	}
+	return tc, nil
+}
+
+//ValidateTraceState checks the syntax of a tracestate value, which may combine several header fields separated by commas.
+func ValidateTraceState(value string) error {
+	members := 0
+	keys := make(map[string]bool)
+	for _, member := range strings.Split(value, ",") {
+		member = strings.Trim(member, " \t")
+		if len(member) == 0 {
+			continue
+		}
+		match := traceStateMemberRegexp.FindStringSubmatch(member)
+		if match == nil || keys[match[1]] {
+			return ErrInvalidTraceState
+		}
+		keys[match[1]] = true
+		members++
+	}
+	if members > maxTraceStateMembers {
+		return ErrInvalidTraceState
+	}
+	return nil
+}
+
+//NewTraceContext starts a new sampled trace.
+func NewTraceContext() TraceContext {
+	return TraceContext{TraceID: NewTraceID(), Flags: FlagSampled}
+}
+
+//Sampled checks whether the caller may have recorded the trace.
+func (tc TraceContext) Sampled() bool {
+	return tc.Flags&FlagSampled != 0
+}
+
+//TraceParent returns the value of a traceparent header field of version 00.
+func (tc TraceContext) TraceParent() string {
+	return "00-" + tc.TraceID.String() + "-" + tc.ParentID.String() + "-" + hex.EncodeToString([]byte{tc.Flags})
+}
+
+//NewTraceID returns a random trace ID.
+func NewTraceID() TraceID {
+	var id TraceID
+	for !id.IsValid() {
+		rand.Read(id[:])
+	}
+	return id
+}
+
+//NewSpanID returns a random span ID.
+func NewSpanID() SpanID {
+	var id SpanID
+	for !id.IsValid() {
+		rand.Read(id[:])
+	}
+	return id
+}
+
+//IsValid checks that the trace ID is not all zeros.
+func (id TraceID) IsValid() bool {
+	return id != TraceID{}
+}
+
+//String returns the trace ID as lowercase hex.
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+//IsValid checks that the span ID is not all zeros.
+func (id SpanID) IsValid() bool {
+	return id != SpanID{}
+}
+
+//String returns the span ID as lowercase hex.
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
@@ -0,0 +1,87 @@
+package tracing_test
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/digital-security-lab/hwl-proxy/tracing"
+)
+
+func TestParseTraceParent(t *testing.T) {
+	value := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	tc, err := tracing.ParseTraceParent(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tc.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || tc.ParentID.String() != "00f067aa0ba902b7" || !tc.Sampled() {
+		t.Error("Unexpected trace context:", tc)
+	}
+	if tc.TraceParent() != value {
+		t.Error("Unexpected traceparent:", tc.TraceParent())
+	}
+
+	// later versions may append fields
+	tc, err = tracing.ParseTraceParent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-extra")
+	if err != nil || tc.Sampled() {
+		t.Error("Traceparent of a later version not accepted:", err)
+	}
+}
+
+func TestInvalidTraceParent(t *testing.T) {
+	for _, value := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-1",
+		"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01extra",
+	} {
+		if _, err := tracing.ParseTraceParent(value); err != tracing.ErrInvalidTraceParent {
+			t.Error("Invalid traceparent accepted:", value)
+		}
+	}
+}
+
+func TestValidateTraceState(t *testing.T) {
+	for _, value := range []string{
+		"",
+		"rojo=00f067aa0ba902b7",
+		"rojo=00f067aa0ba902b7,congo=t61rcWkgMzE",
+		"rojo=00f067aa0ba902b7 , ,\tcongo=t61rcWkgMzE",
+		"tenant1@vendor=value with spaces",
+	} {
+		if err := tracing.ValidateTraceState(value); err != nil {
+			t.Error("Valid tracestate rejected:", value)
+		}
+	}
+	var members []string
+	for i := 0; i < 33; i++ {
+		members = append(members, "k"+strconv.Itoa(i)+"=1")
+	}
+	for _, value := range []string{
+		"Rojo=1",
+		"rojo=",
+		"rojo=1\x7f",
+		"rojo=a,b",
+		"rojo=1,rojo=2",
+		"rojo=a=b",
+		strings.Join(members, ","),
+	} {
+		if err := tracing.ValidateTraceState(value); err != tracing.ErrInvalidTraceState {
+			t.Error("Invalid tracestate accepted:", value)
+		}
+	}
+}
+
+func TestNewTraceContext(t *testing.T) {
+	tc := tracing.NewTraceContext()
+	tc.ParentID = tracing.NewSpanID()
+	parsed, err := tracing.ParseTraceParent(tc.TraceParent())
+	if err != nil || parsed.TraceID != tc.TraceID || parsed.ParentID != tc.ParentID || !parsed.Sampled() {
+		t.Error("New trace context not parsed:", tc.TraceParent(), err)
+	}
+}
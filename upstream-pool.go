@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net"
+	"time"
+
+	"github.com/digital-security-lab/hwl-proxy/config"
+	"github.com/digital-security-lab/hwl-proxy/pool"
+)
+
+// keep-alive connections to the local intermediary, origin server or next intermediary, shared by all clients
+var upstreamPool = pool.New(pool.Options{})
+
+//newUpstreamPool creates the upstream pool with the configured limits.
+//Connecting, including the wait for a connection if the maximum number of connections is open, is limited by the connect timeout.
+func newUpstreamPool() *pool.Pool {
+	return pool.New(pool.Options{
+		MaxIdle:     proxyConfig.UpstreamMaxIdle,
+		MaxOpen:     proxyConfig.UpstreamMaxOpen,
+		IdleTimeout: time.Duration(proxyConfig.UpstreamIdleTimeout),
+		WaitTimeout: time.Duration(proxyConfig.PhaseTimeouts().Connect),
+		DialTimeout: time.Duration(proxyConfig.PhaseTimeouts().Connect),
+	})
+}
+
+//acquireUpstream returns a pooled connection to the endpoint.
+//If a PROXY protocol header is sent for the client, the connection is bound to the client and never reused.
+func acquireUpstream(endpoint config.Endpoint, proxyClient net.Conn) (*pool.Conn, error) {
+	private := proxyClient != nil && (proxyConfig.SendProxyProtocol == "v1" || proxyConfig.SendProxyProtocol == "v2")
+	var conn *pool.Conn
+	var err error
+	if private {
+		conn, err = upstreamPool.Dial(endpoint.Network, endpoint.Address)
+	} else {
+		conn, err = upstreamPool.Get(endpoint.Network, endpoint.Address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	conn.SetWriteTimeout(time.Duration(proxyConfig.PhaseTimeouts().Write))
+	if private {
+		conn.SetWriteDeadline(proxyConfig.PhaseTimeouts().Write.Deadline())
+		err = sendProxyProtocol(conn.Conn, proxyClient)
+		if err != nil {
+			conn.Release(false)
+			return nil, err
+		}
+	}
+	return conn, nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/digital-security-lab/hwl-proxy/config"
+	"github.com/digital-security-lab/hwl-proxy/utils"
+)
+
+// startPoolTest starts an upstream server that answers each request with the number of its connection.
+// The connection is closed after requests for /close.
+func startPoolTest(t *testing.T) (func(...string) string, func()) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for i := 1; ; i++ {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn, number string) {
+				defer conn.Close()
+				br := bufio.NewReader(conn)
+				for {
+					data, err := utils.ReadUntilBytes(br, []byte("\r\n\r\n"))
+					if err != nil {
+						return
+					}
+					_, target, _ := utils.GetRequestLine(data)
+					response := utils.CreateResponse(200, "OK", []byte(number))
+					if target == "/close" {
+						response = utils.AddHeader(response, "Connection", "close")
+					}
+					conn.Write(response)
+					if target == "/close" {
+						return
+					}
+				}
+			}(conn, string('0'+rune(i)))
+		}
+	}()
+
+	previous, previousPool := proxyConfig, upstreamPool
+	proxyConfig = config.ProxyConfig{Origin: true, ConnTimeout: config.Duration(5 * time.Second), AddressOutLocal: listener.Addr().String(), UpstreamMaxIdle: 2}
+	upstreamPool = newUpstreamPool()
+
+	// request sends requests for the targets on a new client connection and returns the bodies of the responses
+	request := func(targets ...string) string {
+		client, clientBr, stop := startClientTest(t, func(proxyIn net.Conn) {
+			processIncomingRequest(proxyIn, nil)
+		})
+		defer stop()
+		bodies := ""
+		for _, target := range targets {
+			go client.Write([]byte("GET " + target + " HTTP/1.1\r\nHost: a\r\n\r\n"))
+			expectMessage(t, clientBr, `^HTTP/1.1 200 OK\r\nContent-Length: 1\r\n\r\n$`)
+			body, err := utils.ReadByContentLength(clientBr, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			bodies += string(body)
+		}
+		return bodies
+	}
+	return request, func() {
+		listener.Close()
+		proxyConfig, upstreamPool = previous, previousPool
+	}
+}
+
+func TestUpstreamReuse(t *testing.T) {
+	request, stop := startPoolTest(t)
+	defer stop()
+
+	// subsequent clients reuse the upstream connection
+	if bodies := request("/a", "/b") + request("/c"); bodies != "111" {
+		t.Error("Upstream connection not reused:", bodies)
+	}
+}
+
+func TestUpstreamClose(t *testing.T) {
+	request, stop := startPoolTest(t)
+	defer stop()
+
+	// requests after a response with Connection: close are forwarded on a new connection, the client connection persists
+	if bodies := request("/a", "/close", "/b") + request("/c"); bodies != "1122" {
+		t.Error("Unexpected upstream connections:", bodies)
+	}
+}
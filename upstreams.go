@@ -0,0 +1,96 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/digital-security-lab/hwl-proxy/balancer"
+	"github.com/digital-security-lab/hwl-proxy/config"
+	"github.com/digital-security-lab/hwl-proxy/pool"
+	"github.com/digital-security-lab/hwl-proxy/utils"
+)
+
+// balancer of the configured upstreams of the last leg, nil if a single upstream is configured
+var upstreamBalancer *balancer.Balancer
+
+//newUpstreamBalancer creates the balancer of the configured upstreams and starts their active health checks.
+//It returns nil if no upstreams are configured.
+func newUpstreamBalancer() *balancer.Balancer {
+	endpoints, weights, err := proxyConfig.UpstreamEndpoints()
+	if err != nil || len(endpoints) == 0 {
+		return nil
+	}
+	b := balancer.New(endpoints, weights, balancer.Options{
+		Policy:    proxyConfig.Balancing,
+		MaxFails:  proxyConfig.HealthCheck.MaxFails,
+		EjectTime: time.Duration(proxyConfig.HealthCheck.EjectTime),
+		Load: func(endpoint config.Endpoint) int {
+			return upstreamPool.Active(endpoint.Network, endpoint.Address)
+		},
+	})
+	check := proxyConfig.HealthCheck
+	if check.Interval > 0 {
+		timeout := time.Duration(check.Timeout)
+		if timeout <= 0 {
+			timeout = time.Duration(check.Interval)
+		}
+		probe := balancer.TCPProbe(timeout)
+		if len(check.Path) > 0 {
+			probe = balancer.HTTPProbe(timeout, check.Path, check.Host, headerLimits())
+		}
+		go b.CheckHealth(time.Duration(check.Interval), probe, nil)
+	}
+	return b
+}
+
+//selectUpstream returns the endpoint of the last leg for a request, i.e. of the origin server or the next intermediary.
+//With several upstreams, the upstream of the current connection is kept while it is available,
+//unless requests are assigned to upstreams by the hash of their Host and request target.
+func selectUpstream(p *pipeline, data []byte, single func() (config.Endpoint, error)) (config.Endpoint, error) {
+	if upstreamBalancer == nil {
+		return single()
+	}
+	if upstreamBalancer.HashesRequests() {
+		_, target, _ := utils.GetRequestLine(data)
+		host := ""
+		if values := utils.GetHeaderFieldValues(data, []byte("Host")); len(values) > 0 {
+			host = string(values[0])
+		}
+		return upstreamBalancer.Pick(host + target)
+	}
+	endpoint, ok := p.currentEndpoint()
+	if ok && upstreamBalancer.IsAvailable(endpoint) {
+		return endpoint, nil
+	}
+	return upstreamBalancer.Pick("")
+}
+
+//upstreamErrorResponse logs why a request could not be answered by the upstream at address and returns the response to it:
+//504 Gateway Timeout if the upstream did not connect or respond in time, 503 Service Unavailable if no upstream is available
+//and 502 Bad Gateway if connecting failed or the response is invalid.
+//It returns nil if relaying a previous response failed, as the client connection is closed without response then.
+func upstreamErrorResponse(address string, err error) *rejection {
+	if err == errPipelineFailed {
+		return nil
+	}
+	if len(address) > 0 {
+		log.Println("Upstream", address+":", err.Error())
+	} else {
+		log.Println("Upstream:", err.Error())
+	}
+	reason, detail := reasonUpstreamFailed, ""
+	if utils.IsTimeout(err) || err == errResponseTimeout {
+		reason = reasonUpstreamTimeout
+	} else if err == pool.ErrPoolExhausted || err == balancer.ErrNoHealthyUpstream {
+		reason, detail = reasonUpstreamUnavailable, err.Error()
+	}
+	upstreamErrorsTotal.Inc(address, string(reason))
+	return errorResponse(reason, detail)
+}
+
+//reportUpstream records whether the upstream answered a request, so failing upstreams are ejected.
+func reportUpstream(endpoint config.Endpoint, ok bool) {
+	if upstreamBalancer != nil {
+		upstreamBalancer.Report(endpoint, ok)
+	}
+}
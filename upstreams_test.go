@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/digital-security-lab/hwl-proxy/balancer"
+	"github.com/digital-security-lab/hwl-proxy/config"
+	"github.com/digital-security-lab/hwl-proxy/utils"
+)
+
+// startNamedUpstream answers all requests with the name of the upstream.
+func startNamedUpstream(t *testing.T, name string) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				br := bufio.NewReader(conn)
+				for {
+					_, err := utils.ReadUntilBytes(br, []byte("\r\n\r\n"))
+					if err != nil {
+						return
+					}
+					conn.Write(utils.CreateResponse(200, "OK", []byte(name)))
+				}
+			}(conn)
+		}
+	}()
+	return listener
+}
+
+// requestBalanced sends a request on a new client connection and returns the response.
+func requestBalanced(t *testing.T, target string) string {
+	client, br, stop := startClientTest(t, func(proxyIn net.Conn) {
+		processIncomingRequest(proxyIn, nil)
+	})
+	defer stop()
+	go client.Write([]byte("GET " + target + " HTTP/1.1\r\nHost: a\r\n\r\n"))
+	data, err := utils.ReadUntilBytes(br, []byte("\r\n\r\n"))
+	if err == nil {
+		data, err = utils.ReadHTTPBody(br, data, false)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func startBalancerTest(t *testing.T, policy string) func() {
+	a := startNamedUpstream(t, "a")
+	b := startNamedUpstream(t, "b")
+	previous, previousBalancer := proxyConfig, upstreamBalancer
+	proxyConfig = config.ProxyConfig{
+		Origin:      true,
+		ConnTimeout: config.Duration(5 * time.Second),
+		Upstreams:   []config.Upstream{config.Upstream{Address: a.Addr().String()}, config.Upstream{Address: "tcp://" + b.Addr().String()}},
+		Balancing:   policy,
+		HealthCheck: config.HealthCheck{MaxFails: 1, EjectTime: config.Duration(time.Minute)},
+	}
+	upstreamBalancer = newUpstreamBalancer()
+	return func() {
+		a.Close()
+		b.Close()
+		proxyConfig, upstreamBalancer = previous, previousBalancer
+	}
+}
+
+func TestRoundRobinUpstreams(t *testing.T) {
+	stop := startBalancerTest(t, "")
+	defer stop()
+
+	// each client connection is forwarded to the next upstream
+	responses := requestBalanced(t, "/") + requestBalanced(t, "/") + requestBalanced(t, "/")
+	expected := string(utils.CreateResponse(200, "OK", []byte("a"))) + string(utils.CreateResponse(200, "OK", []byte("b")))
+	if responses != expected+string(utils.CreateResponse(200, "OK", []byte("a"))) {
+		t.Error("Unexpected responses:", responses)
+	}
+}
+
+func TestConsistentHashUpstreams(t *testing.T) {
+	stop := startBalancerTest(t, "consistent-hash")
+	defer stop()
+
+	// requests for the same target are forwarded to the same upstream
+	for _, target := range []string{"/1", "/2", "/3"} {
+		first := requestBalanced(t, target)
+		second := requestBalanced(t, target)
+		if first != second {
+			t.Error("Requests for", target, "forwarded to different upstreams:", first, second)
+		}
+	}
+}
+
+func TestNoHealthyUpstream(t *testing.T) {
+	stop := startBalancerTest(t, "consistent-hash")
+	defer stop()
+
+	endpoints, _, _ := proxyConfig.UpstreamEndpoints()
+	for _, endpoint := range endpoints {
+		reportUpstream(endpoint, false)
+	}
+	response := requestBalanced(t, "/")
+	if response != string(errorResponse(reasonUpstreamUnavailable, balancer.ErrNoHealthyUpstream.Error()).response) {
+		t.Error("Unexpected response:", response)
+	}
+}
+
+func TestUpstreamConnectFailure(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+	previous := proxyConfig
+	proxyConfig = config.ProxyConfig{Origin: true, ConnTimeout: config.Duration(5 * time.Second), AddressOutLocal: "tcp://" + address}
+	defer func() { proxyConfig = previous }()
+
+	response := requestBalanced(t, "/")
+	if response != string(errorResponse(reasonUpstreamFailed, "").response) {
+		t.Error("Unexpected response:", response)
+	}
+}
+
+func TestUpstreamResponseFailures(t *testing.T) {
+	var tests = map[string]func(net.Conn){
+		"invalid response": func(upstream net.Conn) { upstream.Write([]byte("garbage\r\n\r\n")) },
+		"invalid framing":  func(upstream net.Conn) { upstream.Write([]byte("HTTP/1.1 200 OK\r\nContent-Length: x\r\n\r\n")) },
+		"closed":           func(upstream net.Conn) { upstream.Close() },
+	}
+	for name, fail := range tests {
+		conns, stop := startProxyTest(t, config.ProxyConfig{}, defaultTestWhitelist)
+		go conns.client.Write([]byte("GET / HTTP/1.1\r\nHost: a\r\n\r\n"))
+		expectMessage(t, conns.upstreamBr, `^GET / HTTP/1.1\r\n`)
+		go fail(conns.upstream)
+		data, err := utils.ReadUntilBytes(conns.clientBr, []byte("\r\n\r\n"))
+		if err != nil || !regexp.MustCompile(`^HTTP/1.1 502 Bad Gateway\r\n`).Match(data) {
+			t.Error("Unexpected response to", name+":", string(data), err)
+		}
+		stop()
+	}
+}
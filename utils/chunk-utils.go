@@ -0,0 +1,245 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+)
+
+const (
+	maxChunkSizeDigits = 16   // hex digits of a chunk size, larger sizes overflow int64
+	maxChunkLineSize   = 4096 // length of a chunk-size line including chunk extensions
+	maxTrailerSize     = 8192 // size of the trailer section
+
+	regexQuotedString = `"([\x09\x20\x21\x23-\x5B\x5D-\x7E\x80-\xFF]|\\[\x09\x20-\x7E\x80-\xFF])*"`
+	regexChunkExt     = `^([\x09\x20]*;[\x09\x20]*` + regexToken + `+([\x09\x20]*=[\x09\x20]*(` + regexToken + `+|` + regexQuotedString + `))?)+$`
+	regexChunkSize    = `^[0-9a-fA-F]+$`
+)
+
+var chunkExtRegexp = regexp.MustCompile(regexChunkExt)
+var chunkSizeRegexp = regexp.MustCompile(regexChunkSize)
+
+// fields that control framing, routing or connection handling, which must not be sent in a trailer section
+var prohibitedTrailers = []string{"Connection", "Content-Length", "Host", "Keep-Alive", "TE", "Trailer", "Transfer-Encoding", "Upgrade"}
+
+var ErrInvalidChunk = errors.New("invalid chunk")
+var ErrChunkExtension = errors.New("chunk extensions are not permitted")
+var ErrInvalidTrailer = errors.New("invalid trailer section")
+var ErrBodyTooLarge = errors.New("body too large")
+var ErrTooManyChunks = errors.New("too many chunks")
+
+//ChunkOptions configures how chunked bodies are decoded and forwarded.
+type ChunkOptions struct {
+	RejectExtensions bool                           // reject chunk extensions instead of stripping them
+	FilterTrailers   func(fields [][]byte) [][]byte // returns the trailer fields to forward, all fields are forwarded if nil
+	MaxSize          int64                          // maximum size of the payload in bytes, unlimited if 0
+	MaxChunks        int                            // maximum number of chunks, unlimited if 0
+}
+
+//readLine reads a line terminated by CRLF, which must not exceed limit bytes.
+func readLine(src *bufio.Reader, limit int) ([]byte, error) {
+	var line []byte
+	for {
+		fragment, err := src.ReadSlice('\n')
+		line = append(line, fragment...)
+		if len(line) > limit {
+			return nil, ErrInvalidChunk
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		if !bytes.HasSuffix(line, []byte("\r\n")) {
+			return nil, ErrInvalidChunk
+		}
+		return line, nil
+	}
+}
+
+//ParseChunkSize parses a chunk-size line and returns the chunk size and the chunk extensions.
+//The size is hexadecimal and limited to maxChunkSizeDigits digits.
+func ParseChunkSize(line []byte) (int64, []byte, error) {
+	line = bytes.TrimSuffix(line, []byte("\r\n"))
+	sizeField := line
+	var extensions []byte
+	index := bytes.IndexByte(line, ';')
+	if index > -1 {
+		sizeField = bytes.TrimRight(line[:index], "\x09\x20")
+		extensions = line[len(sizeField):]
+		if !chunkExtRegexp.Match(extensions) {
+			return 0, nil, ErrInvalidChunk
+		}
+	}
+	if len(sizeField) > maxChunkSizeDigits || !chunkSizeRegexp.Match(sizeField) {
+		return 0, nil, ErrInvalidChunk
+	}
+	size, err := strconv.ParseInt(string(sizeField), 16, 64)
+	if err != nil {
+		return 0, nil, ErrInvalidChunk
+	}
+	return size, extensions, nil
+}
+
+//ReadTrailers reads the trailer section following the last chunk and returns its fields.
+//Fields that must not be sent in a trailer section, e.g. Content-Length, are dropped.
+func ReadTrailers(src *bufio.Reader) ([][]byte, error) {
+	var fields [][]byte
+	size := 0
+	for {
+		line, err := readLine(src, maxTrailerSize)
+		if err != nil {
+			if err == ErrInvalidChunk {
+				err = ErrInvalidTrailer
+			}
+			return nil, err
+		}
+		size += len(line)
+		if size > maxTrailerSize {
+			return nil, ErrInvalidTrailer
+		}
+		field := line[:len(line)-2]
+		if len(field) == 0 {
+			return fields, nil
+		}
+		if !IsValidHeader(field) {
+			return nil, ErrInvalidTrailer
+		}
+		if !isProhibitedTrailer(GetHeaderFieldName(field)) {
+			fields = append(fields, field)
+		}
+	}
+}
+
+//isProhibitedTrailer checks whether a field must not be sent in a trailer section.
+func isProhibitedTrailer(name []byte) bool {
+	for _, prohibited := range prohibitedTrailers {
+		if bytes.EqualFold(name, []byte(prohibited)) {
+			return true
+		}
+	}
+	return false
+}
+
+//readChunks decodes a chunked body. For each chunk, readChunk must consume exactly size bytes from src.
+//idle is called before the next chunk-size line is read. The forwarded trailer fields are returned.
+//Bodies exceeding the size or chunk limits of the options are rejected before the exceeding chunk is read.
+func readChunks(src *bufio.Reader, options ChunkOptions, readChunk func(size int64) error, idle func() error) ([][]byte, error) {
+	var total int64
+	for chunks := 1; ; chunks++ {
+		err := idle()
+		if err != nil {
+			return nil, err
+		}
+		line, err := readLine(src, maxChunkLineSize)
+		if err != nil {
+			return nil, err
+		}
+		size, extensions, err := ParseChunkSize(line)
+		if err != nil {
+			return nil, err
+		}
+		if len(extensions) > 0 && options.RejectExtensions {
+			return nil, ErrChunkExtension
+		}
+		if size == 0 {
+			break
+		}
+		if options.MaxChunks > 0 && chunks > options.MaxChunks {
+			return nil, ErrTooManyChunks
+		}
+		if options.MaxSize > 0 && size > options.MaxSize-total {
+			return nil, ErrBodyTooLarge
+		}
+		total += size
+		err = readChunk(size)
+		if err != nil {
+			return nil, err
+		}
+		crlf := make([]byte, 2)
+		_, err = io.ReadFull(src, crlf)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(crlf, []byte("\r\n")) {
+			return nil, ErrInvalidChunk
+		}
+	}
+	fields, err := ReadTrailers(src)
+	if err != nil {
+		return nil, err
+	}
+	if options.FilterTrailers != nil {
+		fields = options.FilterTrailers(fields)
+	}
+	return fields, nil
+}
+
+//CopyChunks streams a chunked body chunk by chunk from the reader to the writer and re-encodes it.
+//Chunk extensions are stripped and trailer fields are filtered according to the options.
+//Chunks are flushed as soon as no further data is buffered, so event streams are relayed without delay.
+//It returns the number of payload bytes copied.
+func CopyChunks(dst FlushWriter, src *bufio.Reader, options ChunkOptions) (int64, error) {
+	var copied int64
+	readChunk := func(size int64) error {
+		_, err := fmt.Fprintf(dst, "%x\r\n", size)
+		if err != nil {
+			return err
+		}
+		length, err := CopyByContentLength(dst, src, size)
+		copied += length
+		if err != nil {
+			return err
+		}
+		_, err = dst.Write([]byte("\r\n"))
+		return err
+	}
+	idle := func() error {
+		return flushIfIdle(dst, src)
+	}
+	fields, err := readChunks(src, options, readChunk, idle)
+	if err != nil {
+		return copied, err
+	}
+	_, err = dst.Write(append([]byte("0\r\n"), joinFields(fields)...))
+	return copied, err
+}
+
+//DecodeChunks reads a chunked body and writes its payload without chunked encoding to the writer.
+//If the payload exceeds limit bytes, ErrBodyTooLarge is returned. The forwarded trailer fields are returned.
+func DecodeChunks(dst io.Writer, src *bufio.Reader, options ChunkOptions, limit int64) ([][]byte, error) {
+	var decoded int64
+	readChunk := func(size int64) error {
+		if size > limit-decoded {
+			return ErrBodyTooLarge
+		}
+		decoded += size
+		_, err := io.CopyN(dst, src, size)
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	idle := func() error {
+		return nil
+	}
+	return readChunks(src, options, readChunk, idle)
+}
+
+//joinFields returns the fields as field section terminated by an empty line.
+func joinFields(fields [][]byte) []byte {
+	var data []byte
+	for _, field := range fields {
+		data = append(data, field...)
+		data = append(data, []byte("\r\n")...)
+	}
+	return append(data, []byte("\r\n")...)
+}
@@ -0,0 +1,140 @@
+package utils_test
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+
+	"github.com/digital-security-lab/hwl-proxy/utils"
+)
+
+func TestParseChunkSize(t *testing.T) {
+	valid := map[string]int64{
+		"0\r\n":                0,
+		"a\r\n":                10,
+		"10\r\n":               16,
+		"FF\r\n":               255,
+		"7fffffffffffffff\r\n": 9223372036854775807,
+		"5;name\r\n":           5,
+		"5 ; name=value\r\n":   5,
+		"5;name=\"a;b\";x\r\n": 5,
+	}
+	for line, expected := range valid {
+		size, _, err := utils.ParseChunkSize([]byte(line))
+		if err != nil || size != expected {
+			t.Error("Invalid size:", line, size, err)
+		}
+	}
+
+	_, extensions, _ := utils.ParseChunkSize([]byte("5 ; name=value\r\n"))
+	if string(extensions) != " ; name=value" {
+		t.Error("Invalid extensions:", string(extensions))
+	}
+
+	invalid := []string{
+		"\r\n",
+		"-1\r\n",
+		"0x5\r\n",
+		"g\r\n",
+		" 5\r\n",
+		"8000000000000000\r\n",
+		"00000000000000001\r\n",
+		"5;\r\n",
+		"5;name=\"value\r\n",
+		"5;na me\r\n",
+	}
+	for _, line := range invalid {
+		_, _, err := utils.ParseChunkSize([]byte(line))
+		if err == nil {
+			t.Error("Error not returned for chunk-size line:", line)
+		}
+	}
+}
+
+func TestReadTrailers(t *testing.T) {
+	br := bufio.NewReader(bytes.NewBufferString("Checksum: abc\r\nX-Trace: 1\r\n\r\nGET"))
+	fields, err := utils.ReadTrailers(br)
+	if err != nil || len(fields) != 2 || string(fields[0]) != "Checksum: abc" || string(fields[1]) != "X-Trace: 1" {
+		t.Error("Invalid trailers:", fields, err)
+	}
+
+	br = bufio.NewReader(bytes.NewBufferString("Content-Length: 0\r\nChecksum: abc\r\ntransfer-encoding: chunked\r\nHost: a\r\nTE: trailers\r\n\r\n"))
+	fields, err = utils.ReadTrailers(br)
+	if err != nil || len(fields) != 1 || string(fields[0]) != "Checksum: abc" {
+		t.Error("Prohibited trailer fields not dropped:", fields, err)
+	}
+
+	br = bufio.NewReader(bytes.NewBufferString("Invalid Field\r\n\r\n"))
+	_, err = utils.ReadTrailers(br)
+	if err != utils.ErrInvalidTrailer {
+		t.Error("Error not returned for invalid trailer field:", err)
+	}
+
+	br = bufio.NewReader(bytes.NewBufferString("Checksum: abc\n\r\n"))
+	_, err = utils.ReadTrailers(br)
+	if err != utils.ErrInvalidTrailer {
+		t.Error("Error not returned for bare LF:", err)
+	}
+}
+
+func TestCopyChunksExtensionsAndTrailers(t *testing.T) {
+	chunks := "3;name=value\r\nabc\r\nA\r\nabcdefghij\r\n0;last\r\nChecksum: abc\r\nX-Internal: 1\r\n\r\n"
+	recorder := &flushRecorder{flushed: make(chan string, 10)}
+	options := utils.ChunkOptions{
+		FilterTrailers: func(fields [][]byte) [][]byte {
+			return fields[:1]
+		},
+	}
+	length, err := utils.CopyChunks(recorder, bufio.NewReader(bytes.NewBufferString(chunks)), options)
+	expected := "3\r\nabc\r\na\r\nabcdefghij\r\n0\r\nChecksum: abc\r\n\r\n"
+	if err != nil || length != 13 || recorder.String() != expected {
+		t.Error("Invalid copy:", recorder.String(), length, err)
+	}
+
+	options = utils.ChunkOptions{RejectExtensions: true}
+	recorder = &flushRecorder{flushed: make(chan string, 10)}
+	_, err = utils.CopyChunks(recorder, bufio.NewReader(bytes.NewBufferString(chunks)), options)
+	if err != utils.ErrChunkExtension {
+		t.Error("Error not returned for chunk extension:", err)
+	}
+
+	recorder = &flushRecorder{flushed: make(chan string, 10)}
+	_, err = utils.CopyChunks(recorder, bufio.NewReader(bytes.NewBufferString("3\r\nabcd\r\n0\r\n\r\n")), utils.ChunkOptions{})
+	if err != utils.ErrInvalidChunk {
+		t.Error("Error not returned for chunk exceeding its size:", err)
+	}
+}
+
+func TestDecodeChunks(t *testing.T) {
+	chunks := "3\r\nabc\r\n4;x=y\r\ndefg\r\n0\r\nChecksum: abc\r\n\r\n"
+	var body bytes.Buffer
+	fields, err := utils.DecodeChunks(&body, bufio.NewReader(bytes.NewBufferString(chunks)), utils.ChunkOptions{}, 7)
+	if err != nil || body.String() != "abcdefg" || len(fields) != 1 || string(fields[0]) != "Checksum: abc" {
+		t.Error("Invalid decoding:", body.String(), fields, err)
+	}
+
+	body.Reset()
+	_, err = utils.DecodeChunks(&body, bufio.NewReader(bytes.NewBufferString(chunks)), utils.ChunkOptions{}, 6)
+	if err != utils.ErrBodyTooLarge {
+		t.Error("Error not returned for body exceeding the limit:", err)
+	}
+}
+
+func TestCopyChunksLimits(t *testing.T) {
+	chunks := "3\r\nabc\r\n3\r\ndef\r\n0\r\n\r\n"
+	var tests = []struct {
+		options utils.ChunkOptions
+		err     error
+	}{
+		{utils.ChunkOptions{MaxSize: 6, MaxChunks: 2}, nil},
+		{utils.ChunkOptions{MaxSize: 5}, utils.ErrBodyTooLarge},
+		{utils.ChunkOptions{MaxChunks: 1}, utils.ErrTooManyChunks},
+	}
+	for _, test := range tests {
+		recorder := &flushRecorder{flushed: make(chan string, 10)}
+		_, err := utils.CopyChunks(recorder, bufio.NewReader(bytes.NewBufferString(chunks)), test.options)
+		if err != test.err {
+			t.Errorf("%+v: got %v, expected %v", test.options, err, test.err)
+		}
+	}
+}
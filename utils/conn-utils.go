@@ -0,0 +1,165 @@
+package utils
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//BufferedConn is a connection with a reader and a writer that live as long as the connection.
+//Data buffered while reading one message remains available for the next message on the connection.
+type BufferedConn struct {
+	net.Conn
+	Reader    *bufio.Reader
+	Writer    *bufio.Writer
+	deadlines *deadlineConn
+}
+
+//deadlineConn extends the deadline of the connection before each read or write by the respective timeout, if set.
+//Reads are further limited by the minimum read rate, if set.
+type deadlineConn struct {
+	writeTimeout int64 // time.Duration, accessed atomically
+	bytesRead    int64 // accessed atomically
+	bytesWritten int64 // accessed atomically
+	net.Conn
+	readTimeout  time.Duration
+	readDeadline time.Time // deadline set by SetReadDeadline
+	minRate      int       // minimum bytes per second after the grace period
+	rateStart    time.Time // start of the grace period
+	rateBytes    int64     // bytes read since the start of the grace period
+	mutex        sync.Mutex
+}
+
+//NewBufferedConn wraps a connection with a persistent buffered reader and writer.
+func NewBufferedConn(conn net.Conn) *BufferedConn {
+	deadlines := &deadlineConn{Conn: conn}
+	return &BufferedConn{
+		Conn:      conn,
+		Reader:    bufio.NewReader(deadlines),
+		Writer:    bufio.NewWriter(deadlines),
+		deadlines: deadlines,
+	}
+}
+
+//Read reads data through the buffered reader.
+func (conn *BufferedConn) Read(b []byte) (int, error) {
+	return conn.Reader.Read(b)
+}
+
+//Write writes data to the buffered writer. The data is sent on Flush or if the buffer is full.
+func (conn *BufferedConn) Write(b []byte) (int, error) {
+	return conn.Writer.Write(b)
+}
+
+//Flush sends the buffered data.
+func (conn *BufferedConn) Flush() error {
+	return conn.Writer.Flush()
+}
+
+//WriteAndFlush writes data and sends it immediately.
+func (conn *BufferedConn) WriteAndFlush(b []byte) error {
+	_, err := conn.Writer.Write(b)
+	if err != nil {
+		return err
+	}
+	return conn.Writer.Flush()
+}
+
+//SetReadTimeout sets the read deadline to the timeout from now before each read from the connection,
+//so it only expires if no data is received for that long. A timeout of 0 keeps the deadline set by SetReadDeadline.
+func (conn *BufferedConn) SetReadTimeout(timeout time.Duration) {
+	conn.deadlines.mutex.Lock()
+	defer conn.deadlines.mutex.Unlock()
+	conn.deadlines.readTimeout = timeout
+}
+
+//SetReadDeadline sets the deadline of reads from the connection, including a read in progress.
+func (conn *BufferedConn) SetReadDeadline(t time.Time) error {
+	conn.deadlines.mutex.Lock()
+	defer conn.deadlines.mutex.Unlock()
+	conn.deadlines.readDeadline = t
+	return conn.Conn.SetReadDeadline(t)
+}
+
+//SetMinReadRate requires the data read from now on to arrive at rate bytes per second on average once the grace period ended.
+//Otherwise, reads time out. A rate of 0 removes the requirement.
+func (conn *BufferedConn) SetMinReadRate(rate int, grace time.Duration) {
+	conn.deadlines.mutex.Lock()
+	defer conn.deadlines.mutex.Unlock()
+	conn.deadlines.minRate = rate
+	conn.deadlines.rateStart = time.Now().Add(grace)
+	conn.deadlines.rateBytes = 0
+}
+
+//SetWriteTimeout sets the write deadline to the timeout from now before each write to the connection.
+//A timeout of 0 keeps the deadline set by SetWriteDeadline.
+func (conn *BufferedConn) SetWriteTimeout(timeout time.Duration) {
+	atomic.StoreInt64(&conn.deadlines.writeTimeout, int64(timeout))
+}
+
+//BytesRead returns the number of bytes read from the connection, including data still buffered.
+func (conn *BufferedConn) BytesRead() int64 {
+	return atomic.LoadInt64(&conn.deadlines.bytesRead)
+}
+
+//BytesConsumed returns the number of bytes read from the connection and consumed from the buffered reader.
+//The reader must not be used concurrently.
+func (conn *BufferedConn) BytesConsumed() int64 {
+	return conn.BytesRead() - int64(conn.Reader.Buffered())
+}
+
+//BytesWritten returns the number of bytes written to the connection, excluding data still buffered.
+func (conn *BufferedConn) BytesWritten() int64 {
+	return atomic.LoadInt64(&conn.deadlines.bytesWritten)
+}
+
+//Read extends the read deadline and reads from the connection.
+//With a minimum read rate, the deadline is the time by which the data read so far had to arrive, if that is earlier.
+func (conn *deadlineConn) Read(b []byte) (int, error) {
+	conn.mutex.Lock()
+	if conn.readTimeout > 0 || conn.minRate > 0 {
+		deadline := conn.readDeadline
+		if conn.readTimeout > 0 {
+			deadline = time.Now().Add(conn.readTimeout)
+		}
+		if conn.minRate > 0 {
+			rateDeadline := conn.rateStart.Add(time.Duration(conn.rateBytes * int64(time.Second) / int64(conn.minRate)))
+			if deadline.IsZero() || rateDeadline.Before(deadline) {
+				deadline = rateDeadline
+			}
+		}
+		conn.Conn.SetReadDeadline(deadline)
+	}
+	conn.mutex.Unlock()
+	n, err := conn.Conn.Read(b)
+	atomic.AddInt64(&conn.bytesRead, int64(n))
+	conn.mutex.Lock()
+	conn.rateBytes += int64(n)
+	conn.mutex.Unlock()
+	return n, err
+}
+
+//Write extends the write deadline and writes to the connection.
+func (conn *deadlineConn) Write(b []byte) (int, error) {
+	if timeout := atomic.LoadInt64(&conn.writeTimeout); timeout > 0 {
+		conn.Conn.SetWriteDeadline(time.Now().Add(time.Duration(timeout)))
+	}
+	n, err := conn.Conn.Write(b)
+	atomic.AddInt64(&conn.bytesWritten, int64(n))
+	return n, err
+}
+
+//IsClosed checks whether an error is caused by using a connection that was closed.
+func IsClosed(err error) bool {
+	return err == io.ErrClosedPipe || (err != nil && strings.Contains(err.Error(), "use of closed network connection"))
+}
+
+//IsTimeout checks whether an error is caused by an expired deadline.
+func IsTimeout(err error) bool {
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
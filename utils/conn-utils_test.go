@@ -0,0 +1,112 @@
+package utils_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/digital-security-lab/hwl-proxy/utils"
+)
+
+func TestBufferedConn(t *testing.T) {
+	connA, connB := net.Pipe()
+	defer connA.Close()
+	defer connB.Close()
+	connA.SetDeadline(time.Now().Add(time.Second))
+	connB.SetDeadline(time.Now().Add(time.Second))
+	bufferedA := utils.NewBufferedConn(connA)
+	bufferedB := utils.NewBufferedConn(connB)
+
+	// both messages are sent with a single write
+	go func() {
+		bufferedA.Write([]byte("first\r\n\r\n"))
+		bufferedA.Write([]byte("second\r\n\r\n"))
+		bufferedA.Flush()
+	}()
+
+	// data buffered while reading the first message is preserved for the second one
+	first, err := utils.ReadUntilBytes(bufferedB.Reader, []byte("\r\n\r\n"))
+	if err != nil || string(first) != "first\r\n\r\n" {
+		t.Error("Invalid first message:", string(first), err)
+	}
+	if consumed := bufferedB.BytesConsumed(); consumed != int64(len(first)) {
+		t.Error("Invalid number of consumed bytes:", consumed)
+	}
+	second, err := utils.ReadUntilBytes(bufferedB.Reader, []byte("\r\n\r\n"))
+	if err != nil || string(second) != "second\r\n\r\n" {
+		t.Error("Invalid second message:", string(second), err)
+	}
+
+	go bufferedB.WriteAndFlush([]byte("reply"))
+	buf := make([]byte, 5)
+	length, err := bufferedA.Read(buf)
+	if err != nil || string(buf[:length]) != "reply" {
+		t.Error("Invalid reply:", string(buf[:length]), err)
+	}
+}
+
+func TestBufferedConnReadTimeout(t *testing.T) {
+	connA, connB := net.Pipe()
+	defer connA.Close()
+	defer connB.Close()
+	buffered := utils.NewBufferedConn(connB)
+	buffered.SetReadTimeout(100 * time.Millisecond)
+
+	// the deadline is extended by each read, so slow data within the timeout is received
+	go func() {
+		for i := 0; i < 4; i++ {
+			time.Sleep(50 * time.Millisecond)
+			connA.Write([]byte("x"))
+		}
+	}()
+	buf := make([]byte, 1)
+	for i := 0; i < 4; i++ {
+		_, err := buffered.Read(buf)
+		if err != nil {
+			t.Fatal("Read timed out although data was received:", err)
+		}
+	}
+	_, err := buffered.Read(buf)
+	if !utils.IsTimeout(err) {
+		t.Error("Read did not time out:", err)
+	}
+
+	// without a timeout, the fixed deadline applies
+	buffered.SetReadTimeout(0)
+	buffered.SetReadDeadline(time.Now().Add(-time.Second))
+	_, err = buffered.Read(buf)
+	if !utils.IsTimeout(err) {
+		t.Error("Deadline not applied:", err)
+	}
+}
+
+func TestBufferedConnMinReadRate(t *testing.T) {
+	connA, connB := net.Pipe()
+	defer connA.Close()
+	defer connB.Close()
+	buffered := utils.NewBufferedConn(connB)
+	buffered.SetMinReadRate(100, 50*time.Millisecond)
+
+	// a byte every 30ms falls behind the required byte every 10ms once the grace period ended
+	go func() {
+		for i := 0; i < 20; i++ {
+			time.Sleep(30 * time.Millisecond)
+			if _, err := connA.Write([]byte("x")); err != nil {
+				return
+			}
+		}
+	}()
+	buf := make([]byte, 1)
+	var err error
+	received := 0
+	for err == nil {
+		_, err = buffered.Read(buf)
+		received++
+	}
+	if !utils.IsTimeout(err) || received > 10 {
+		t.Error("Slow data not interrupted:", received, err)
+	}
+	if buffered.BytesRead() != int64(received-1) {
+		t.Error("Invalid number of bytes read:", buffered.BytesRead())
+	}
+}
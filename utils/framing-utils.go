@@ -0,0 +1,250 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	maxContentLengthDigits = 18 // decimal digits of a Content-Length, larger values may overflow int64
+
+	regexContentLength = `^[0-9]+$`
+)
+
+var tokenRegexp = regexp.MustCompile(`^` + regexToken + `+$`)
+var contentLengthRegexp = regexp.MustCompile(regexContentLength)
+
+// transfer codings the proxy can forward, any other coding is rejected
+var knownTransferCodings = map[string]bool{
+	"chunked":    true,
+	"compress":   true,
+	"deflate":    true,
+	"gzip":       true,
+	"x-compress": true,
+	"x-gzip":     true,
+}
+
+var ErrInvalidFramingHeader = errors.New("malformed Content-Length or Transfer-Encoding header field")
+var ErrInvalidContentLength = errors.New("invalid Content-Length")
+var ErrConflictingContentLength = errors.New("conflicting Content-Length values")
+var ErrDuplicateContentLength = errors.New("duplicate Content-Length")
+var ErrContentLengthAndTransferEncoding = errors.New("Content-Length together with Transfer-Encoding")
+var ErrInvalidTransferEncoding = errors.New("invalid Transfer-Encoding")
+var ErrChunkedNotFinal = errors.New("chunked is not the final transfer coding")
+var ErrUnknownTransferCoding = errors.New("unknown transfer coding")
+var ErrTransferEncodingHTTP10 = errors.New("Transfer-Encoding in HTTP/1.0 request")
+
+//Framing describes how the end of a message body is determined.
+type Framing struct {
+	Chunked       bool  // body is transferred with chunked encoding
+	ContentLength int64 // length of the body if not chunked, -1 if the message has no Content-Length
+	Close         bool  // connection must be closed after the message, e.g. as its framing was ambiguous or the body ends with the connection
+}
+
+//ValidateRequestFraming validates the framing headers of a request according to RFC 9112, section 6.3,
+//and returns the request with a single Content-Length or Transfer-Encoding header field.
+//If strict is false, Content-Length is removed if Transfer-Encoding is present, identical Content-Length values are merged
+//and leading zeros are removed. The connection must be closed after such a request, as indicated by Framing.Close.
+func ValidateRequestFraming(data []byte, strict bool) ([]byte, Framing, error) {
+	framing := Framing{ContentLength: -1}
+	err := checkFramingHeaderLines(data)
+	if err != nil {
+		return nil, framing, err
+	}
+	transferEncoding := GetHeaderFieldValues(data, []byte("Transfer-Encoding"))
+	contentLength := GetHeaderFieldValues(data, []byte("Content-Length"))
+
+	if len(transferEncoding) > 0 {
+		codings, err := parseTransferCodings(transferEncoding)
+		if err != nil {
+			return nil, framing, err
+		}
+		_, _, version := GetRequestLine(data)
+		if version == "HTTP/1.0" {
+			if strict {
+				return nil, framing, ErrTransferEncodingHTTP10
+			}
+			framing.Close = true
+		}
+		if len(contentLength) > 0 {
+			if strict {
+				return nil, framing, ErrContentLengthAndTransferEncoding
+			}
+			data = RemoveHeader(data, "Content-Length", 0)
+			framing.Close = true
+		}
+		data = setSingleHeader(data, "Transfer-Encoding", transferEncoding, strings.Join(codings, ", "))
+		framing.Chunked = true
+		return data, framing, nil
+	}
+
+	if len(contentLength) > 0 {
+		length, err := parseContentLength(contentLength, strict)
+		if err != nil {
+			return nil, framing, err
+		}
+		data = setSingleHeader(data, "Content-Length", contentLength, strconv.FormatInt(length, 10))
+		framing.ContentLength = length
+	}
+	return data, framing, nil
+}
+
+//ResponseFraming determines the framing of a response to a request with the given method according to RFC 9112, section 6.3,
+//and returns the response without Content-Length if Transfer-Encoding is present.
+//If neither is present, the body ends when the connection is closed.
+func ResponseFraming(data []byte, method string) ([]byte, Framing, error) {
+	framing := Framing{ContentLength: -1}
+	code := GetStatusCode(data)
+	if method == "HEAD" || (code >= 100 && code < 200) || code == 204 || code == 304 {
+		framing.ContentLength = 0
+		// the connection does not transport HTTP anymore after switching protocols
+		framing.Close = code == 101
+		return data, framing, nil
+	}
+	transferEncoding := GetHeaderFieldValues(data, []byte("Transfer-Encoding"))
+	contentLength := GetHeaderFieldValues(data, []byte("Content-Length"))
+	if len(transferEncoding) > 0 {
+		if len(contentLength) > 0 {
+			data = RemoveHeader(data, "Content-Length", 0)
+		}
+		framing.Chunked = IsChunked(data)
+		framing.Close = !framing.Chunked
+		return data, framing, nil
+	}
+	if len(contentLength) > 0 {
+		length, err := parseContentLength(contentLength, false)
+		if err != nil {
+			return nil, framing, err
+		}
+		framing.ContentLength = length
+		return data, framing, nil
+	}
+	framing.Close = true
+	return data, framing, nil
+}
+
+//IsCloseDelimited checks whether the body ends when the connection is closed.
+func (framing Framing) IsCloseDelimited() bool {
+	return framing.Close && !framing.Chunked && framing.ContentLength < 0
+}
+
+//checkFramingHeaderLines rejects framing header fields that do not comply with the field syntax, e.g. with whitespace
+//before the colon or continued on the next line, as other recipients may interpret them differently.
+func checkFramingHeaderLines(data []byte) error {
+	header := bytes.SplitN(data, []byte("\r\n\r\n"), 2)[0]
+	lines := bytes.Split(header, []byte("\r\n"))
+	previousFraming := false
+	for _, line := range lines[1:] {
+		if len(line) > 0 && (line[0] == ' ' || line[0] == '\t') {
+			if previousFraming {
+				return ErrInvalidFramingHeader
+			}
+			continue
+		}
+		previousFraming = false
+		index := bytes.IndexByte(line, ':')
+		if index < 0 {
+			continue
+		}
+		name := strings.ToLower(strings.TrimSpace(string(line[:index])))
+		if name == "content-length" || name == "transfer-encoding" {
+			if !IsValidHeader(line) {
+				return ErrInvalidFramingHeader
+			}
+			previousFraming = true
+		}
+	}
+	return nil
+}
+
+//parseTransferCodings returns the transfer codings of the Transfer-Encoding values in lower case.
+//Chunked must be applied exactly once as final coding and all codings must be known.
+func parseTransferCodings(values [][]byte) ([]string, error) {
+	var codings []string
+	for _, value := range values {
+		for _, element := range strings.Split(string(value), ",") {
+			element = strings.Trim(element, " \t")
+			if len(element) == 0 {
+				continue
+			}
+			coding := element
+			parameters := ""
+			index := strings.IndexByte(element, ';')
+			if index > -1 {
+				coding = strings.TrimRight(element[:index], " \t")
+				parameters = element[index:]
+			}
+			if !tokenRegexp.MatchString(coding) {
+				return nil, ErrInvalidTransferEncoding
+			}
+			coding = strings.ToLower(coding)
+			if !knownTransferCodings[coding] {
+				return nil, ErrUnknownTransferCoding
+			}
+			if coding == "chunked" && len(parameters) > 0 {
+				return nil, ErrInvalidTransferEncoding
+			}
+			codings = append(codings, coding+parameters)
+		}
+	}
+	if len(codings) == 0 {
+		return nil, ErrInvalidTransferEncoding
+	}
+	for i, coding := range codings {
+		if (coding == "chunked") != (i == len(codings)-1) {
+			return nil, ErrChunkedNotFinal
+		}
+	}
+	return codings, nil
+}
+
+//parseContentLength returns the length announced by the Content-Length values.
+//A list of identical values is only accepted if strict is false.
+func parseContentLength(values [][]byte, strict bool) (int64, error) {
+	var length int64 = -1
+	elements := 0
+	for _, value := range values {
+		for _, element := range bytes.Split(value, []byte(",")) {
+			element = bytes.Trim(element, " \t")
+			if !contentLengthRegexp.Match(element) {
+				return 0, ErrInvalidContentLength
+			}
+			if strict && len(element) > 1 && element[0] == '0' {
+				return 0, ErrInvalidContentLength
+			}
+			trimmed := bytes.TrimLeft(element, "0")
+			if len(trimmed) > maxContentLengthDigits {
+				return 0, ErrInvalidContentLength
+			}
+			n, err := strconv.ParseInt("0"+string(trimmed), 10, 64)
+			if err != nil {
+				return 0, ErrInvalidContentLength
+			}
+			if length > -1 && n != length {
+				return 0, ErrConflictingContentLength
+			}
+			length = n
+			elements++
+		}
+	}
+	if strict && elements > 1 {
+		return 0, ErrDuplicateContentLength
+	}
+	return length, nil
+}
+
+//setSingleHeader replaces the header fields with key by a single field with value, unless it is already the only one.
+//The position of the first field is kept.
+func setSingleHeader(data []byte, key string, values [][]byte, value string) []byte {
+	if len(values) == 1 && string(values[0]) == value {
+		return data
+	}
+	data = SetHeaderValue(data, key, value, 1)
+	for i := 1; i < len(values); i++ {
+		data = RemoveHeader(data, key, 2)
+	}
+	return data
+}
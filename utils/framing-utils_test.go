@@ -0,0 +1,99 @@
+package utils_test
+
+import (
+	"testing"
+
+	"github.com/digital-security-lab/hwl-proxy/utils"
+)
+
+func TestValidateRequestFraming(t *testing.T) {
+	valid := []struct {
+		request  string
+		strict   bool
+		expected string
+		framing  utils.Framing
+	}{
+		{"GET / HTTP/1.1\r\nHost: a\r\n\r\n", true, "GET / HTTP/1.1\r\nHost: a\r\n\r\n", utils.Framing{ContentLength: -1}},
+		{"POST / HTTP/1.1\r\nContent-Length: 5\r\nHost: a\r\n\r\n", true, "POST / HTTP/1.1\r\nContent-Length: 5\r\nHost: a\r\n\r\n", utils.Framing{ContentLength: 5}},
+		{"POST / HTTP/1.1\r\nContent-Length: 5\r\nHost: a\r\nContent-Length: 5\r\n\r\n", false, "POST / HTTP/1.1\r\nContent-Length: 5\r\nHost: a\r\n\r\n", utils.Framing{ContentLength: 5}},
+		{"POST / HTTP/1.1\r\nContent-Length: 5, 5\r\n\r\n", false, "POST / HTTP/1.1\r\nContent-Length: 5\r\n\r\n", utils.Framing{ContentLength: 5}},
+		{"POST / HTTP/1.1\r\nContent-Length: 005\r\n\r\n", false, "POST / HTTP/1.1\r\nContent-Length: 5\r\n\r\n", utils.Framing{ContentLength: 5}},
+		{"POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n", true, "POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n", utils.Framing{Chunked: true, ContentLength: -1}},
+		{"POST / HTTP/1.1\r\nTransfer-Encoding: gzip\r\nTransfer-Encoding: Chunked\r\n\r\n", true, "POST / HTTP/1.1\r\nTransfer-Encoding: gzip, chunked\r\n\r\n", utils.Framing{Chunked: true, ContentLength: -1}},
+		{"POST / HTTP/1.1\r\nContent-Length: 5\r\nTransfer-Encoding: chunked\r\n\r\n", false, "POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n", utils.Framing{Chunked: true, ContentLength: -1, Close: true}},
+		{"POST / HTTP/1.0\r\nTransfer-Encoding: chunked\r\n\r\n", false, "POST / HTTP/1.0\r\nTransfer-Encoding: chunked\r\n\r\n", utils.Framing{Chunked: true, ContentLength: -1, Close: true}},
+	}
+	for _, test := range valid {
+		data, framing, err := utils.ValidateRequestFraming([]byte(test.request), test.strict)
+		if err != nil || string(data) != test.expected || framing != test.framing {
+			t.Error("Invalid framing:", test.request, string(data), framing, err)
+		}
+	}
+
+	invalid := []struct {
+		request string
+		strict  bool
+		err     error
+	}{
+		{"POST / HTTP/1.1\r\nContent-Length: 5\r\nContent-Length: 6\r\n\r\n", false, utils.ErrConflictingContentLength},
+		{"POST / HTTP/1.1\r\nContent-Length: 5, 6\r\n\r\n", false, utils.ErrConflictingContentLength},
+		{"POST / HTTP/1.1\r\nContent-Length: 5\r\nContent-Length: 5\r\n\r\n", true, utils.ErrDuplicateContentLength},
+		{"POST / HTTP/1.1\r\nContent-Length: +5\r\n\r\n", false, utils.ErrInvalidContentLength},
+		{"POST / HTTP/1.1\r\nContent-Length: -1\r\n\r\n", false, utils.ErrInvalidContentLength},
+		{"POST / HTTP/1.1\r\nContent-Length: 5 5\r\n\r\n", false, utils.ErrInvalidContentLength},
+		{"POST / HTTP/1.1\r\nContent-Length: 0x5\r\n\r\n", false, utils.ErrInvalidContentLength},
+		{"POST / HTTP/1.1\r\nContent-Length: 005\r\n\r\n", true, utils.ErrInvalidContentLength},
+		{"POST / HTTP/1.1\r\nContent-Length: 99999999999999999999\r\n\r\n", false, utils.ErrInvalidContentLength},
+		{"POST / HTTP/1.1\r\nContent-Length : 5\r\n\r\n", false, utils.ErrInvalidFramingHeader},
+		{"POST / HTTP/1.1\r\nTransfer-Encoding: gzip\r\n chunked\r\n\r\n", false, utils.ErrInvalidFramingHeader},
+		{"POST / HTTP/1.1\r\nContent-Length: 5\r\nTransfer-Encoding: chunked\r\n\r\n", true, utils.ErrContentLengthAndTransferEncoding},
+		{"POST / HTTP/1.1\r\nTransfer-Encoding: chunked, gzip\r\n\r\n", false, utils.ErrChunkedNotFinal},
+		{"POST / HTTP/1.1\r\nTransfer-Encoding: chunked, chunked\r\n\r\n", false, utils.ErrChunkedNotFinal},
+		{"POST / HTTP/1.1\r\nTransfer-Encoding: gzip\r\n\r\n", false, utils.ErrChunkedNotFinal},
+		{"POST / HTTP/1.1\r\nTransfer-Encoding: xchunked\r\n\r\n", false, utils.ErrUnknownTransferCoding},
+		{"POST / HTTP/1.1\r\nTransfer-Encoding: identity\r\n\r\n", false, utils.ErrUnknownTransferCoding},
+		{"POST / HTTP/1.1\r\nTransfer-Encoding: \"chunked\"\r\n\r\n", false, utils.ErrInvalidTransferEncoding},
+		{"POST / HTTP/1.1\r\nTransfer-Encoding: chunked;x=1\r\n\r\n", false, utils.ErrInvalidTransferEncoding},
+		{"POST / HTTP/1.1\r\nTransfer-Encoding: ,\r\n\r\n", false, utils.ErrInvalidTransferEncoding},
+		{"POST / HTTP/1.0\r\nTransfer-Encoding: chunked\r\n\r\n", true, utils.ErrTransferEncodingHTTP10},
+	}
+	for _, test := range invalid {
+		_, _, err := utils.ValidateRequestFraming([]byte(test.request), test.strict)
+		if err != test.err {
+			t.Error("Unexpected error:", test.request, err, "Expected:", test.err)
+		}
+	}
+}
+
+func TestResponseFraming(t *testing.T) {
+	tests := []struct {
+		response string
+		method   string
+		framing  utils.Framing
+	}{
+		{"HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\n", "GET", utils.Framing{ContentLength: 5}},
+		{"HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\n", "HEAD", utils.Framing{ContentLength: 0}},
+		{"HTTP/1.1 204 No Content\r\n\r\n", "GET", utils.Framing{ContentLength: 0}},
+		{"HTTP/1.1 304 Not Modified\r\nTransfer-Encoding: chunked\r\n\r\n", "GET", utils.Framing{ContentLength: 0}},
+		{"HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\n\r\n", "GET", utils.Framing{ContentLength: 0, Close: true}},
+		{"HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n", "GET", utils.Framing{Chunked: true, ContentLength: -1}},
+		{"HTTP/1.1 200 OK\r\nTransfer-Encoding: gzip\r\n\r\n", "GET", utils.Framing{ContentLength: -1, Close: true}},
+		{"HTTP/1.1 200 OK\r\nServer: a\r\n\r\n", "GET", utils.Framing{ContentLength: -1, Close: true}},
+	}
+	for _, test := range tests {
+		_, framing, err := utils.ResponseFraming([]byte(test.response), test.method)
+		if err != nil || framing != test.framing {
+			t.Error("Invalid framing:", test.response, test.method, framing, err)
+		}
+	}
+
+	data, framing, err := utils.ResponseFraming([]byte("HTTP/1.1 200 OK\r\nContent-Length: 5\r\nTransfer-Encoding: chunked\r\n\r\n"), "GET")
+	if err != nil || !framing.Chunked || string(data) != "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n" {
+		t.Error("Content-Length not removed:", string(data), framing, err)
+	}
+
+	_, _, err = utils.ResponseFraming([]byte("HTTP/1.1 200 OK\r\nContent-Length: 5\r\nContent-Length: 6\r\n\r\n"), "GET")
+	if err != utils.ErrConflictingContentLength {
+		t.Error("Error not returned for conflicting Content-Length:", err)
+	}
+}
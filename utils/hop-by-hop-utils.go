@@ -0,0 +1,115 @@
+package utils
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+)
+
+// header fields that only apply to a single connection, besides the fields nominated in Connection
+var hopByHopHeaders = []string{"Keep-Alive", "Proxy-Connection", "TE", "Upgrade"}
+
+//GetVersion returns the HTTP version of the start line of a request or response.
+func GetVersion(data []byte) string {
+	index := bytes.Index(data, []byte("\r\n"))
+	if index < 0 {
+		return ""
+	}
+	fields := strings.Split(string(data[:index]), " ")
+	if strings.HasPrefix(fields[0], "HTTP/") {
+		return fields[0]
+	}
+	return fields[len(fields)-1]
+}
+
+//ConnectionOptions returns the connection options of all Connection header fields in lower case.
+func ConnectionOptions(data []byte) []string {
+	var options []string
+	for _, value := range GetHeaderFieldValues(data, []byte("Connection")) {
+		for _, option := range strings.Split(string(value), ",") {
+			option = strings.ToLower(strings.Trim(option, " \t"))
+			if len(option) > 0 {
+				options = append(options, option)
+			}
+		}
+	}
+	return options
+}
+
+//IsPersistent checks whether the connection persists after a message according to RFC 9112, section 9.3.
+//HTTP/1.1 connections persist unless the close option is sent, HTTP/1.0 connections only with the keep-alive option.
+func IsPersistent(data []byte) bool {
+	keepAlive := false
+	for _, option := range ConnectionOptions(data) {
+		if option == "close" {
+			return false
+		}
+		keepAlive = keepAlive || option == "keep-alive"
+	}
+	return GetVersion(data) == "HTTP/1.1" || keepAlive
+}
+
+//NominatedHeaders returns the names of the header fields nominated in Connection, i.e. all options except close and keep-alive.
+func NominatedHeaders(data []byte) []string {
+	var names []string
+	for _, option := range ConnectionOptions(data) {
+		if option != "close" && option != "keep-alive" {
+			names = append(names, option)
+		}
+	}
+	return names
+}
+
+//RemoveHopByHopHeaders removes hop-by-hop header fields and the fields nominated in Connection.
+//Connection is reduced to the options close and keep-alive and removed if no option remains.
+//Nominated fields for which keep returns true are not removed.
+func RemoveHopByHopHeaders(data []byte, keep func(name string) bool) []byte {
+	for _, name := range NominatedHeaders(data) {
+		if keep == nil || !keep(name) {
+			data = removeHeaderField(data, name)
+		}
+	}
+	for _, name := range hopByHopHeaders {
+		data = removeHeaderField(data, name)
+	}
+	var options []string
+	for _, option := range ConnectionOptions(data) {
+		if option == "close" || option == "keep-alive" {
+			options = append(options, option)
+		}
+	}
+	if len(options) == 0 {
+		return removeHeaderField(data, "Connection")
+	}
+	values := GetHeaderFieldValues(data, []byte("Connection"))
+	return setSingleHeader(data, "Connection", values, strings.Join(options, ", "))
+}
+
+//SetConnectionOption replaces the Connection header fields by a single field with the option.
+func SetConnectionOption(data []byte, option string) []byte {
+	values := GetHeaderFieldValues(data, []byte("Connection"))
+	if len(values) == 0 {
+		return AddHeader(data, "Connection", option)
+	}
+	return setSingleHeader(data, "Connection", values, option)
+}
+
+//removeHeaderField removes all header fields with the name, which is matched literally.
+func removeHeaderField(data []byte, name string) []byte {
+	if !hasHeaderField(data, name) || !tokenRegexp.MatchString(name) {
+		return data
+	}
+	return RemoveHeader(data, regexp.QuoteMeta(name), 0)
+}
+
+//hasHeaderField checks whether a header field with the name is present, without validating the field lines.
+func hasHeaderField(data []byte, name string) bool {
+	header := bytes.SplitN(data, []byte("\r\n\r\n"), 2)[0]
+	for _, line := range bytes.Split(header, []byte("\r\n"))[1:] {
+		index := bytes.IndexByte(line, ':')
+		if index > -1 && bytes.EqualFold(line[:index], []byte(name)) {
+			return true
+		}
+	}
+	return false
+}
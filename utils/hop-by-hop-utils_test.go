@@ -0,0 +1,60 @@
+package utils_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/digital-security-lab/hwl-proxy/utils"
+)
+
+func TestIsPersistent(t *testing.T) {
+	persistent := map[string]bool{
+		"GET / HTTP/1.1\r\nHost: a\r\n\r\n":                                      true,
+		"GET / HTTP/1.1\r\nConnection: close\r\n\r\n":                            false,
+		"GET / HTTP/1.1\r\nConnection: Keep-Alive, Close\r\n\r\n":                false,
+		"GET / HTTP/1.0\r\nHost: a\r\n\r\n":                                      false,
+		"GET / HTTP/1.0\r\nConnection: keep-alive\r\n\r\n":                       true,
+		"HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n":                           true,
+		"HTTP/1.1 200 OK\r\nConnection: close\r\n\r\n":                           false,
+		"HTTP/1.0 200 OK\r\nContent-Length: 0\r\n\r\n":                           false,
+		"HTTP/1.0 200 OK\r\nConnection: keep-alive\r\nContent-Length: 0\r\n\r\n": true,
+	}
+	for message, expected := range persistent {
+		if utils.IsPersistent([]byte(message)) != expected {
+			t.Error("Wrong persistence:", message)
+		}
+	}
+}
+
+func TestRemoveHopByHopHeaders(t *testing.T) {
+	data := []byte("GET / HTTP/1.1\r\nHost: a\r\nConnection: keep-alive, X-Hop, Authorization\r\nKeep-Alive: timeout=5\r\nX-Hop: 1\r\n" +
+		"TE: trailers\r\nProxy-Connection: keep-alive\r\nUpgrade: websocket\r\nAuthorization: Basic YQ==\r\n\r\n")
+	keep := func(name string) bool {
+		return strings.EqualFold(name, "Authorization")
+	}
+	expected := "GET / HTTP/1.1\r\nHost: a\r\nConnection: keep-alive\r\nAuthorization: Basic YQ==\r\n\r\n"
+	if result := utils.RemoveHopByHopHeaders(data, keep); string(result) != expected {
+		t.Error("Invalid headers:", string(result))
+	}
+
+	data = []byte("GET / HTTP/1.1\r\nHost: a\r\nConnection: X-Hop\r\nX-Hop: 1\r\n\r\n")
+	if result := utils.RemoveHopByHopHeaders(data, nil); string(result) != "GET / HTTP/1.1\r\nHost: a\r\n\r\n" {
+		t.Error("Invalid headers:", string(result))
+	}
+
+	data = []byte("GET / HTTP/1.1\r\nConnection: close\r\nConnection: keep-alive\r\n\r\n")
+	if result := utils.RemoveHopByHopHeaders(data, nil); string(result) != "GET / HTTP/1.1\r\nConnection: close, keep-alive\r\n\r\n" {
+		t.Error("Invalid headers:", string(result))
+	}
+}
+
+func TestSetConnectionOption(t *testing.T) {
+	data := utils.SetConnectionOption([]byte("HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n"), "close")
+	if string(data) != "HTTP/1.1 200 OK\r\nContent-Length: 0\r\nConnection: close\r\n\r\n" {
+		t.Error("Invalid headers:", string(data))
+	}
+	data = utils.SetConnectionOption([]byte("HTTP/1.1 200 OK\r\nConnection: keep-alive\r\nContent-Length: 0\r\n\r\n"), "close")
+	if string(data) != "HTTP/1.1 200 OK\r\nConnection: close\r\nContent-Length: 0\r\n\r\n" {
+		t.Error("Invalid headers:", string(data))
+	}
+}
@@ -12,11 +12,15 @@ const (
 	regexToken        = `(\x21|[\x23-\x27]|\x2a|\x2b|\x2d|\x2e|[\x5e-\x60]|\x7c|\x7e|[\x30-\x39]|[\x41-\x5a]|[\x61-\x7a])`
 	regexRequestLine  = `(GET|HEAD|POST|PUT|DELETE|CONNECT|OPTIONS|TRACE)\x20[\x21-\x7F]+\x20HTTP/\d[.]\d(\r\n){1}`
 	regexResponseLine = `HTTP/\d[.]\d\x20\d\d\d\x20.+(\r\n){1}`
+	regexStatusOnly   = `HTTP/\d[.]\d\x20\d\d\d\x20[^\r\n]*\r\n\r\n`
 	regexHeaderLines  = `((.+)(\r\n)?)+`
 	regexValidHeader  = `^((` + regexToken + `+:((\x09|\x20)?([\x21-\xFF]))*(\x09|\x20)?))$`
 	regexHeaderEnd    = `(\r\n\r\n){1}`
 )
 
+// compiled once, as header fields are validated for every header line
+var validHeaderRegexp = regexp.MustCompile(regexValidHeader)
+
 //CreateResponse returns a full http response with custom response code and body.
 //The content-length header is set dynamically matching the body length.
 func CreateResponse(code int, message string, body []byte) []byte {
@@ -31,14 +35,57 @@ func IsRequest(data []byte) bool {
 	return re.Match(data)
 }
 
+//IsResponse checks whether a data array has a valid http response format. Responses may have no header fields, e.g. 204 No Content.
 func IsResponse(data []byte) bool {
 	re, _ := regexp.Compile(regexResponseLine + regexHeaderLines + regexHeaderEnd)
+	if re.Match(data) {
+		return true
+	}
+	re, _ = regexp.Compile(`^` + regexStatusOnly + `$`)
 	return re.Match(data)
 }
 
+//GetStatusCode returns the status code of a response or -1 if the status line is invalid.
+func GetStatusCode(data []byte) int {
+	fields := bytes.SplitN(data, []byte(" "), 3)
+	if len(fields) < 2 || len(fields[1]) != 3 {
+		return -1
+	}
+	code, err := strconv.Atoi(string(fields[1]))
+	if err != nil {
+		return -1
+	}
+	return code
+}
+
+//IsInterimResponse checks whether a response is an informational (1xx) response other than 101 Switching Protocols.
+func IsInterimResponse(data []byte) bool {
+	code := GetStatusCode(data)
+	return code >= 100 && code < 200 && code != 101
+}
+
+//IsChunked checks whether the body of a message is transferred with chunked encoding, i.e. chunked is the final transfer coding.
+func IsChunked(data []byte) bool {
+	transferEncoding := GetHeaderFieldValues(data, []byte("Transfer-Encoding"))
+	if len(transferEncoding) == 0 {
+		return false
+	}
+	codings := bytes.Split(transferEncoding[len(transferEncoding)-1], []byte(","))
+	return bytes.EqualFold(bytes.Trim(codings[len(codings)-1], " \t"), []byte("chunked"))
+}
+
+//HasBody checks whether the headers of a request announce a body.
+//Invalid Content-Length values are considered to announce a body.
+func HasBody(data []byte) bool {
+	if IsChunked(data) {
+		return true
+	}
+	length, err := parseContentLength(GetHeaderFieldValues(data, []byte("Content-Length")), false)
+	return err != nil || length > 0
+}
+
 func IsValidHeader(data []byte) bool {
-	re, _ := regexp.Compile(regexValidHeader)
-	return re.Match(data)
+	return validHeaderRegexp.Match(data)
 }
 
 func GetHeaderFieldName(headerLine []byte) []byte {
@@ -147,8 +194,7 @@ func RemoveHeader(data []byte, key string, index int) []byte {
 //The data parameter must contain the previously received headers.
 func ReadHTTPBody(br *bufio.Reader, data []byte, modifyHeaders bool) ([]byte, error) {
 	contentLength := GetHeaderFieldValues(data, []byte("Content-Length"))
-	transferEncoding := GetHeaderFieldValues(data, []byte("Transfer-Encoding"))
-	if len(transferEncoding) > 0 && bytes.Equal(transferEncoding[0], []byte("chunked")) {
+	if IsChunked(data) {
 		if modifyHeaders {
 			data = RemoveHeader(data, "Content-Length", 0)
 		}
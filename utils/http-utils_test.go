@@ -81,6 +81,16 @@ func TestIsResponse(t *testing.T) {
 		t.Error("Wrong response validation")
 	}
 
+	responseBytes = []byte("HTTP/1.1 204 No Content\r\n\r\n")
+	if utils.IsResponse(responseBytes) == false {
+		t.Error("Wrong response validation")
+	}
+
+	responseBytes = []byte("HTTP/1.1 204 No\rContent\r\n\r\n")
+	if utils.IsResponse(responseBytes) == true {
+		t.Error("Wrong response validation")
+	}
+
 	responseBytes = []byte("HTTP/1.1 200 OK\rHost: example.com\r\n\r\n")
 	if utils.IsResponse(responseBytes) == true {
 		t.Error("Wrong response validation")
@@ -205,3 +215,19 @@ func TestGetHeaderValue(t *testing.T) {
 		t.Error("Header not found", string(requestBytes), "X-Test")
 	}
 }
+
+func TestHasBody(t *testing.T) {
+	var tests = map[string]bool{
+		"POST / HTTP/1.1\r\nHost: a\r\n\r\n":                               false,
+		"POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 0\r\n\r\n":          false,
+		"POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 00\r\n\r\n":         false,
+		"POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 4\r\n\r\n":          true,
+		"POST / HTTP/1.1\r\nHost: a\r\nContent-Length: x\r\n\r\n":          true,
+		"POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n": true,
+	}
+	for request, expected := range tests {
+		if utils.HasBody([]byte(request)) != expected {
+			t.Errorf("%q: expected %v", request, expected)
+		}
+	}
+}
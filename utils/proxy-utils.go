@@ -0,0 +1,138 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"net/url"
+	"strings"
+)
+
+//GetRequestLine returns the method, request target and HTTP version of the request line.
+//Empty strings are returned if the request line has not the expected format.
+func GetRequestLine(data []byte) (string, string, string) {
+	index := bytes.Index(data, []byte("\r\n"))
+	if index < 0 {
+		return "", "", ""
+	}
+	parts := strings.Split(string(data[:index]), " ")
+	if len(parts) != 3 || len(parts[0]) == 0 || len(parts[1]) == 0 || len(parts[2]) == 0 {
+		return "", "", ""
+	}
+	return parts[0], parts[1], parts[2]
+}
+
+//SetRequestTarget replaces the request target of the request line.
+func SetRequestTarget(data []byte, target string) []byte {
+	method, _, version := GetRequestLine(data)
+	index := bytes.Index(data, []byte("\r\n"))
+	if len(method) == 0 || index < 0 {
+		return data
+	}
+	result := []byte(method + " " + target + " " + version)
+	return append(result, data[index:]...)
+}
+
+//ParseAbsoluteTarget splits an absolute-form request target into the address to dial,
+//the value of the Host header and the origin-form of the target.
+func ParseAbsoluteTarget(target string) (string, string, string, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", "", "", err
+	}
+	if !strings.EqualFold(u.Scheme, "http") {
+		return "", "", "", errors.New("unsupported scheme: " + u.Scheme)
+	}
+	if len(u.Hostname()) == 0 || u.User != nil {
+		return "", "", "", errors.New("invalid authority: " + u.Host)
+	}
+	port := u.Port()
+	if len(port) == 0 {
+		port = "80"
+	}
+	return net.JoinHostPort(u.Hostname(), port), u.Host, u.RequestURI(), nil
+}
+
+//ParseAuthorityTarget validates an authority-form request target (host:port) as used by CONNECT.
+func ParseAuthorityTarget(target string) (string, error) {
+	host, port, err := net.SplitHostPort(target)
+	if err != nil {
+		return "", err
+	}
+	if len(host) == 0 || len(port) == 0 || strings.ContainsAny(target, "/?#@") {
+		return "", errors.New("invalid authority: " + target)
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+//MatchDestination checks whether an address (host:port) matches one of the patterns.
+//A pattern consists of a host and an optional port ("*" or omitted for any port).
+//The host may be a name, a wildcard for subdomains ("*.example.com"), an IP address or a CIDR range.
+//CIDR ranges only match IP literals, host names are not resolved.
+func MatchDestination(patterns []string, address string) bool {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	ip := net.ParseIP(host)
+	for _, pattern := range patterns {
+		patternHost, patternPort, err := net.SplitHostPort(pattern)
+		if err != nil {
+			patternHost, patternPort = pattern, "*"
+		}
+		if patternPort != "*" && patternPort != port {
+			continue
+		}
+		patternHost = strings.TrimSuffix(strings.ToLower(patternHost), ".")
+		if _, network, err := net.ParseCIDR(patternHost); err == nil {
+			if ip != nil && network.Contains(ip) {
+				return true
+			}
+		} else if strings.HasPrefix(patternHost, "*.") {
+			if strings.HasSuffix(host, patternHost[1:]) {
+				return true
+			}
+		} else if patternIP := net.ParseIP(patternHost); patternIP != nil {
+			if ip != nil && patternIP.Equal(ip) {
+				return true
+			}
+		} else if patternHost == host {
+			return true
+		}
+	}
+	return false
+}
+
+//GetIP returns the IP address of a network address or nil, e.g. for Unix domain sockets.
+func GetIP(addr net.Addr) net.IP {
+	if addr == nil {
+		return nil
+	}
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		return tcpAddr.IP
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		host = addr.String()
+	}
+	return net.ParseIP(host)
+}
+
+//MatchIP checks whether the IP address of a network address equals one of the IP addresses or is within one of the CIDR ranges.
+func MatchIP(networks []string, addr net.Addr) bool {
+	ip := GetIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, network := range networks {
+		if _, cidr, err := net.ParseCIDR(network); err == nil {
+			if cidr.Contains(ip) {
+				return true
+			}
+		} else if networkIP := net.ParseIP(network); networkIP != nil && networkIP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
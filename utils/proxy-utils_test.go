@@ -0,0 +1,103 @@
+package utils_test
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/digital-security-lab/hwl-proxy/utils"
+)
+
+func TestGetRequestLine(t *testing.T) {
+	method, target, version := utils.GetRequestLine([]byte("CONNECT example.com:443 HTTP/1.1\r\nHost: example.com\r\n\r\n"))
+	if method != "CONNECT" || target != "example.com:443" || version != "HTTP/1.1" {
+		t.Error("Invalid request line:", method, target, version)
+	}
+
+	method, _, _ = utils.GetRequestLine([]byte("GET  HTTP/1.1\r\n\r\n"))
+	if method != "" {
+		t.Error("Invalid request line accepted")
+	}
+}
+
+func TestSetRequestTarget(t *testing.T) {
+	data := []byte("GET http://example.com/index?a=1 HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	result := utils.SetRequestTarget(data, "/index?a=1")
+	if bytes.Equal(result, []byte("GET /index?a=1 HTTP/1.1\r\nHost: example.com\r\n\r\n")) == false {
+		t.Error("Invalid request target:", string(result))
+	}
+}
+
+func TestParseAbsoluteTarget(t *testing.T) {
+	address, host, originForm, err := utils.ParseAbsoluteTarget("http://example.com/index?a=1")
+	if err != nil || address != "example.com:80" || host != "example.com" || originForm != "/index?a=1" {
+		t.Error("Invalid target:", address, host, originForm, err)
+	}
+
+	address, host, originForm, err = utils.ParseAbsoluteTarget("http://[::1]:8080")
+	if err != nil || address != "[::1]:8080" || host != "[::1]:8080" || originForm != "/" {
+		t.Error("Invalid target:", address, host, originForm, err)
+	}
+
+	for _, target := range []string{"/index", "https://example.com/", "http://user:pw@example.com/", "http:///index"} {
+		_, _, _, err = utils.ParseAbsoluteTarget(target)
+		if err == nil {
+			t.Error("Invalid target accepted:", target)
+		}
+	}
+}
+
+func TestParseAuthorityTarget(t *testing.T) {
+	address, err := utils.ParseAuthorityTarget("example.com:443")
+	if err != nil || address != "example.com:443" {
+		t.Error("Invalid authority:", address, err)
+	}
+
+	for _, target := range []string{"example.com", "example.com:", "http://example.com:443", "user@example.com:443"} {
+		_, err = utils.ParseAuthorityTarget(target)
+		if err == nil {
+			t.Error("Invalid authority accepted:", target)
+		}
+	}
+}
+
+func TestMatchDestination(t *testing.T) {
+	patterns := []string{"example.com:443", "*.internal", "10.0.0.0/8:80", "[::1]:8080"}
+	allowed := []string{"example.com:443", "EXAMPLE.com.:443", "api.internal:1234", "10.1.2.3:80", "[::1]:8080"}
+	denied := []string{"example.com:80", "internal:80", "evilinternal:80", "10.1.2.3:443", "11.0.0.1:80", "[::1]:80", "example.com"}
+
+	for _, address := range allowed {
+		if !utils.MatchDestination(patterns, address) {
+			t.Error("Destination not allowed:", address)
+		}
+	}
+	for _, address := range denied {
+		if utils.MatchDestination(patterns, address) {
+			t.Error("Destination allowed:", address)
+		}
+	}
+	if utils.MatchDestination(nil, "example.com:443") {
+		t.Error("Destination allowed without patterns")
+	}
+}
+
+func TestMatchIP(t *testing.T) {
+	networks := []string{"10.0.0.0/8", "192.0.2.1", "2001:db8::/32"}
+	matching := []string{"10.1.2.3:80", "192.0.2.1:1234", "[2001:db8::1]:443"}
+	for _, address := range matching {
+		addr, _ := net.ResolveTCPAddr("tcp", address)
+		if !utils.MatchIP(networks, addr) {
+			t.Error("Address not matched:", address)
+		}
+	}
+	other := []string{"11.0.0.1:80", "192.0.2.2:1234", "[2001:db9::1]:443"}
+	for _, address := range other {
+		addr, _ := net.ResolveTCPAddr("tcp", address)
+		if utils.MatchIP(networks, addr) {
+			t.Error("Address matched:", address)
+		}
+	}
+	if utils.MatchIP(networks, &net.UnixAddr{Name: "/run/a.sock", Net: "unix"}) {
+		t.Error("Unix address matched")
+	}
+}
@@ -3,13 +3,41 @@ package utils
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"io"
-	"log"
 	"net"
 	"strconv"
-	"strings"
+	"sync"
 )
 
+// size of the buffers used to stream message bodies
+const streamBufferSize = 32 * 1024
+
+var ErrStartLineTooLong = errors.New("request line too long")
+var ErrFieldLineTooLong = errors.New("header field line too long")
+var ErrTooManyFields = errors.New("too many header fields")
+var ErrHeaderTooLarge = errors.New("header section too large")
+
+//HeaderLimits limits a message header while it is read. Limits of 0 are not enforced.
+type HeaderLimits struct {
+	StartLine int // length of the request or status line in bytes
+	FieldLine int // length of a header field line in bytes
+	Fields    int // number of header field lines
+	Size      int // size of the whole header including the start line in bytes
+}
+
+var streamBufferPool = sync.Pool{
+	New: func() interface{} {
+		return make([]byte, streamBufferSize)
+	},
+}
+
+//FlushWriter is a writer that buffers data until Flush is called.
+type FlushWriter interface {
+	io.Writer
+	Flush() error
+}
+
 //ReadUntilBytes reads from a stream until the occurance of the delimiter.
 func ReadUntilBytes(br *bufio.Reader, delim []byte) ([]byte, error) {
 	var data []byte
@@ -25,66 +53,200 @@ func ReadUntilBytes(br *bufio.Reader, delim []byte) ([]byte, error) {
 	}
 }
 
+//ReadHeader reads a message header from a stream until the empty line terminating it.
+//The limits are enforced while reading, so a header exceeding them is never buffered completely.
+func ReadHeader(br *bufio.Reader, limits HeaderLimits) ([]byte, error) {
+	var data []byte
+	lineStart := 0
+	lines := 0
+	for {
+		fragment, err := br.ReadSlice('\n')
+		data = append(data, fragment...)
+		lineLength := len(bytes.TrimRight(data[lineStart:], "\r\n"))
+		if lines == 0 && limits.StartLine > 0 && lineLength > limits.StartLine {
+			return nil, ErrStartLineTooLong
+		}
+		if lines > 0 && limits.FieldLine > 0 && lineLength > limits.FieldLine {
+			return nil, ErrFieldLineTooLong
+		}
+		if limits.Size > 0 && len(data) > limits.Size {
+			return nil, ErrHeaderTooLarge
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if bytes.HasSuffix(data, []byte("\r\n\r\n")) {
+			return data, nil
+		}
+		lines++
+		lineStart = len(data)
+		if limits.Fields > 0 && lines > limits.Fields+1 {
+			return nil, ErrTooManyFields
+		}
+	}
+}
+
 //ReadChunks reads from a stream expecting a chunked encoded http body.
+//The body is returned as received, including chunk extensions and trailer fields.
 func ReadChunks(reader *bufio.Reader) ([]byte, error) {
 	var data []byte
+	for {
+		line, err := readLine(reader, maxChunkLineSize)
+		if err != nil {
+			return nil, err
+		}
+		num, _, err := ParseChunkSize(line)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, line...)
+		if num == 0 {
+			break
+		}
+		// read chunk followed by CRLF
+		buf, err := ReadByContentLength(reader, int(num)+2)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.HasSuffix(buf, []byte("\r\n")) {
+			return nil, ErrInvalidChunk
+		}
+		data = append(data, buf...)
+	}
+	fields, err := ReadTrailers(reader)
+	if err != nil {
+		return nil, err
+	}
+	return append(data, joinFields(fields)...), nil
+}
 
-	line, err := ReadUntilBytes(reader, []byte("\r\n"))
-	if err == nil {
-		num, err := strconv.Atoi(strings.TrimSpace(string(line)))
-		if err == nil {
-			data = append(data, line...)
-			for num != 0 {
-				// read chunk
-				buf := make([]byte, num)
-				_, err := io.ReadFull(reader, buf)
-				if err != nil {
-					return nil, err
-				}
-				data = append(data, buf...)
-				line, err := ReadUntilBytes(reader, []byte("\r\n"))
-				data = append(data, line...)
-				// check next chunk size
-				line, err = ReadUntilBytes(reader, []byte("\r\n"))
-				if err == nil {
-					num, err = strconv.Atoi(strings.TrimSpace(string(line)))
-					if err != nil {
-						return nil, err
-					}
-					data = append(data, line...)
-				}
+//ReadByContentLength reads from a stream expecting a fixed size.
+//Memory is allocated as data arrives, not in advance for the announced size.
+func ReadByContentLength(reader *bufio.Reader, n int) ([]byte, error) {
+	var buf bytes.Buffer
+	_, err := io.CopyN(&buf, reader, int64(n))
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return buf.Bytes(), err
+}
+
+//flushIfIdle sends the buffered data of the writer if the reader has no buffered data left,
+//so data is not held back while waiting for the next read.
+func flushIfIdle(dst FlushWriter, src *bufio.Reader) error {
+	if src.Buffered() == 0 {
+		return dst.Flush()
+	}
+	return nil
+}
+
+//CopyByContentLength streams n bytes from the reader to the writer using a bounded buffer.
+//It returns the number of bytes copied.
+func CopyByContentLength(dst FlushWriter, src *bufio.Reader, n int64) (int64, error) {
+	buf := streamBufferPool.Get().([]byte)
+	defer streamBufferPool.Put(buf)
+	var copied int64
+	for copied < n {
+		size := int64(len(buf))
+		if n-copied < size {
+			size = n - copied
+		}
+		length, err := src.Read(buf[:size])
+		if length > 0 {
+			_, writeErr := dst.Write(buf[:length])
+			if writeErr != nil {
+				return copied, writeErr
 			}
-			line, err = ReadUntilBytes(reader, []byte("\r\n"))
-			if err != nil {
-				return nil, err
+			copied += int64(length)
+		}
+		if err != nil {
+			if err == io.EOF && copied < n {
+				err = io.ErrUnexpectedEOF
 			}
-			data = append(data, line...)
-			return data, nil
+			return copied, err
+		}
+		err = flushIfIdle(dst, src)
+		if err != nil {
+			return copied, err
 		}
-		return data, err
 	}
-	return data, err
+	return copied, nil
 }
 
-//ReadByContentLength reads from a stream expecting a fixed size.
-func ReadByContentLength(reader *bufio.Reader, n int) ([]byte, error) {
-	buf := make([]byte, n)
-	_, err := io.ReadFull(reader, buf)
-	if err != nil {
-		return buf, err
+//CopyHTTPBody streams the body announced by the headers in data from the reader to the writer.
+//Chunked bodies are re-encoded according to the options. It returns the number of payload bytes copied.
+func CopyHTTPBody(dst FlushWriter, src *bufio.Reader, data []byte, options ChunkOptions) (int64, error) {
+	contentLength := GetHeaderFieldValues(data, []byte("Content-Length"))
+	if IsChunked(data) {
+		return CopyChunks(dst, src, options)
+	} else if len(contentLength) > 0 {
+		clNum, err := strconv.ParseInt(string(contentLength[0]), 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		return CopyByContentLength(dst, src, clNum)
+	}
+	return 0, nil
+}
+
+//CopyBody streams a body with the given framing from the reader to the writer.
+//Chunked bodies are re-encoded according to the options. It returns the number of payload bytes copied.
+func CopyBody(dst FlushWriter, src *bufio.Reader, framing Framing, options ChunkOptions) (int64, error) {
+	if framing.Chunked {
+		return CopyChunks(dst, src, options)
+	} else if framing.IsCloseDelimited() {
+		return CopyUntilClose(dst, src)
+	} else if framing.ContentLength > 0 {
+		return CopyByContentLength(dst, src, framing.ContentLength)
 	}
-	return buf, nil
+	return 0, nil
 }
 
-//Tunnel reads incoming data from one connection and forwards to another connection
-func Tunnel(connIn net.Conn, connOut net.Conn) {
+//CopyUntilClose streams data from the reader to the writer until the connection is closed.
+//It returns the number of bytes copied.
+func CopyUntilClose(dst FlushWriter, src *bufio.Reader) (int64, error) {
+	buf := streamBufferPool.Get().([]byte)
+	defer streamBufferPool.Put(buf)
+	var copied int64
+	for {
+		length, err := src.Read(buf)
+		if length > 0 {
+			_, writeErr := dst.Write(buf[:length])
+			if writeErr != nil {
+				return copied, writeErr
+			}
+			copied += int64(length)
+		}
+		if err == io.EOF {
+			return copied, nil
+		}
+		if err != nil {
+			return copied, err
+		}
+		err = flushIfIdle(dst, src)
+		if err != nil {
+			return copied, err
+		}
+	}
+}
+
+//Tunnel reads incoming data from one connection and forwards to another connection until one of them ends.
+//Connections ending by EOF, by being closed or by an idle timeout are the normal end of a tunnel, for which nil is returned.
+func Tunnel(connIn net.Conn, connOut net.Conn) error {
 	buf := make([]byte, 4096)
 	for {
 		length, err := connIn.Read(buf)
+		if err == nil {
+			_, err = connOut.Write(buf[:length])
+		}
+		if err == io.EOF || IsClosed(err) || IsTimeout(err) {
+			return nil
+		}
 		if err != nil {
-			log.Println(err)
-			return
+			return err
 		}
-		connOut.Write(buf[:length])
 	}
 }
@@ -3,9 +3,12 @@ package utils_test
 import (
 	"bufio"
 	"bytes"
+	"io"
+	"io/ioutil"
 	"net"
 	"strconv"
 	"testing"
+	"time"
 
 	"github.com/digital-security-lab/hwl-proxy/utils"
 )
@@ -29,10 +32,52 @@ func TestReadUntilBytes(t *testing.T) {
 	}
 }
 
+func TestReadHeader(t *testing.T) {
+	limits := utils.HeaderLimits{StartLine: 20, FieldLine: 10, Fields: 2, Size: 40}
+	var tests = []struct {
+		data   string
+		header string
+		err    error
+	}{
+		{"GET / HTTP/1.1\r\nHost: a\r\nX: 1\r\n\r\nnext", "GET / HTTP/1.1\r\nHost: a\r\nX: 1\r\n\r\n", nil},
+		{"GET /very/long/target HTTP/1.1\r\n\r\n", "", utils.ErrStartLineTooLong},
+		{"GET / HTTP/1.1\r\nHost: example.com\r\n\r\n", "", utils.ErrFieldLineTooLong},
+		{"GET / HTTP/1.1\r\nA: 1\r\nB: 2\r\nC: 3\r\n\r\n", "", utils.ErrTooManyFields},
+		{"GET / HTTP/1.1\r\nHost: abcd\r\nX-Abc: 12\r\n\r\n", "", utils.ErrHeaderTooLarge},
+		{"GET / HTTP/1.1\r\n", "", io.EOF},
+	}
+	for _, test := range tests {
+		header, err := utils.ReadHeader(bufio.NewReader(bytes.NewBufferString(test.data)), limits)
+		if string(header) != test.header || err != test.err {
+			t.Errorf("%q: got %q, %v, expected %q, %v", test.data, header, err, test.header, test.err)
+		}
+	}
+
+	// an endless header is rejected once it exceeds the size limit
+	limits = utils.HeaderLimits{Size: 1024}
+	endless := bufio.NewReader(io.MultiReader(bytes.NewBufferString("GET / HTTP/1.1\r\n"), repeatReader("X: 1\r\n")))
+	_, err := utils.ReadHeader(endless, limits)
+	if err != utils.ErrHeaderTooLarge {
+		t.Error("Endless header not rejected:", err)
+	}
+}
+
+//repeatReader endlessly returns the same data.
+type repeatReader string
+
+func (r repeatReader) Read(b []byte) (int, error) {
+	n := 0
+	for n < len(b) {
+		n += copy(b[n:], r)
+	}
+	return n, nil
+}
+
 func TestReadChunks(t *testing.T) {
 	chunk_a := []byte("0\r\n\r\n")
 	chunk_b := []byte("1\r\na\r\n")
-	chunk_c := []byte("10\r\nabcdefghij\r\n")
+	chunk_c := []byte("a\r\nabcdefghij\r\n")
+	chunk_d := []byte("10\r\nabcdefghijklmnop\r\n")
 
 	buf := bytes.NewBuffer(chunk_a)
 	br := bufio.NewReader(buf)
@@ -63,6 +108,16 @@ func TestReadChunks(t *testing.T) {
 	if bytes.Equal(data, append(chunk_c, append(chunk_b, chunk_a...)...)) == false {
 		t.Error("Read:", string(data), "Expected:", string(append(chunk_c, append(chunk_b, chunk_a...)...)))
 	}
+
+	buf = bytes.NewBuffer(append(chunk_d, chunk_a...))
+	br = bufio.NewReader(buf)
+	data, err = utils.ReadChunks(br)
+	if err != nil {
+		t.Error("Error returned")
+	}
+	if bytes.Equal(data, append(chunk_d, chunk_a...)) == false {
+		t.Error("Read:", string(data), "Expected:", string(append(chunk_d, chunk_a...)))
+	}
 }
 
 func TestReadByContentLength(t *testing.T) {
@@ -104,3 +159,137 @@ func TestTunnel(t *testing.T) {
 	connOut.Close()
 	connIn.Close()
 }
+
+func TestTunnelEnd(t *testing.T) {
+	client, connIn := net.Pipe()
+	connOut, upstream := net.Pipe()
+	done := make(chan error)
+	go func() {
+		done <- utils.Tunnel(connIn, connOut)
+	}()
+	go ioutil.ReadAll(upstream)
+	client.Write([]byte("data"))
+	client.Close()
+	if err := <-done; err != nil {
+		t.Error("Error returned for a closed connection:", err)
+	}
+
+	idle, peer := net.Pipe()
+	defer peer.Close()
+	idle.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	if err := utils.Tunnel(idle, connOut); err != nil {
+		t.Error("Error returned for an idle timeout:", err)
+	}
+	upstream.Close()
+}
+
+type flushRecorder struct {
+	bytes.Buffer
+	flushed chan string
+}
+
+func (recorder *flushRecorder) Flush() error {
+	if recorder.Len() == 0 {
+		return nil
+	}
+	recorder.flushed <- recorder.String()
+	recorder.Reset()
+	return nil
+}
+
+func TestCopyByContentLength(t *testing.T) {
+	recorder := &flushRecorder{flushed: make(chan string, 10)}
+	br := bufio.NewReader(bytes.NewBufferString("abcdefghijklmnopqrstuvw"))
+	length, err := utils.CopyByContentLength(recorder, br, 5)
+	if err != nil || length != 5 || recorder.String() != "abcde" {
+		t.Error("Invalid copy:", recorder.String(), length, err)
+	}
+
+	recorder = &flushRecorder{flushed: make(chan string, 10)}
+	br = bufio.NewReader(bytes.NewBufferString("abc"))
+	length, err = utils.CopyByContentLength(recorder, br, 5)
+	if err == nil || length != 3 {
+		t.Error("Error not returned for a truncated body", length)
+	}
+}
+
+func TestCopyChunks(t *testing.T) {
+	chunks := "1\r\na\r\n3\r\nabc\r\n0\r\n\r\n"
+	recorder := &flushRecorder{flushed: make(chan string, 10)}
+	br := bufio.NewReader(bytes.NewBufferString(chunks + "GET / HTTP/1.1\r\n"))
+	length, err := utils.CopyChunks(recorder, br, utils.ChunkOptions{})
+	if err != nil || length != 4 || recorder.String() != chunks {
+		t.Error("Invalid copy:", recorder.String(), length, err)
+	}
+
+	br = bufio.NewReader(bytes.NewBufferString("3\r\nab"))
+	_, err = utils.CopyChunks(&flushRecorder{flushed: make(chan string, 10)}, br, utils.ChunkOptions{})
+	if err == nil {
+		t.Error("Error not returned for a truncated chunk")
+	}
+}
+
+func TestCopyChunksFlushesEachChunk(t *testing.T) {
+	connIn, connOut := net.Pipe()
+	defer connIn.Close()
+	defer connOut.Close()
+	recorder := &flushRecorder{flushed: make(chan string, 10)}
+	done := make(chan error)
+	go func() {
+		_, err := utils.CopyChunks(recorder, bufio.NewReader(connIn), utils.ChunkOptions{})
+		done <- err
+	}()
+
+	// each chunk is flushed before the next one was sent
+	for _, chunk := range []string{"5\r\nfirst\r\n", "6\r\nsecond\r\n"} {
+		connOut.Write([]byte(chunk))
+		select {
+		case flushed := <-recorder.flushed:
+			if flushed != chunk {
+				t.Error("Flushed:", flushed, "Expected:", chunk)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Chunk not flushed:", chunk)
+		}
+	}
+	connOut.Write([]byte("0\r\n\r\n"))
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCopyHTTPBody(t *testing.T) {
+	recorder := &flushRecorder{flushed: make(chan string, 10)}
+	br := bufio.NewReader(bytes.NewBufferString("abcdefghijklmnopqrstuvw"))
+	length, err := utils.CopyHTTPBody(recorder, br, []byte("POST / HTTP/1.1\r\nContent-Length: 4\r\n\r\n"), utils.ChunkOptions{})
+	if err != nil || length != 4 || recorder.String() != "abcd" {
+		t.Error("Invalid copy:", recorder.String(), length, err)
+	}
+
+	length, err = utils.CopyHTTPBody(recorder, br, []byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"), utils.ChunkOptions{})
+	if err != nil || length != 0 {
+		t.Error("Body copied without framing headers:", length, err)
+	}
+}
+
+func TestCopyBody(t *testing.T) {
+	recorder := &flushRecorder{flushed: make(chan string, 10)}
+	br := bufio.NewReader(bytes.NewBufferString("abcdefgh"))
+	length, err := utils.CopyBody(recorder, br, utils.Framing{ContentLength: 0}, utils.ChunkOptions{})
+	if err != nil || length != 0 {
+		t.Error("Body copied without length:", length, err)
+	}
+
+	length, err = utils.CopyBody(recorder, br, utils.Framing{ContentLength: 3}, utils.ChunkOptions{})
+	if err != nil || length != 3 || recorder.String() != "abc" {
+		t.Error("Invalid copy:", recorder.String(), length, err)
+	}
+
+	// bodies delimited by the end of the connection are copied until EOF
+	recorder = &flushRecorder{flushed: make(chan string, 10)}
+	length, err = utils.CopyBody(recorder, br, utils.Framing{ContentLength: -1, Close: true}, utils.ChunkOptions{})
+	flushed := <-recorder.flushed
+	if err != nil || length != 5 || flushed != "defgh" {
+		t.Error("Invalid copy:", flushed, length, err)
+	}
+}
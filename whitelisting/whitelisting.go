@@ -10,28 +10,52 @@ import (
 )
 
 type WhitelistItem struct {
-	Key string // header key
-	Val string // value as regex
+	Key    string // header key
+	Val    string // value as regex
+	Redact bool   // values of header fields with this key are redacted in the audit log
 }
 
 type Whitelist []WhitelistItem
 
+// reasons why a header field line is removed or a request is rejected
+const (
+	ReasonNotWhitelisted = "not-whitelisted" // no whitelist item has the field name as key
+	ReasonValueMismatch  = "value-mismatch"  // the value does not match the value regex of the item with the field name as key
+	ReasonDuplicate      = "duplicate"       // the matching item already matched a previous line
+	ReasonInvalidSyntax  = "invalid-syntax"  // the line is no valid header field, so the request is rejected
+)
+
+//Removal describes a header field line removed by the whitelist.
+type Removal struct {
+	Line   []byte // header field line
+	Reason string // one of the Reason constants
+	Rule   int    // index of the whitelist item with the field name as key, -1 if none
+}
+
 //Load reads the whitelist from an according JSON file.
 func (wl *Whitelist) Load(file string) error {
 	data, err := ioutil.ReadFile(file)
 	if err != nil {
 		return err
 	}
-	err = json.Unmarshal(data, wl)
-	return err
+	return wl.Parse(data)
+}
+
+//Parse reads the whitelist from JSON data.
+func (wl *Whitelist) Parse(data []byte) error {
+	return json.Unmarshal(data, wl)
 }
 
 //Apply modifies the request data, so only whitelisted headers are preserved.
 //It is assumed that the first line is the request line and is therefore ignored.
 //The first byte array returned is the request line and all whitelisted headers. The second array contains the headers that are not whitelisted.
 func (wl *Whitelist) Apply(data []byte) ([]byte, []byte, bool) {
-	var err error
-	var re *regexp.Regexp
+	whitelisted, nonWhitelisted, _, ok := wl.ApplyMatches(data)
+	return whitelisted, nonWhitelisted, ok
+}
+
+//ApplyMatches works like Apply and additionally returns which whitelist items matched a header line, by their index.
+func (wl *Whitelist) ApplyMatches(data []byte) ([]byte, []byte, []bool, bool) {
 	var whitelisted, nonWhitelisted []byte
 	lines := bytes.Split(data, []byte("\r\n"))
 
@@ -46,24 +70,10 @@ func (wl *Whitelist) Apply(data []byte) ([]byte, []byte, bool) {
 		if i != 0 && len(line) > 0 {
 			// return without results if invalid syntax is detected
 			if !utils.IsValidHeader(line) && len(line) > 0 {
-				return nil, nil, false
-			}
-			match := false
-			for j, wlItem := range *wl {
-				if len(wlItem.Val) > 0 {
-					re, err = regexp.Compile(`^((((?i)` + wlItem.Key + `):(\x09|\x20)?` + wlItem.Val + `(\x09|\x20)?){1})$`)
-				} else {
-					re, err = regexp.Compile(`^(((((?i)` + wlItem.Key + `):((\x09|\x20)?([\x21-\xFF]))*(\x09|\x20)?)){1})$`)
-				}
-
-				if wlHeaderOccurance[j] == false && (err == nil && re.Match(line)) {
-					match = true
-					wlHeaderOccurance[j] = true
-					break
-				}
+				return nil, nil, nil, false
 			}
 
-			if match {
+			if wl.match(line, wlHeaderOccurance) {
 				whitelisted = append(whitelisted, line...)
 				whitelisted = append(whitelisted, []byte("\r\n")...)
 			} else {
@@ -73,7 +83,94 @@ func (wl *Whitelist) Apply(data []byte) ([]byte, []byte, bool) {
 		}
 	}
 	whitelisted = append(whitelisted, []byte("\r\n")...)
-	return whitelisted, nonWhitelisted, true
+	return whitelisted, nonWhitelisted, wlHeaderOccurance, true
+}
+
+//Explain returns the header field lines of a request that Apply removes and the reasons.
+//If Apply rejects the request due to invalid syntax, it returns false and the invalid line as the only removal.
+func (wl *Whitelist) Explain(data []byte) ([]Removal, bool) {
+	var removals []Removal
+	lines := bytes.Split(data, []byte("\r\n"))
+	wlHeaderOccurance := make([]bool, len(*wl))
+	for i, line := range lines {
+		if i == 0 || len(line) == 0 {
+			continue
+		}
+		if !utils.IsValidHeader(line) {
+			return []Removal{{Line: line, Reason: ReasonInvalidSyntax, Rule: -1}}, false
+		}
+		if wl.match(line, wlHeaderOccurance) {
+			continue
+		}
+		removal := Removal{Line: line, Reason: ReasonNotWhitelisted, Rule: -1}
+		name := string(utils.GetHeaderFieldName(line))
+		for j, wlItem := range *wl {
+			if !wlItem.matchesKey(name) {
+				continue
+			}
+			re, err := wlItem.lineRegexp()
+			if err == nil && re.Match(line) {
+				// only a duplicate line matches an item that already matched
+				removal.Reason = ReasonDuplicate
+				removal.Rule = j
+				break
+			}
+			if removal.Rule < 0 {
+				removal.Reason = ReasonValueMismatch
+				removal.Rule = j
+			}
+		}
+		removals = append(removals, removal)
+	}
+	return removals, true
+}
+
+//ApplyFields returns the whitelisted fields of a field section without start line, e.g. the trailer section of a chunked body.
+func (wl *Whitelist) ApplyFields(fields [][]byte) [][]byte {
+	var whitelisted [][]byte
+	wlHeaderOccurance := make([]bool, len(*wl))
+	for _, field := range fields {
+		if utils.IsValidHeader(field) && wl.match(field, wlHeaderOccurance) {
+			whitelisted = append(whitelisted, field)
+		}
+	}
+	return whitelisted
+}
+
+//Contains checks whether a header field name is whitelisted, regardless of its value.
+func (wl *Whitelist) Contains(name string) bool {
+	for _, wlItem := range *wl {
+		if wlItem.matchesKey(name) {
+			return true
+		}
+	}
+	return false
+}
+
+//matchesKey checks whether a header field name matches the key of the item.
+func (wlItem WhitelistItem) matchesKey(name string) bool {
+	re, err := regexp.Compile(`^((?i)` + wlItem.Key + `)$`)
+	return err == nil && re.MatchString(name)
+}
+
+//lineRegexp compiles the regular expression of header field lines matching the item.
+func (wlItem WhitelistItem) lineRegexp() (*regexp.Regexp, error) {
+	if len(wlItem.Val) > 0 {
+		return regexp.Compile(`^((((?i)` + wlItem.Key + `):(\x09|\x20)?` + wlItem.Val + `(\x09|\x20)?){1})$`)
+	}
+	return regexp.Compile(`^(((((?i)` + wlItem.Key + `):((\x09|\x20)?([\x21-\xFF]))*(\x09|\x20)?)){1})$`)
+}
+
+//match checks whether a header line matches a whitelist item, that did not match a previous line.
+func (wl *Whitelist) match(line []byte, wlHeaderOccurance []bool) bool {
+	for j, wlItem := range *wl {
+		re, err := wlItem.lineRegexp()
+		if wlHeaderOccurance[j] == false && (err == nil && re.Match(line)) {
+			wlHeaderOccurance[j] = true
+			return true
+		}
+	}
+	return false
 }
 
 //JoinHeaders adds headers to the original message,
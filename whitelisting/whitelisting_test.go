@@ -75,6 +75,23 @@ func TestRequestHeaderWhitelistingInvalidHeaders(t *testing.T) {
 
 }
 
+func TestWhitelistMatches(t *testing.T) {
+	requestBytes := []byte("GET / HTTP/1.1\r\nHost: example.com\r\nCookie: c1\r\nCookie: c2\r\nX-Test: example\r\n\r\n")
+	_, nonWhitelisted, matches, ok := whitelistDefault.ApplyMatches(requestBytes)
+	if !ok || !bytes.Equal(nonWhitelisted, []byte("Cookie: c2\r\nX-Test: example\r\n")) {
+		t.Error("Invalid non whitelisted return value", "("+string(nonWhitelisted)+")")
+	}
+	expected := []bool{true, false, false, false, true}
+	if len(matches) != len(expected) {
+		t.Fatal("Invalid number of matches:", len(matches))
+	}
+	for i := range expected {
+		if matches[i] != expected[i] {
+			t.Error("Invalid match of whitelist item", i)
+		}
+	}
+}
+
 func TestJoinHeaders(t *testing.T) {
 	requestBytes := []byte("GET /index.html HTTP/1.1\r\nHost: example.com\r\n\r\n")
 	result := whitelisting.JoinHeaders(requestBytes, []byte("X-Test: example\r\n"))
@@ -105,3 +122,62 @@ func TestJoinHeadersHeaderOverwriting(t *testing.T) {
 		t.Error("Concat headers failed", string(result))
 	}
 }
+
+func TestTrailerWhitelisting(t *testing.T) {
+	trailerWhitelist := whitelisting.Whitelist{
+		whitelisting.WhitelistItem{Key: "checksum", Val: `[a-z0-9]+`},
+	}
+	fields := [][]byte{
+		[]byte("Checksum: abc123"),
+		[]byte("Checksum: def456"),
+		[]byte("X-Internal: 1"),
+	}
+	whitelisted := trailerWhitelist.ApplyFields(fields)
+	if len(whitelisted) != 1 || !bytes.Equal(whitelisted[0], fields[0]) {
+		t.Error("Invalid trailer fields:", whitelisted)
+	}
+
+	var emptyWhitelist whitelisting.Whitelist
+	if len(emptyWhitelist.ApplyFields(fields)) != 0 {
+		t.Error("Trailer fields forwarded without whitelist")
+	}
+}
+
+func TestWhitelistContains(t *testing.T) {
+	if !whitelistDefault.Contains("Cookie") || !whitelistDefault.Contains("content-length") {
+		t.Error("Whitelisted header field not found")
+	}
+	if whitelistDefault.Contains("Authorization") || whitelistDefault.Contains("cookies") {
+		t.Error("Header field not whitelisted found")
+	}
+}
+
+func TestExplainWhitelisting(t *testing.T) {
+	requestBytes := []byte("GET / HTTP/1.1\r\nHost: example.com\r\nX-Test: example\r\nConnection: upgrade\r\nCookie: c1\r\nCookie: c2\r\n\r\n")
+	removals, ok := whitelistDefault.Explain(requestBytes)
+	expected := []whitelisting.Removal{
+		{Line: []byte("X-Test: example"), Reason: whitelisting.ReasonNotWhitelisted, Rule: -1},
+		{Line: []byte("Connection: upgrade"), Reason: whitelisting.ReasonValueMismatch, Rule: 1},
+		{Line: []byte("Cookie: c2"), Reason: whitelisting.ReasonDuplicate, Rule: 4},
+	}
+	if !ok || len(removals) != len(expected) {
+		t.Fatal("Unexpected removals:", removals, ok)
+	}
+	for i, removal := range removals {
+		if !bytes.Equal(removal.Line, expected[i].Line) || removal.Reason != expected[i].Reason || removal.Rule != expected[i].Rule {
+			t.Errorf("Unexpected removal %s (%s, %d), expected %s (%s, %d)", removal.Line, removal.Reason, removal.Rule,
+				expected[i].Line, expected[i].Reason, expected[i].Rule)
+		}
+	}
+
+	// the removals match the fields removed by Apply
+	_, nonWhitelisted, _ := whitelistDefault.Apply(requestBytes)
+	if !bytes.Equal(nonWhitelisted, []byte("X-Test: example\r\nConnection: upgrade\r\nCookie: c2\r\n")) {
+		t.Error("Removals differ from Apply:", string(nonWhitelisted))
+	}
+
+	removals, ok = whitelistDefault.Explain([]byte("GET / HTTP/1.1\r\nHost\r: example.com\r\n\r\n"))
+	if ok || len(removals) != 1 || removals[0].Reason != whitelisting.ReasonInvalidSyntax {
+		t.Error("Invalid header field not explained:", removals, ok)
+	}
+}